@@ -0,0 +1,128 @@
+package blocky
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const backupTimeFormat = "20060102-150405"
+
+// ErrBackupNotFound is returned for an unknown or malformed backup ID.
+var ErrBackupNotFound = errors.New("backup not found")
+
+// backupID matches the suffix of "config.yml.bak.<id>": a timestamp and,
+// for several backups within one second, a sequence number.
+var backupID = regexp.MustCompile(`^\d{8}-\d{6}(\.\d+)?$`)
+
+// Backup is a saved copy of the config, identified by the suffix of its
+// file name.
+type Backup struct {
+	ID   string    `json:"id"`
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+}
+
+// RetentionPolicy decides which backups PruneBackups removes. Keep is the
+// number of newest backups to keep and MaxAge the age after which they are
+// removed; zero or negative values disable either limit.
+type RetentionPolicy struct {
+	Keep   int
+	MaxAge time.Duration
+}
+
+func backupPath(configPath, id string) string {
+	return configPath + ".bak." + id
+}
+
+// ListBackups returns the backups of the config at configPath, newest first.
+func ListBackups(configPath string) ([]Backup, error) {
+	matches, err := filepath.Glob(backupPath(configPath, "*"))
+	if err != nil {
+		return nil, err
+	}
+	prefix := filepath.Base(configPath) + ".bak."
+	backups := make([]Backup, 0, len(matches))
+	for _, path := range matches {
+		id := strings.TrimPrefix(filepath.Base(path), prefix)
+		if !backupID.MatchString(id) {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		ts, _ := time.ParseInLocation(backupTimeFormat, id[:len(backupTimeFormat)], time.Local)
+		backups = append(backups, Backup{ID: id, Name: filepath.Base(path), Time: ts, Size: info.Size()})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		if !backups[i].Time.Equal(backups[j].Time) {
+			return backups[i].Time.After(backups[j].Time)
+		}
+		return backupSeq(backups[i].ID) > backupSeq(backups[j].ID)
+	})
+	return backups, nil
+}
+
+func backupSeq(id string) int {
+	var n int
+	if _, seq, ok := strings.Cut(id, "."); ok {
+		fmt.Sscanf(seq, "%d", &n)
+	}
+	return n
+}
+
+// ReadBackup returns the contents of a backup.
+func ReadBackup(configPath, id string) ([]byte, error) {
+	if !backupID.MatchString(id) {
+		return nil, ErrBackupNotFound
+	}
+	data, err := os.ReadFile(backupPath(configPath, id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBackupNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read backup: %w", err)
+	}
+	return data, nil
+}
+
+// RestoreBackup replaces the config with a backup through
+// WriteConfigIfMatch: the backup must pass validation, the config must
+// still match ifMatch, and the current config is backed up first.
+func RestoreBackup(configPath, id, ifMatch string) (newBackup string, warnings []ConfigIssue, err error) {
+	data, err := ReadBackup(configPath, id)
+	if err != nil {
+		return "", nil, err
+	}
+	return WriteConfigIfMatch(configPath, data, ifMatch)
+}
+
+// PruneBackups removes the backups the policy no longer keeps and returns
+// their names.
+func PruneBackups(configPath string, policy RetentionPolicy) ([]string, error) {
+	backups, err := ListBackups(configPath)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	var errs []error
+	cutoff := time.Now().Add(-policy.MaxAge)
+	for i, b := range backups {
+		if (policy.Keep <= 0 || i < policy.Keep) && (policy.MaxAge <= 0 || b.Time.After(cutoff)) {
+			continue
+		}
+		if err := os.Remove(backupPath(configPath, b.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+			continue
+		}
+		removed = append(removed, b.Name)
+	}
+	return removed, errors.Join(errs...)
+}
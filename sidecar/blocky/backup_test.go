@@ -0,0 +1,117 @@
+package blocky
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeBackup(t *testing.T, configPath string, ts time.Time, content string) string {
+	t.Helper()
+	id := ts.Format(backupTimeFormat)
+	if err := os.WriteFile(backupPath(configPath, id), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestListAndRestoreBackups(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yml")
+	if err := os.WriteFile(path, []byte("log:\n  level: info\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	old := writeBackup(t, path, time.Now().Add(-time.Hour), "log:\n  level: debug\n")
+	os.WriteFile(filepath.Join(dir, "config.yml.bak.notes"), []byte("x"), 0644)
+
+	first, err := BackupConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := BackupConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Fatalf("two backups in one second share the name %s", first)
+	}
+
+	backups, err := ListBackups(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 3 {
+		t.Fatalf("backups = %+v, want 3", backups)
+	}
+	if backups[0].Name != filepath.Base(second) || backups[1].Name != filepath.Base(first) || backups[2].ID != old {
+		t.Errorf("order = %s, %s, %s", backups[0].Name, backups[1].Name, backups[2].Name)
+	}
+	if backups[2].Size != int64(len("log:\n  level: debug\n")) {
+		t.Errorf("size = %d", backups[2].Size)
+	}
+
+	for _, id := range []string{"../config.yml", "20990101-000000", ""} {
+		if _, err := ReadBackup(path, id); !errors.Is(err, ErrBackupNotFound) {
+			t.Errorf("ReadBackup(%q) error = %v, want ErrBackupNotFound", id, err)
+		}
+	}
+
+	if _, _, err := RestoreBackup(path, old, `"stale"`); !errors.Is(err, ErrConfigChanged) {
+		t.Errorf("restore with a stale ETag error = %v, want ErrConfigChanged", err)
+	}
+	current, _ := os.ReadFile(path)
+	made, _, err := RestoreBackup(path, old, ConfigETag(current))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "log:\n  level: debug\n" {
+		t.Errorf("restored config = %q", data)
+	}
+	if data, _ := os.ReadFile(made); string(data) != "log:\n  level: info\n" {
+		t.Errorf("backup made by restore = %q, want the previous config", data)
+	}
+	if info, _ := os.Stat(made); info.Mode().Perm() != 0600 {
+		t.Errorf("backup mode = %v, want the config's 0600", info.Mode().Perm())
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, ".config.yml.tmp-*")); len(leftovers) != 0 {
+		t.Errorf("temp files left behind: %v", leftovers)
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yml")
+	now := time.Now()
+	var ids []string
+	for _, age := range []time.Duration{time.Hour, 2 * time.Hour, 3 * 24 * time.Hour, 10 * 24 * time.Hour} {
+		ids = append(ids, writeBackup(t, path, now.Add(-age), "a: 1\n"))
+	}
+
+	removed, err := PruneBackups(path, RetentionPolicy{MaxAge: 7 * 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != "config.yml.bak."+ids[3] {
+		t.Errorf("removed by age = %v", removed)
+	}
+
+	removed, err = PruneBackups(path, RetentionPolicy{Keep: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != "config.yml.bak."+ids[2] {
+		t.Errorf("removed by count = %v", removed)
+	}
+
+	if removed, _ := PruneBackups(path, RetentionPolicy{}); len(removed) != 0 {
+		t.Errorf("removed without limits = %v", removed)
+	}
+	if backups, _ := ListBackups(path); len(backups) != 2 {
+		t.Errorf("%d backups left, want 2", len(backups))
+	}
+}
@@ -0,0 +1,256 @@
+package blocky
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// ErrInvalidClient wraps a client that CheckClient rejects.
+	ErrInvalidClient = errors.New("invalid client")
+	// ErrUnknownListGroup wraps a list group that is not defined in
+	// blocking.denylists.
+	ErrUnknownListGroup = errors.New("list group is not defined in blocking.denylists")
+)
+
+// defaultClient is the clientGroupsBlock key Blocky uses for clients that
+// get no groups from any other key.
+const defaultClient = "default"
+
+// ClientGroup is one entry of blocking.clientGroupsBlock: a client name
+// (wildcards allowed), IP or CIDR and the denylist groups that apply to it.
+type ClientGroup struct {
+	Client string   `json:"client"`
+	Groups []string `json:"groups"`
+}
+
+// ClientGroupMatch is the result of MatchClientGroups: the groups that
+// apply to a client and the entries they come from.
+type ClientGroupMatch struct {
+	Groups  []string      `json:"groups"`
+	Matches []ClientGroup `json:"matches"`
+	Default bool          `json:"default"`
+}
+
+// ClientGroups returns the entries of blocking.clientGroupsBlock in config
+// order.
+func ClientGroups(data []byte) ([]ClientGroup, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid YAML: %w", err)
+	}
+	clients := []ClientGroup{}
+	block := mappingValue(mappingValue(docRoot(&doc), "blocking"), "clientGroupsBlock")
+	if block == nil || block.Kind != yaml.MappingNode {
+		return clients, nil
+	}
+	for i := 0; i+1 < len(block.Content); i += 2 {
+		groups := []string{}
+		for _, g := range groupSources(resolve(block.Content[i+1])) {
+			if g = resolve(g); g.Kind == yaml.ScalarNode {
+				groups = append(groups, g.Value)
+			}
+		}
+		clients = append(clients, ClientGroup{Client: block.Content[i].Value, Groups: groups})
+	}
+	return clients, nil
+}
+
+// DenylistGroups returns the names of the groups in blocking.denylists, or
+// its deprecated name, in config order.
+func DenylistGroups(data []byte) ([]string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid YAML: %w", err)
+	}
+	names := []string{}
+	lists := listKindNode(mappingValue(docRoot(&doc), "blocking"), Denylist)
+	if lists != nil && lists.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(lists.Content); i += 2 {
+			names = append(names, lists.Content[i].Value)
+		}
+	}
+	return names, nil
+}
+
+// CheckClient accepts what Blocky matches clientGroupsBlock keys against:
+// an IP, a CIDR, or a client name with optional * and ? wildcards.
+func CheckClient(client string) error {
+	if _, err := netip.ParseAddr(client); err == nil {
+		return nil
+	}
+	if strings.Contains(client, "/") {
+		if _, err := netip.ParsePrefix(client); err != nil {
+			return fmt.Errorf("%w: bad CIDR %q", ErrInvalidClient, client)
+		}
+		return nil
+	}
+	if client == "" || strings.ContainsAny(client, " \t\n") {
+		return fmt.Errorf("%w: bad name %q", ErrInvalidClient, client)
+	}
+	if _, err := filepath.Match(client, ""); err != nil {
+		return fmt.Errorf("%w: bad pattern %q", ErrInvalidClient, client)
+	}
+	return nil
+}
+
+// SetClientGroups assigns a client to denylist groups in the config and
+// returns the new config. Nil groups remove the client. Blocky applies the
+// default entry to clients without any groups, so an empty list does not
+// turn blocking off; it behaves like no entry.
+func SetClientGroups(data []byte, client string, groups []string) ([]byte, error) {
+	if err := CheckClient(client); err != nil {
+		return nil, err
+	}
+	if groups != nil {
+		defined, err := DenylistGroups(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range groups {
+			if !contains(defined, g) {
+				return nil, fmt.Errorf("%w: %s", ErrUnknownListGroup, g)
+			}
+		}
+	}
+
+	return editSection(data, "blocking", func(blocking *yaml.Node) (*yaml.Node, error) {
+		if blocking == nil || isNull(blocking) {
+			if groups == nil {
+				return blocking, nil
+			}
+			blocking = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		}
+		if blocking.Kind != yaml.MappingNode {
+			return nil, errors.New("blocking is not a mapping")
+		}
+		bi := findKey(blocking, "clientGroupsBlock")
+		if bi < 0 {
+			if groups == nil {
+				return blocking, nil
+			}
+			blocking.Content = append(blocking.Content,
+				&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "clientGroupsBlock"},
+				&yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"})
+			bi = len(blocking.Content) - 2
+		}
+		block := blocking.Content[bi+1]
+		if isNull(block) {
+			block = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			blocking.Content[bi+1] = block
+		}
+		if block.Kind != yaml.MappingNode {
+			return nil, errors.New("blocking.clientGroupsBlock is not a mapping")
+		}
+
+		ci := findKey(block, client)
+		if groups == nil {
+			if ci >= 0 {
+				block.Content = append(block.Content[:ci], block.Content[ci+2:]...)
+				if len(block.Content) == 0 {
+					blocking.Content = append(blocking.Content[:bi], blocking.Content[bi+2:]...)
+				}
+			}
+			return blocking, nil
+		}
+
+		seq := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, g := range groups {
+			seq.Content = append(seq.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: g})
+		}
+		if ci >= 0 {
+			block.Content[ci+1] = replaceNode(block.Content[ci+1], seq)
+			return blocking, nil
+		}
+		// Write new entries like the existing ones.
+		for i := 1; i < len(block.Content); i += 2 {
+			if block.Content[i].Kind == yaml.SequenceNode {
+				seq.Style = block.Content[i].Style
+				break
+			}
+		}
+		if len(groups) == 0 {
+			seq.Style = yaml.FlowStyle
+		}
+		block.Content = append(block.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: client}, seq)
+		return blocking, nil
+	})
+}
+
+// MatchClientGroups returns the denylist groups Blocky applies to a client
+// with the given IP and names. Like Blocky, it collects the groups of every
+// entry whose key matches a client name (with wildcards, ignoring case),
+// equals the IP or is a CIDR containing it, and falls back to the default
+// entry if that yields no groups. Keys that Blocky resolves as host names to compare
+// IPs are only matched by name here.
+func MatchClientGroups(data []byte, ip string, names []string) (*ClientGroupMatch, error) {
+	clients, err := ClientGroups(data)
+	if err != nil {
+		return nil, err
+	}
+	addr, _ := netip.ParseAddr(ip)
+
+	m := &ClientGroupMatch{Groups: []string{}, Matches: []ClientGroup{}}
+	for _, c := range clients {
+		if clientMatches(c.Client, addr, names) {
+			m.Matches = append(m.Matches, c)
+		}
+	}
+	m.Groups = unionGroups(m.Matches)
+	if len(m.Groups) == 0 {
+		for _, c := range clients {
+			if c.Client == defaultClient {
+				m.Matches = append(m.Matches, c)
+				m.Groups = unionGroups([]ClientGroup{c})
+				m.Default = true
+			}
+		}
+	}
+	return m, nil
+}
+
+func unionGroups(clients []ClientGroup) []string {
+	groups := []string{}
+	for _, c := range clients {
+		for _, g := range c.Groups {
+			if !contains(groups, g) {
+				groups = append(groups, g)
+			}
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+func clientMatches(key string, addr netip.Addr, names []string) bool {
+	for _, name := range names {
+		if ok, _ := filepath.Match(strings.ToLower(key), strings.ToLower(name)); ok && name != "" {
+			return true
+		}
+	}
+	if !addr.IsValid() {
+		return false
+	}
+	if a, err := netip.ParseAddr(key); err == nil {
+		return a.Unmap() == addr.Unmap()
+	}
+	if p, err := netip.ParsePrefix(key); err == nil {
+		return p.Contains(addr.Unmap())
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
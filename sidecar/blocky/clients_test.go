@@ -0,0 +1,132 @@
+package blocky
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const clientsConfig = `blocking:
+  denylists:
+    ads:
+      - https://a.example/ads.txt
+    adult:
+      - https://a.example/adult.txt
+    social:
+      - https://a.example/social.txt
+  clientGroupsBlock:
+    default:
+      - ads
+    kid*:
+      - ads
+      - adult
+    192.168.1.0/24: # guest wifi
+      - social
+    192.168.1.7:
+      - adult
+`
+
+func TestMatchClientGroups(t *testing.T) {
+	tests := []struct {
+		ip      string
+		names   []string
+		groups  []string
+		clients []string
+		def     bool
+	}{
+		{"10.0.0.2", nil, []string{"ads"}, []string{"default"}, true},
+		{"10.0.0.2", []string{"KIDS-TABLET"}, []string{"ads", "adult"}, []string{"kid*"}, false},
+		{"192.168.1.20", nil, []string{"social"}, []string{"192.168.1.0/24"}, false},
+		{"192.168.1.7", []string{"kid-phone"}, []string{"ads", "adult", "social"}, []string{"kid*", "192.168.1.0/24", "192.168.1.7"}, false},
+		{"::ffff:192.168.1.7", nil, []string{"adult", "social"}, []string{"192.168.1.0/24", "192.168.1.7"}, false},
+	}
+	for _, tt := range tests {
+		m, err := MatchClientGroups([]byte(clientsConfig), tt.ip, tt.names)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var clients []string
+		for _, c := range m.Matches {
+			clients = append(clients, c.Client)
+		}
+		if !reflect.DeepEqual(m.Groups, tt.groups) || !reflect.DeepEqual(clients, tt.clients) || m.Default != tt.def {
+			t.Errorf("MatchClientGroups(%s, %v) = %v from %v (default %v), want %v from %v", tt.ip, tt.names, m.Groups, clients, m.Default, tt.groups, tt.clients)
+		}
+	}
+}
+
+func TestSetClientGroups(t *testing.T) {
+	out, err := SetClientGroups([]byte(clientsConfig), "192.168.1.0/24", []string{"ads", "social"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `blocking:
+  denylists:
+    ads:
+      - https://a.example/ads.txt
+    adult:
+      - https://a.example/adult.txt
+    social:
+      - https://a.example/social.txt
+  clientGroupsBlock:
+    default:
+      - ads
+    kid*:
+      - ads
+      - adult
+    192.168.1.0/24: # guest wifi
+      - ads
+      - social
+    192.168.1.7:
+      - adult
+`
+	if string(out) != want {
+		t.Errorf("move =\n%s\nwant\n%s", out, want)
+	}
+
+	out, err = SetClientGroups(out, "tv.lan", []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ClientGroups(out); len(got) != 5 || got[4].Client != "tv.lan" || len(got[4].Groups) != 0 {
+		t.Errorf("clients after adding tv.lan = %+v", got)
+	}
+	// Like Blocky, a client whose entries give it no groups gets the default.
+	m, err := MatchClientGroups(out, "10.0.0.3", []string{"tv.lan"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Default || !reflect.DeepEqual(m.Groups, []string{"ads"}) || len(m.Matches) != 2 || m.Matches[0].Client != "tv.lan" {
+		t.Errorf("match for an empty entry = %+v, want default groups [ads]", m)
+	}
+	// Without a default entry, nothing applies and nothing is claimed.
+	noDefault := []byte("blocking:\n  denylists:\n    ads: [a.txt]\n  clientGroupsBlock:\n    pc.lan: [ads]\n")
+	if m, err := MatchClientGroups(noDefault, "10.0.0.9", nil); err != nil || m.Default || len(m.Groups) != 0 {
+		t.Errorf("match without a default entry = %+v, %v; want no groups and no default", m, err)
+	}
+
+	out, err = SetClientGroups(out, "192.168.1.7", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := MatchClientGroups(out, "192.168.1.7", nil); !reflect.DeepEqual(m.Groups, []string{"ads", "social"}) {
+		t.Errorf("groups after removing 192.168.1.7 = %v", m.Groups)
+	}
+
+	if _, err := SetClientGroups(out, "tv.lan", []string{"games"}); !errors.Is(err, ErrUnknownListGroup) {
+		t.Errorf("unknown group error = %v, want ErrUnknownListGroup", err)
+	}
+	for _, client := range []string{"10.0.0.0/33", "my tv", "kid[", ""} {
+		if _, err := SetClientGroups(out, client, []string{"ads"}); !errors.Is(err, ErrInvalidClient) {
+			t.Errorf("SetClientGroups(%q) error = %v, want ErrInvalidClient", client, err)
+		}
+	}
+
+	out, err = SetClientGroups([]byte("blocking:\n  denylists:\n    ads: [x.txt]\n"), "10.0.0.9", []string{"ads"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "blocking:\n  denylists:\n    ads: [x.txt]\n  clientGroupsBlock:\n    10.0.0.9:\n      - ads\n"; string(out) != want {
+		t.Errorf("first entry =\n%s\nwant\n%s", out, want)
+	}
+}
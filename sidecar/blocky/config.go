@@ -1,13 +1,26 @@
 package blocky
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
-	"gopkg.in/yaml.v3"
+	"github.com/JCHHeilmann/blocky-visor/sidecar/internal/fsutil"
 )
 
+// ErrConfigChanged is returned by WriteConfigIfMatch when the config no
+// longer matches the ETag the caller read.
+var ErrConfigChanged = errors.New("config was changed since it was loaded")
+
+// writeMu serialises config writes so a conditional write cannot race
+// another save or restore.
+var writeMu sync.Mutex
+
 // ReadConfig reads the raw Blocky config file.
 func ReadConfig(path string) ([]byte, error) {
 	data, err := os.ReadFile(path)
@@ -17,42 +30,103 @@ func ReadConfig(path string) ([]byte, error) {
 	return data, nil
 }
 
-// ValidateYAML checks that data is valid YAML.
-func ValidateYAML(data []byte) error {
-	var out interface{}
-	if err := yaml.Unmarshal(data, &out); err != nil {
-		return fmt.Errorf("invalid YAML: %w", err)
+// ConfigETag returns a strong HTTP entity tag for the config contents.
+func ConfigETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// MatchETag reports whether an If-Match header value matches the config
+// contents. It accepts "*" and comma-separated lists.
+func MatchETag(header string, data []byte) bool {
+	etag := ConfigETag(data)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag {
+			return true
+		}
 	}
-	return nil
+	return false
 }
 
-// BackupConfig creates a timestamped backup of the config file.
+// BackupConfig creates a timestamped backup of the config file with the
+// same permission bits, so a backup is no more readable than the file.
+// Backups made within the same second get a sequence number.
 func BackupConfig(path string) (string, error) {
-	backupPath := fmt.Sprintf("%s.bak.%s", path, time.Now().Format("20060102-150405"))
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return "", fmt.Errorf("read for backup: %w", err)
 	}
-	if err := os.WriteFile(backupPath, data, 0644); err != nil {
-		return "", fmt.Errorf("write backup: %w", err)
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("read for backup: %w", err)
+	}
+	id := time.Now().Format(backupTimeFormat)
+	for seq := 1; ; seq++ {
+		backupPath := backupPath(path, id)
+		f, err := os.OpenFile(backupPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+		if errors.Is(err, os.ErrExist) {
+			id = fmt.Sprintf("%s.%d", time.Now().Format(backupTimeFormat), seq)
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("write backup: %w", err)
+		}
+		_, err = f.Write(data)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(backupPath)
+			return "", fmt.Errorf("write backup: %w", err)
+		}
+		return backupPath, nil
 	}
-	return backupPath, nil
 }
 
-// WriteConfig validates YAML, backs up existing config, and writes new config.
+// WriteConfig validates the config, backs up existing config, and writes new
+// config atomically. A config with schema errors is rejected with a
+// *ValidationError.
 func WriteConfig(path string, data []byte) (backupPath string, err error) {
-	if err := ValidateYAML(data); err != nil {
-		return "", err
+	backupPath, _, err = WriteConfigIfMatch(path, data, "*")
+	return backupPath, err
+}
+
+// WriteConfigIfMatch is WriteConfig for a caller that read the config with
+// the given If-Match value; it fails with ErrConfigChanged if the config
+// was written since. It returns the schema warnings of the written config.
+func WriteConfigIfMatch(path string, data []byte, ifMatch string) (backupPath string, warnings []ConfigIssue, err error) {
+	_, backupPath, warnings, err = ReplaceConfig(path, data, ifMatch)
+	return backupPath, warnings, err
+}
+
+// ReplaceConfig is WriteConfigIfMatch that also returns the config it
+// replaced, as read under the write lock.
+func ReplaceConfig(path string, data []byte, ifMatch string) (previous []byte, backupPath string, warnings []ConfigIssue, err error) {
+	issues := ValidateConfig(data)
+	if HasErrors(issues) {
+		return nil, "", nil, &ValidationError{Issues: issues}
+	}
+
+	writeMu.Lock()
+	defer writeMu.Unlock()
+
+	current, err := ReadConfig(path)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if !MatchETag(ifMatch, current) {
+		return nil, "", nil, ErrConfigChanged
 	}
 
 	backupPath, err = BackupConfig(path)
 	if err != nil {
-		return "", fmt.Errorf("backup failed: %w", err)
+		return nil, "", nil, fmt.Errorf("backup failed: %w", err)
 	}
 
-	if err := os.WriteFile(path, data, 0644); err != nil {
-		return backupPath, fmt.Errorf("write config: %w", err)
+	if err := fsutil.WriteFileAtomic(path, data, 0644); err != nil {
+		return nil, backupPath, nil, fmt.Errorf("write config: %w", err)
 	}
 
-	return backupPath, nil
+	return current, backupPath, issues, nil
 }
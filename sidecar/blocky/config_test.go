@@ -0,0 +1,72 @@
+package blocky
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteConfigIfMatch(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "blocky.yml")
+	if err := os.WriteFile(target, []byte("a: 1\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.yml")
+	if err := os.Symlink(target, path); err != nil {
+		t.Fatal(err)
+	}
+
+	stale := ConfigETag([]byte("a: 0\n"))
+	if _, _, err := WriteConfigIfMatch(path, []byte("a: 2\n"), stale); !errors.Is(err, ErrConfigChanged) {
+		t.Fatalf("write with stale ETag error = %v, want ErrConfigChanged", err)
+	}
+	if backups, _ := ListBackups(path); len(backups) != 0 {
+		t.Errorf("rejected write made %d backups", len(backups))
+	}
+
+	etag := ConfigETag([]byte("a: 1\n"))
+	if _, _, err := WriteConfigIfMatch(path, []byte("a: 2\n"), `"other", `+etag); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(target); string(data) != "a: 2\n" {
+		t.Errorf("target = %q, want the new config", data)
+	}
+	if info, err := os.Lstat(path); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("config symlink was replaced")
+	}
+	if info, _ := os.Stat(target); info.Mode().Perm() != 0640 {
+		t.Errorf("mode = %v, want 0640", info.Mode().Perm())
+	}
+	if _, _, err := WriteConfigIfMatch(path, []byte("a: 3\n"), etag); !errors.Is(err, ErrConfigChanged) {
+		t.Errorf("second write with the old ETag error = %v, want ErrConfigChanged", err)
+	}
+	if _, err := WriteConfig(path, []byte("a: 3\n")); err != nil {
+		t.Errorf("unconditional write: %v", err)
+	}
+	if previous, _, _, err := ReplaceConfig(path, []byte("a: 4\n"), "*"); err != nil || string(previous) != "a: 3\n" {
+		t.Errorf("ReplaceConfig previous = %q, %v; want the replaced config", previous, err)
+	}
+	var verr *ValidationError
+	if _, _, err := WriteConfigIfMatch(path, []byte("a: [\n"), "*"); !errors.As(err, &verr) {
+		t.Errorf("invalid YAML error = %v, want *ValidationError", err)
+	}
+}
+
+func TestMatchETag(t *testing.T) {
+	data := []byte("a: 1\n")
+	etag := ConfigETag(data)
+	for header, want := range map[string]bool{
+		etag:                  true,
+		"*":                   true,
+		`"x", ` + etag:        true,
+		`"x"`:                 false,
+		"W/" + etag:           false,
+		etag[1 : len(etag)-1]: false,
+	} {
+		if got := MatchETag(header, data); got != want {
+			t.Errorf("MatchETag(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
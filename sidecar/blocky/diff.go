@@ -0,0 +1,366 @@
+package blocky
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Change types of a ConfigChange.
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// ConfigChange is one semantic difference between two configs. Path uses
+// dots for keys and [i] for list items; Line is in the new config, or in
+// the old one for removals.
+type ConfigChange struct {
+	Path string `json:"path"`
+	Type string `json:"type"`
+	Old  any    `json:"old,omitempty"`
+	New  any    `json:"new,omitempty"`
+	Line int    `json:"line"`
+}
+
+// ConfigDiff compares two configs both line by line and by structure.
+type ConfigDiff struct {
+	Unified string         `json:"unified"`
+	Changes []ConfigChange `json:"changes"`
+}
+
+// DiffConfig compares the current config with a proposed one. The proposed
+// config must be valid YAML; the current one is compared as empty if it is
+// not.
+func DiffConfig(current, proposed []byte) (*ConfigDiff, error) {
+	var b yaml.Node
+	if err := yaml.Unmarshal(proposed, &b); err != nil {
+		return nil, fmt.Errorf("invalid YAML: %w", err)
+	}
+	var a yaml.Node
+	if err := yaml.Unmarshal(current, &a); err != nil {
+		a = yaml.Node{}
+	}
+
+	d := &ConfigDiff{
+		Unified: UnifiedDiff("config.yml", "config.yml (proposed)", current, proposed, 3),
+		Changes: []ConfigChange{},
+	}
+	d.Changes = diffNodes(d.Changes, "", docRoot(&a), docRoot(&b))
+	return d, nil
+}
+
+func docRoot(doc *yaml.Node) *yaml.Node {
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		return resolve(doc.Content[0])
+	}
+	return nil
+}
+
+func nodeValue(n *yaml.Node) any {
+	var v any
+	if n != nil {
+		n.Decode(&v)
+	}
+	return v
+}
+
+func diffNodes(changes []ConfigChange, path string, a, b *yaml.Node) []ConfigChange {
+	switch {
+	case a == nil && b == nil:
+		return changes
+	case a == nil:
+		return append(changes, ConfigChange{Path: path, Type: ChangeAdded, New: nodeValue(b), Line: b.Line})
+	case b == nil:
+		return append(changes, ConfigChange{Path: path, Type: ChangeRemoved, Old: nodeValue(a), Line: a.Line})
+	}
+	a, b = resolve(a), resolve(b)
+
+	switch {
+	case a.Kind == yaml.MappingNode && b.Kind == yaml.MappingNode:
+		av, bv := mappingEntries(a), mappingEntries(b)
+		for _, key := range av.keys {
+			changes = diffNodes(changes, joinPath(path, key), av.values[key], bv.values[key])
+		}
+		for _, key := range bv.keys {
+			if _, ok := av.values[key]; !ok {
+				changes = diffNodes(changes, joinPath(path, key), nil, bv.values[key])
+			}
+		}
+		return changes
+	case a.Kind == yaml.SequenceNode && b.Kind == yaml.SequenceNode:
+		if allScalars(a) && allScalars(b) {
+			return diffScalarLists(changes, path, a, b)
+		}
+		for i := range max(len(a.Content), len(b.Content)) {
+			var ai, bi *yaml.Node
+			if i < len(a.Content) {
+				ai = a.Content[i]
+			}
+			if i < len(b.Content) {
+				bi = b.Content[i]
+			}
+			changes = diffNodes(changes, fmt.Sprintf("%s[%d]", path, i), ai, bi)
+		}
+		return changes
+	case a.Kind == yaml.ScalarNode && b.Kind == yaml.ScalarNode:
+		if a.Value == b.Value && a.Tag == b.Tag {
+			return changes
+		}
+	}
+	return append(changes, ConfigChange{Path: path, Type: ChangeChanged, Old: nodeValue(a), New: nodeValue(b), Line: b.Line})
+}
+
+type entries struct {
+	keys   []string
+	values map[string]*yaml.Node
+}
+
+// mappingEntries lists the keys of a mapping in order, including merged
+// keys; later keys win as in YAML decoding.
+func mappingEntries(n *yaml.Node) entries {
+	e := entries{values: make(map[string]*yaml.Node)}
+	set := func(k, v *yaml.Node) {
+		if _, ok := e.values[k.Value]; !ok {
+			e.keys = append(e.keys, k.Value)
+		}
+		e.values[k.Value] = v
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := n.Content[i], n.Content[i+1]
+		if k.Tag == "!!merge" {
+			if m := resolve(v); m.Kind == yaml.MappingNode {
+				for j := 0; j+1 < len(m.Content); j += 2 {
+					set(m.Content[j], m.Content[j+1])
+				}
+			}
+			continue
+		}
+		set(k, v)
+	}
+	return e
+}
+
+func allScalars(n *yaml.Node) bool {
+	for _, c := range n.Content {
+		if resolve(c).Kind != yaml.ScalarNode {
+			return false
+		}
+	}
+	return true
+}
+
+// diffScalarLists reports inserted and deleted items, so adding one list
+// source does not show every following item as changed.
+func diffScalarLists(changes []ConfigChange, path string, a, b *yaml.Node) []ConfigChange {
+	as, bs := make([]string, len(a.Content)), make([]string, len(b.Content))
+	for i, c := range a.Content {
+		as[i] = resolve(c).Value
+	}
+	for i, c := range b.Content {
+		bs[i] = resolve(c).Value
+	}
+	for _, op := range diffLines(as, bs) {
+		switch op.kind {
+		case '-':
+			n := a.Content[op.a]
+			changes = append(changes, ConfigChange{Path: fmt.Sprintf("%s[%d]", path, op.a), Type: ChangeRemoved, Old: nodeValue(n), Line: n.Line})
+		case '+':
+			n := b.Content[op.b]
+			changes = append(changes, ConfigChange{Path: fmt.Sprintf("%s[%d]", path, op.b), Type: ChangeAdded, New: nodeValue(n), Line: n.Line})
+		}
+	}
+	return changes
+}
+
+// editOp is one line of an edit script: ' ' keeps a[a] (== b[b]), '-'
+// deletes a[a], '+' inserts b[b].
+type editOp struct {
+	kind byte
+	a, b int
+}
+
+// diffLines computes a shortest edit script from a to b with Myers'
+// algorithm.
+func diffLines(a, b []string) []editOp {
+	// Trim the common prefix and suffix, which is most of a config.
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	ma, mb := a[pre:len(a)-suf], b[pre:len(b)-suf]
+
+	mid := myers(ma, mb, pre)
+
+	ops := make([]editOp, 0, pre+len(mid)+suf)
+	for i := range pre {
+		ops = append(ops, editOp{' ', i, i})
+	}
+	for i := len(mid) - 1; i >= 0; i-- {
+		ops = append(ops, mid[i])
+	}
+	for i := range suf {
+		ops = append(ops, editOp{' ', len(a) - suf + i, len(b) - suf + i})
+	}
+	return ops
+}
+
+// maxEditDistance bounds the work of myers; beyond it the changed region is
+// reported as removed and re-added as a whole.
+const maxEditDistance = 2000
+
+// myers returns the edit script from a to b in reverse order, with indices
+// shifted by base.
+func myers(a, b []string, base int) []editOp {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	// trace[d] holds v[offset-d-1 : offset+d+2] before step d.
+	var trace [][]int
+	found := n == 0 && m == 0
+	for d := 0; d <= n+m && d <= maxEditDistance && !found; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[offset+k-1] < v[offset+k+1] {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+
+	var ops []editOp
+	if !found {
+		for j := m - 1; j >= 0; j-- {
+			ops = append(ops, editOp{'+', base + n, base + j})
+		}
+		for i := n - 1; i >= 0; i-- {
+			ops = append(ops, editOp{'-', base + i, base})
+		}
+		return ops
+	}
+
+	// Walk the trace backwards to recover the edits.
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0 && (x > 0 || y > 0); d-- {
+		vd := func(k int) int { return trace[d][k+d+1] }
+		k := x - y
+		var prevK int
+		if k == -d || k != d && vd(k-1) < vd(k+1) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := vd(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, editOp{' ', base + x, base + y})
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, editOp{'+', base + x, base + prevY})
+			} else {
+				ops = append(ops, editOp{'-', base + prevX, base + y})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	return ops
+}
+
+func splitLines(data []byte) []string {
+	s := string(data)
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// UnifiedDiff returns the differences between a and b in unified diff
+// format with the given lines of context, or "" if they are equal.
+func UnifiedDiff(aName, bName string, a, b []byte, context int) string {
+	al, bl := splitLines(a), splitLines(b)
+	ops := diffLines(al, bl)
+
+	var sb strings.Builder
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		// Extend the hunk while changes are within 2*context of each other.
+		start := max(i-context, 0)
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end = j
+			} else if j-end > 2*context {
+				break
+			}
+		}
+		end = min(end+context+1, len(ops))
+
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s\n+++ %s\n", aName, bName)
+		}
+		aStart, bStart, aCount, bCount := ops[start].a, ops[start].b, 0, 0
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+		for _, op := range ops[start:end] {
+			line := ""
+			switch op.kind {
+			case '+':
+				line = bl[op.b]
+			default:
+				line = al[op.a]
+			}
+			sb.WriteByte(op.kind)
+			sb.WriteString(line)
+			if !strings.HasSuffix(line, "\n") {
+				sb.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return sb.String()
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
@@ -0,0 +1,87 @@
+package blocky
+
+import (
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	a := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\n"
+	b := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\nn\n"
+	want := `--- a.txt
++++ b.txt
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -11,3 +11,4 @@
+ k
+ l
+ m
++n
+`
+	if got := UnifiedDiff("a.txt", "b.txt", []byte(a), []byte(b), 3); got != want {
+		t.Errorf("UnifiedDiff =\n%s\nwant\n%s", got, want)
+	}
+	if got := UnifiedDiff("a", "b", []byte(a), []byte(a), 3); got != "" {
+		t.Errorf("UnifiedDiff of equal input = %q, want empty", got)
+	}
+	if got := UnifiedDiff("a", "b", nil, []byte("x\n"), 3); got != "--- a\n+++ b\n@@ -0,0 +1 @@\n+x\n" {
+		t.Errorf("UnifiedDiff from empty = %q", got)
+	}
+}
+
+func TestDiffConfig(t *testing.T) {
+	current := `upstreams:
+  groups:
+    default: [1.1.1.1, 9.9.9.9]
+blocking:
+  denylists:
+    ads:
+      - https://a.example/list.txt
+      - https://b.example/list.txt
+  blockType: zeroIp
+caching:
+  prefetching: true
+`
+	proposed := `upstreams:
+  groups:
+    default: [1.1.1.1, 9.9.9.9]
+blocking:
+  denylists:
+    ads:
+      - https://new.example/list.txt
+      - https://a.example/list.txt
+      - https://b.example/list.txt
+  blockType: nxDomain
+  blockTTL: 1m
+`
+	d, err := DiffConfig([]byte(current), []byte(proposed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ConfigChange{
+		{Path: "blocking.denylists.ads[0]", Type: ChangeAdded, New: "https://new.example/list.txt", Line: 7},
+		{Path: "blocking.blockType", Type: ChangeChanged, Old: "zeroIp", New: "nxDomain", Line: 10},
+		{Path: "blocking.blockTTL", Type: ChangeAdded, New: "1m", Line: 11},
+		{Path: "caching", Type: ChangeRemoved, Old: map[string]any{"prefetching": true}, Line: 11},
+	}
+	if len(d.Changes) != len(want) {
+		t.Fatalf("changes = %+v, want %d", d.Changes, len(want))
+	}
+	for i, c := range d.Changes {
+		w := want[i]
+		if c.Path != w.Path || c.Type != w.Type || c.Line != w.Line || c.New != w.New && w.Type != ChangeRemoved {
+			t.Errorf("change %d = %+v, want %+v", i, c, w)
+		}
+	}
+	if d.Unified == "" {
+		t.Error("unified diff is empty")
+	}
+
+	if _, err := DiffConfig([]byte(current), []byte("a: [")); err == nil {
+		t.Error("DiffConfig accepted invalid YAML")
+	}
+}
@@ -0,0 +1,515 @@
+package blocky
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/JCHHeilmann/blocky-visor/sidecar/internal/fsutil"
+	"gopkg.in/yaml.v3"
+)
+
+// List kinds, named after their keys under "blocking".
+const (
+	Allowlist = "allowlists"
+	Denylist  = "denylists"
+)
+
+// legacyListKeys are the deprecated names of the list kinds, used when a
+// config has not been migrated yet.
+var legacyListKeys = map[string]string{Allowlist: "whiteLists", Denylist: "blackLists"}
+
+var (
+	// ErrInvalidEntry wraps a list entry that ValidateListEntry rejects.
+	ErrInvalidEntry = errors.New("invalid list entry")
+	// ErrEntryNotFound is returned when removing an entry that is not in
+	// the group.
+	ErrEntryNotFound = errors.New("entry not found")
+	// ErrListSourceNotFound is returned for a list file that the group does
+	// not reference.
+	ErrListSourceNotFound = errors.New("list file not found in group")
+)
+
+// Types of a ListSource.
+const (
+	SourceInline = "inline"
+	SourceFile   = "file"
+	SourceURL    = "url"
+)
+
+// ListSource is one source of a list group. Entries are filled in for
+// inline lists and readable list files.
+type ListSource struct {
+	Type    string   `json:"type"`
+	Source  string   `json:"source,omitempty"`
+	Path    string   `json:"path,omitempty"`
+	Entries []string `json:"entries,omitempty"`
+}
+
+// ListEdit describes a change to one list source. File is the file that
+// was written, which is the config for inline lists.
+type ListEdit struct {
+	Changed bool   `json:"changed"`
+	Source  string `json:"source"`
+	File    string `json:"-"`
+	Backup  string `json:"backup,omitempty"`
+}
+
+func checkListKind(kind string) error {
+	if kind != Allowlist && kind != Denylist {
+		return fmt.Errorf("unknown list kind %q", kind)
+	}
+	return nil
+}
+
+// listKindNode returns the mapping of groups for kind under blocking,
+// falling back to its deprecated name.
+func listKindNode(blocking *yaml.Node, kind string) *yaml.Node {
+	if n := mappingValue(blocking, kind); n != nil {
+		return n
+	}
+	return mappingValue(blocking, legacyListKeys[kind])
+}
+
+// groupSources returns the sources of a group, which may be a single
+// source or a list. Items of a list are returned as they are, so aliases
+// are not resolved.
+func groupSources(group *yaml.Node) []*yaml.Node {
+	if group == nil || isNull(group) {
+		return nil
+	}
+	switch group.Kind {
+	case yaml.ScalarNode:
+		return []*yaml.Node{group}
+	case yaml.SequenceNode:
+		return append([]*yaml.Node(nil), group.Content...)
+	}
+	return nil
+}
+
+func isInline(source string) bool {
+	return strings.Contains(source, "\n")
+}
+
+// inlineSource reports whether n is an inline list that can be edited in
+// place.
+func inlineSource(n *yaml.Node) bool {
+	return n.Kind == yaml.ScalarNode && isInline(n.Value)
+}
+
+// listFilePath returns the local file of a list source, resolving relative
+// paths against the config's directory, or "" for URLs and inline lists.
+func listFilePath(configPath, source string) string {
+	source = strings.TrimSpace(source)
+	if isInline(source) {
+		return ""
+	}
+	if rest, ok := strings.CutPrefix(source, "file://"); ok {
+		source = rest
+	} else if strings.Contains(source, "://") {
+		return ""
+	}
+	if !filepath.IsAbs(source) {
+		source = filepath.Join(filepath.Dir(configPath), source)
+	}
+	return filepath.Clean(source)
+}
+
+// listEntries returns the entries of a list, without blank lines and
+// comments.
+func listEntries(text string) []string {
+	var entries []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = stripComment(line); line != "" {
+			entries = append(entries, line)
+		}
+	}
+	return entries
+}
+
+// stripComment removes a "# comment" from a list line, as Blocky does. A #
+// only starts a comment at the beginning of the line or after whitespace.
+func stripComment(line string) string {
+	for i := 0; i < len(line); i++ {
+		if line[i] == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t') {
+			line = line[:i]
+			break
+		}
+	}
+	return strings.TrimSpace(line)
+}
+
+func sameEntry(a, b string) bool {
+	a, b = stripComment(a), strings.TrimSpace(b)
+	if strings.HasPrefix(a, "/") {
+		return a == b
+	}
+	return strings.EqualFold(strings.TrimSuffix(a, "."), strings.TrimSuffix(b, "."))
+}
+
+// ListGroups returns the groups of one list kind with their sources.
+func ListGroups(configPath string, data []byte, kind string) (map[string][]ListSource, error) {
+	if err := checkListKind(kind); err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid YAML: %w", err)
+	}
+	groups := make(map[string][]ListSource)
+	lists := listKindNode(mappingValue(docRoot(&doc), "blocking"), kind)
+	if lists == nil || lists.Kind != yaml.MappingNode {
+		return groups, nil
+	}
+	for i := 0; i+1 < len(lists.Content); i += 2 {
+		name := lists.Content[i].Value
+		sources := []ListSource{}
+		for _, s := range groupSources(resolve(lists.Content[i+1])) {
+			if s = resolve(s); s.Kind != yaml.ScalarNode {
+				continue
+			}
+			switch path := listFilePath(configPath, s.Value); {
+			case isInline(s.Value):
+				sources = append(sources, ListSource{Type: SourceInline, Entries: listEntries(s.Value)})
+			case path != "":
+				src := ListSource{Type: SourceFile, Source: strings.TrimSpace(s.Value), Path: path}
+				if content, err := os.ReadFile(path); err == nil {
+					src.Entries = listEntries(string(content))
+				}
+				sources = append(sources, src)
+			default:
+				sources = append(sources, ListSource{Type: SourceURL, Source: strings.TrimSpace(s.Value)})
+			}
+		}
+		groups[name] = sources
+	}
+	return groups, nil
+}
+
+// groupFiles returns the local list files a group references, or only the
+// given one, which may be named as in the config or by its resolved path.
+func groupFiles(configPath string, data []byte, kind, group, source string) ([]string, error) {
+	groups, err := ListGroups(configPath, data, kind)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, s := range groups[group] {
+		if s.Type != SourceFile {
+			continue
+		}
+		if source == "" || source == s.Source || listFilePath(configPath, source) == s.Path {
+			files = append(files, s.Path)
+		}
+	}
+	if source != "" && len(files) == 0 {
+		return nil, ErrListSourceNotFound
+	}
+	return files, nil
+}
+
+// AddListEntry adds an entry to a list group: to the local list file
+// source if given, and otherwise (or for SourceInline) to the group's first
+// inline list, which is created if needed. The file is backed up before it
+// is written.
+func AddListEntry(configPath, kind, group, entry, source string) (*ListEdit, error) {
+	if err := checkListKind(kind); err != nil {
+		return nil, err
+	}
+	entry = strings.TrimSpace(entry)
+	if err := ValidateListEntry(entry); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEntry, err)
+	}
+	data, err := ReadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if source != "" && source != SourceInline {
+		files, err := groupFiles(configPath, data, kind, group, source)
+		if err != nil {
+			return nil, err
+		}
+		return editListFile(files[0], func(lines []string) ([]string, bool) {
+			for _, l := range lines {
+				if sameEntry(l, entry) {
+					return lines, false
+				}
+			}
+			return append(lines, entry+"\n"), true
+		})
+	}
+
+	return editInlineLists(configPath, data, kind, group, func(sources []*yaml.Node) ([]*yaml.Node, bool) {
+		for _, s := range sources {
+			if inlineSource(s) && containsEntry(s.Value, entry) {
+				return sources, false
+			}
+		}
+		for _, s := range sources {
+			if inlineSource(s) {
+				s.Value = strings.TrimRight(s.Value, "\n") + "\n" + entry + "\n"
+				return sources, true
+			}
+		}
+		inline := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Style: yaml.LiteralStyle, Value: entry + "\n"}
+		return append(sources, inline), true
+	})
+}
+
+// RemoveListEntry removes an entry from a list group: from the local list
+// file source if given, from the group's inline lists for SourceInline, and
+// otherwise from both the inline lists and all list files of the group. It
+// fails with ErrEntryNotFound if nothing changed.
+func RemoveListEntry(configPath, kind, group, entry, source string) ([]ListEdit, error) {
+	if err := checkListKind(kind); err != nil {
+		return nil, err
+	}
+	entry = strings.TrimSpace(entry)
+	data, err := ReadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	if source != SourceInline {
+		if files, err = groupFiles(configPath, data, kind, group, source); err != nil {
+			return nil, err
+		}
+	}
+
+	var edits []ListEdit
+	if source == "" || source == SourceInline {
+		edit, err := editInlineLists(configPath, data, kind, group, func(sources []*yaml.Node) ([]*yaml.Node, bool) {
+			changed := false
+			kept := sources[:0]
+			for _, s := range sources {
+				if inlineSource(s) && containsEntry(s.Value, entry) {
+					changed = true
+					if s.Value = removeEntry(s.Value, entry); strings.TrimSpace(s.Value) == "" {
+						continue
+					}
+				}
+				kept = append(kept, s)
+			}
+			return kept, changed
+		})
+		if err != nil {
+			return edits, err
+		}
+		if edit.Changed {
+			edits = append(edits, *edit)
+		}
+	}
+	for _, file := range files {
+		edit, err := editListFile(file, func(lines []string) ([]string, bool) {
+			kept := lines[:0:0]
+			for _, l := range lines {
+				if !sameEntry(l, entry) {
+					kept = append(kept, l)
+				}
+			}
+			return kept, len(kept) != len(lines)
+		})
+		if err != nil {
+			return edits, err
+		}
+		if edit.Changed {
+			edits = append(edits, *edit)
+		}
+	}
+	if len(edits) == 0 {
+		return nil, ErrEntryNotFound
+	}
+	return edits, nil
+}
+
+func containsEntry(text, entry string) bool {
+	for _, e := range listEntries(text) {
+		if sameEntry(e, entry) {
+			return true
+		}
+	}
+	return false
+}
+
+func removeEntry(text, entry string) string {
+	var kept []string
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if line != "" && !sameEntry(line, entry) {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "")
+}
+
+// editInlineLists edits the sources of a group in the config and saves it
+// with WriteConfigIfMatch, so a concurrent save is not overwritten. A group
+// left without sources is removed.
+func editInlineLists(configPath string, data []byte, kind, group string, edit func([]*yaml.Node) ([]*yaml.Node, bool)) (*ListEdit, error) {
+	result := &ListEdit{Source: SourceInline, File: configPath}
+	out, err := editSection(data, "blocking", func(blocking *yaml.Node) (*yaml.Node, error) {
+		if blocking == nil || isNull(blocking) {
+			blocking = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		}
+		if blocking.Kind != yaml.MappingNode {
+			return nil, errors.New("blocking is not a mapping")
+		}
+		key := kind
+		if findKey(blocking, kind) < 0 && findKey(blocking, legacyListKeys[kind]) >= 0 {
+			key = legacyListKeys[kind]
+		}
+		ki := findKey(blocking, key)
+		if ki < 0 {
+			blocking.Content = append(blocking.Content,
+				&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+				&yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"})
+			ki = len(blocking.Content) - 2
+		}
+		lists := blocking.Content[ki+1]
+		if lists.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("blocking.%s is not a mapping", key)
+		}
+
+		gi := findKey(lists, group)
+		var node *yaml.Node
+		if gi >= 0 {
+			node = lists.Content[gi+1]
+			if node.Kind == yaml.AliasNode {
+				return nil, fmt.Errorf("list group %s is an alias and cannot be edited", group)
+			}
+		}
+		sources, changed := edit(groupSources(node))
+		if result.Changed = changed; !changed {
+			return blocking, nil
+		}
+
+		switch {
+		case len(sources) == 0 && gi >= 0:
+			lists.Content = append(lists.Content[:gi], lists.Content[gi+2:]...)
+			if len(lists.Content) == 0 {
+				blocking.Content = append(blocking.Content[:ki], blocking.Content[ki+2:]...)
+			}
+		case node != nil && node.Kind == yaml.SequenceNode:
+			node.Content = sources
+			// Inline lists are block scalars, which a flow list cannot hold.
+			node.Style &^= yaml.FlowStyle
+		case len(sources) == 1 && node != nil:
+			lists.Content[gi+1] = sources[0]
+		default:
+			seq := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: sources}
+			if gi >= 0 {
+				copyComments(seq, node)
+				lists.Content[gi+1] = seq
+			} else {
+				lists.Content = append(lists.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: group}, seq)
+			}
+		}
+		return blocking, nil
+	})
+	if err != nil || !result.Changed {
+		return result, err
+	}
+	if result.Backup, _, err = WriteConfigIfMatch(configPath, out, ConfigETag(data)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// editListFile rewrites a local list file line by line, after backing it
+// up. A missing file is created when edit adds lines.
+func editListFile(path string, edit func(lines []string) ([]string, bool)) (*ListEdit, error) {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+
+	result := &ListEdit{Source: path, File: path}
+	data, err := os.ReadFile(path)
+	exists := err == nil
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("read list: %w", err)
+	}
+	lines := splitLines(data)
+	if n := len(lines); n > 0 && !strings.HasSuffix(lines[n-1], "\n") {
+		lines[n-1] += "\n"
+	}
+	lines, result.Changed = edit(lines)
+	if !result.Changed {
+		return result, nil
+	}
+	if exists {
+		if result.Backup, err = BackupConfig(path); err != nil {
+			return nil, fmt.Errorf("backup failed: %w", err)
+		}
+	}
+	if err := fsutil.WriteFileAtomic(path, []byte(strings.Join(lines, "")), 0644); err != nil {
+		return nil, fmt.Errorf("write list: %w", err)
+	}
+	return result, nil
+}
+
+// Ways of applying a list change to the running Blocky.
+const (
+	ApplyRestart = "restart"
+	ApplyReload  = "reload"
+)
+
+// ApplyListChange restarts Blocky or makes it reload its lists. Only a
+// restart picks up changes to inline lists in the config.
+func ApplyListChange(configPath, serviceName, mode string) error {
+	switch mode {
+	case "":
+		return nil
+	case ApplyRestart:
+		return Restart(serviceName)
+	case ApplyReload:
+		data, err := ReadConfig(configPath)
+		if err != nil {
+			return err
+		}
+		return RefreshLists(data)
+	}
+	return fmt.Errorf("unknown apply mode %q", mode)
+}
+
+// RefreshLists makes the running Blocky reload its allow- and denylists
+// through its HTTP API, found at ports.http of the config. Changes to list
+// files take effect this way; changes to the config need a restart.
+func RefreshLists(data []byte) error {
+	port, err := GetSection(data, "ports.http")
+	if err != nil {
+		return errors.New("ports.http is not set in the Blocky config, so its API cannot be reached")
+	}
+	addr := fmt.Sprint(port)
+	if list, ok := port.([]any); ok && len(list) > 0 {
+		addr = fmt.Sprint(list[0])
+	}
+	addr, _, _ = strings.Cut(addr, ",")
+	addr = strings.TrimSpace(addr)
+	if !strings.Contains(addr, ":") {
+		addr = ":" + addr
+	}
+	host, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid ports.http %q: %w", addr, err)
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+
+	client := &http.Client{Timeout: 2 * time.Minute}
+	resp, err := client.Post("http://"+net.JoinHostPort(host, p)+"/api/lists/refresh", "application/json", nil)
+	if err != nil {
+		return fmt.Errorf("refresh lists: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("refresh lists: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
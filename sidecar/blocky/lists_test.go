@@ -0,0 +1,214 @@
+package blocky
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const listsConfig = `blocking:
+  denylists:
+    ads:
+      - https://a.example/list.txt
+      - lists/local.txt # own list
+  allowlists:
+    ads:
+      - |
+        # fixes
+        good.example.com
+  blockType: zeroIp
+`
+
+func setupLists(t *testing.T) (configPath, listPath string) {
+	t.Helper()
+	dir := t.TempDir()
+	configPath = filepath.Join(dir, "config.yml")
+	listPath = filepath.Join(dir, "lists", "local.txt")
+	if err := os.WriteFile(configPath, []byte(listsConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Mkdir(filepath.Dir(listPath), 0755)
+	if err := os.WriteFile(listPath, []byte("tracker.example.net\nbad.example.org"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return configPath, listPath
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestAddListEntry(t *testing.T) {
+	configPath, listPath := setupLists(t)
+
+	edit, err := AddListEntry(configPath, Allowlist, "ads", "*.cdn.example.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !edit.Changed || edit.Source != SourceInline || edit.Backup == "" {
+		t.Errorf("edit = %+v", edit)
+	}
+	want := strings.Replace(listsConfig, "good.example.com\n", "good.example.com\n        *.cdn.example.com\n", 1)
+	if got := readFile(t, configPath); got != want {
+		t.Errorf("config =\n%s\nwant\n%s", got, want)
+	}
+
+	if edit, err := AddListEntry(configPath, Allowlist, "ads", "GOOD.example.com.", ""); err != nil || edit.Changed {
+		t.Errorf("adding an existing entry: %+v, %v", edit, err)
+	}
+
+	if _, err := AddListEntry(configPath, Allowlist, "kids", "/^school\\./", ""); err != nil {
+		t.Fatal(err)
+	}
+	groups, err := ListGroups(configPath, []byte(readFile(t, configPath)), Allowlist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kids := groups["kids"]; len(kids) != 1 || kids[0].Type != SourceInline || kids[0].Entries[0] != "/^school\\./" {
+		t.Errorf("new group = %+v", kids)
+	}
+
+	edit, err = AddListEntry(configPath, Denylist, "ads", "ads.example.com", "lists/local.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edit.Source != listPath || edit.Backup == "" {
+		t.Errorf("file edit = %+v", edit)
+	}
+	if got := readFile(t, listPath); got != "tracker.example.net\nbad.example.org\nads.example.com\n" {
+		t.Errorf("list file = %q", got)
+	}
+
+	for _, entry := range []string{"bad..example", "*bad.example", "/[/", ""} {
+		if _, err := AddListEntry(configPath, Denylist, "ads", entry, ""); !errors.Is(err, ErrInvalidEntry) {
+			t.Errorf("AddListEntry(%q) error = %v, want ErrInvalidEntry", entry, err)
+		}
+	}
+	if _, err := AddListEntry(configPath, Denylist, "ads", "x.example", "other.txt"); !errors.Is(err, ErrListSourceNotFound) {
+		t.Errorf("unreferenced file error = %v, want ErrListSourceNotFound", err)
+	}
+	if _, err := AddListEntry(configPath, "greylists", "ads", "x.example", ""); err == nil {
+		t.Error("unknown list kind accepted")
+	}
+}
+
+func TestRemoveListEntry(t *testing.T) {
+	configPath, listPath := setupLists(t)
+
+	edits, err := RemoveListEntry(configPath, Denylist, "ads", "bad.example.org", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(edits) != 1 || edits[0].Source != listPath {
+		t.Errorf("edits = %+v", edits)
+	}
+	if got := readFile(t, listPath); got != "tracker.example.net\n" {
+		t.Errorf("list file = %q", got)
+	}
+
+	if _, err := RemoveListEntry(configPath, Allowlist, "ads", "good.example.com", ""); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Replace(listsConfig, "        good.example.com\n", "", 1)
+	if got := readFile(t, configPath); got != want {
+		t.Errorf("config =\n%s\nwant\n%s", got, want)
+	}
+
+	if _, err := RemoveListEntry(configPath, Allowlist, "ads", "/^media\\./", ""); !errors.Is(err, ErrEntryNotFound) {
+		t.Errorf("missing regex error = %v, want ErrEntryNotFound", err)
+	}
+	if _, err := RemoveListEntry(configPath, Denylist, "ads", "nope.example", ""); !errors.Is(err, ErrEntryNotFound) {
+		t.Errorf("missing entry error = %v, want ErrEntryNotFound", err)
+	}
+}
+
+func TestListEntryComments(t *testing.T) {
+	configPath, _ := setupLists(t)
+	config := strings.Replace(listsConfig, "good.example.com\n", "good.example.com # partner\n", 1)
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if edit, err := AddListEntry(configPath, Allowlist, "ads", "new.example.com", ""); err != nil || !edit.Changed {
+		t.Fatalf("add next to a commented entry: %+v, %v", edit, err)
+	}
+	if edit, err := AddListEntry(configPath, Allowlist, "ads", "good.example.com", ""); err != nil || edit.Changed {
+		t.Errorf("commented entry not found as existing: %+v, %v", edit, err)
+	}
+	if _, err := RemoveListEntry(configPath, Allowlist, "ads", "good.example.com", ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, configPath); strings.Contains(got, "good.example.com") {
+		t.Errorf("commented entry not removed:\n%s", got)
+	}
+}
+
+func TestLegacyListKey(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yml")
+	os.WriteFile(configPath, []byte("blocking:\n  whiteLists:\n    ads: https://a.example/allow.txt\n"), 0644)
+
+	if _, err := AddListEntry(configPath, Allowlist, "ads", "ok.example", ""); err != nil {
+		t.Fatal(err)
+	}
+	want := "blocking:\n  whiteLists:\n    ads:\n      - https://a.example/allow.txt\n      - |\n        ok.example\n"
+	if got := readFile(t, configPath); got != want {
+		t.Errorf("config =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestInlineListInFlowSequence(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yml")
+	os.WriteFile(configPath, []byte("blocking:\n  denylists:\n    ads: [https://a.example/deny.txt]\n"), 0644)
+
+	if _, err := AddListEntry(configPath, Denylist, "ads", "x.example", ""); err != nil {
+		t.Fatal(err)
+	}
+	want := "blocking:\n  denylists:\n    ads:\n      - https://a.example/deny.txt\n      - |\n        x.example\n"
+	if got := readFile(t, configPath); got != want {
+		t.Errorf("config =\n%s\nwant\n%s", got, want)
+	}
+	if _, err := AddListEntry(configPath, Allowlist, "ads", "ok.example", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RemoveListEntry(configPath, Allowlist, "ads", "ok.example", ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, configPath); got != want {
+		t.Errorf("config after removing the only allowlist entry =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRefreshLists(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/api/lists/refresh" {
+			calls++
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	addr := strings.TrimPrefix(srv.URL, "http://")
+	if err := RefreshLists([]byte(fmt.Sprintf("ports:\n  http: %s\n", addr))); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("refresh calls = %d, want 1", calls)
+	}
+	if err := RefreshLists([]byte("ports:\n  dns: 53\n")); err == nil {
+		t.Error("RefreshLists without ports.http succeeded")
+	}
+}
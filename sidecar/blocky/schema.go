@@ -0,0 +1,752 @@
+package blocky
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Issue severities.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// ConfigIssue is a problem found in a Blocky config. Line and Column are
+// 1-based; Path is the dotted key path, e.g. "blocking.loading.strategy".
+type ConfigIssue struct {
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Path     string `json:"path"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// ValidationError is returned by WriteConfig when the config has errors.
+// Issues holds the errors and any warnings.
+type ValidationError struct {
+	Issues []ConfigIssue
+}
+
+func (e *ValidationError) Error() string {
+	var errs []ConfigIssue
+	for _, is := range e.Issues {
+		if is.Severity == SeverityError {
+			errs = append(errs, is)
+		}
+	}
+	if len(errs) == 0 {
+		return "invalid config"
+	}
+	msg := fmt.Sprintf("line %d: %s", errs[0].Line, errs[0].Message)
+	if len(errs) > 1 {
+		msg += fmt.Sprintf(" (and %d more errors)", len(errs)-1)
+	}
+	return "invalid config: " + msg
+}
+
+// HasErrors reports whether any of issues is an error.
+func HasErrors(issues []ConfigIssue) bool {
+	for _, is := range issues {
+		if is.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+var yamlErrLine = regexp.MustCompile(`line (\d+)`)
+
+// ValidateConfig checks data against the schema of Blocky's config: known
+// keys, value types, enums, durations, list sources, upstreams and IP
+// fields. Deprecated and unknown keys are warnings, except that an unknown
+// key close to a known one is reported as a probable typo error.
+func ValidateConfig(data []byte) []ConfigIssue {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		is := ConfigIssue{Severity: SeverityError, Message: strings.TrimPrefix(err.Error(), "yaml: ")}
+		if m := yamlErrLine.FindStringSubmatch(err.Error()); m != nil {
+			is.Line, _ = strconv.Atoi(m[1])
+		}
+		return []ConfigIssue{is}
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+
+	v := &validator{}
+	root := resolve(doc.Content[0])
+	v.walk(configSchema, root, "")
+	v.checkClientGroups(root)
+
+	sort.SliceStable(v.issues, func(i, j int) bool {
+		if v.issues[i].Line != v.issues[j].Line {
+			return v.issues[i].Line < v.issues[j].Line
+		}
+		return v.issues[i].Column < v.issues[j].Column
+	})
+	return v.issues
+}
+
+// ValidateListEntry checks one line of an allow- or denylist: a domain, a
+// wildcard such as "*.example.com", a regex between slashes, an IP or CIDR,
+// or a hosts-file line.
+func ValidateListEntry(entry string) error {
+	entry = strings.TrimSpace(entry)
+	switch {
+	case entry == "":
+		return fmt.Errorf("empty entry")
+	case len(entry) > 2 && strings.HasPrefix(entry, "/") && strings.HasSuffix(entry, "/"):
+		if _, err := regexp.Compile(entry[1 : len(entry)-1]); err != nil {
+			return fmt.Errorf("invalid regex %s: %w", entry, err)
+		}
+		return nil
+	case strings.HasPrefix(entry, "*."):
+		if err := checkDomain(entry[2:]); err != nil {
+			return fmt.Errorf("invalid wildcard %q: %w", entry, err)
+		}
+		return nil
+	case strings.Contains(entry, "*"):
+		return fmt.Errorf("invalid wildcard %q: only a leading \"*.\" is supported", entry)
+	}
+
+	if fields := strings.Fields(entry); len(fields) > 1 {
+		// Hosts-file format: an address followed by host names.
+		if _, err := netip.ParseAddr(fields[0]); err != nil {
+			return fmt.Errorf("invalid entry %q: unexpected whitespace", entry)
+		}
+		for _, name := range fields[1:] {
+			if err := checkDomain(name); err != nil {
+				return fmt.Errorf("invalid host %q: %w", name, err)
+			}
+		}
+		return nil
+	}
+	if _, err := netip.ParseAddr(entry); err == nil {
+		return nil
+	}
+	if _, err := netip.ParsePrefix(entry); err == nil {
+		return nil
+	}
+	if err := checkDomain(entry); err != nil {
+		return fmt.Errorf("invalid domain %q: %w", entry, err)
+	}
+	return nil
+}
+
+func checkDomain(name string) error {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return fmt.Errorf("empty name")
+	}
+	if len(name) > 253 {
+		return fmt.Errorf("name longer than 253 characters")
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return fmt.Errorf("invalid label length")
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return fmt.Errorf("invalid character %q", c)
+			}
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("label %q starts or ends with a hyphen", label)
+		}
+	}
+	return nil
+}
+
+type schemaKind int
+
+const (
+	kindScalar schemaKind = iota // any scalar, optionally checked
+	kindBool
+	kindInt
+	kindObject // known keys
+	kindMap    // arbitrary keys, values of elem
+	kindList   // sequence of elem
+)
+
+type schema struct {
+	kind   schemaKind
+	check  func(string) error // for scalars
+	fields map[string]*schema
+	elem   *schema
+	// single accepts one elem in place of a list.
+	single bool
+	// object is accepted in place of a scalar.
+	object *schema
+	// deprecated names the replacement of a deprecated key.
+	deprecated string
+}
+
+func str() *schema      { return &schema{kind: kindScalar} }
+func boolean() *schema  { return &schema{kind: kindBool} }
+func integer() *schema  { return &schema{kind: kindInt} }
+func duration() *schema { return &schema{kind: kindScalar, check: checkDuration} }
+func ip() *schema       { return &schema{kind: kindScalar, check: checkIP} }
+func upstream() *schema { return &schema{kind: kindScalar, check: checkUpstream} }
+func port() *schema     { return &schema{kind: kindScalar, check: checkPorts} }
+
+func enum(values ...string) *schema {
+	return &schema{kind: kindScalar, check: func(s string) error {
+		for _, v := range values {
+			if strings.EqualFold(s, v) {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(values, ", "))
+	}}
+}
+
+func object(fields map[string]*schema) *schema { return &schema{kind: kindObject, fields: fields} }
+func mapOf(elem *schema) *schema               { return &schema{kind: kindMap, elem: elem} }
+func listOf(elem *schema) *schema              { return &schema{kind: kindList, elem: elem} }
+func oneOrList(elem *schema) *schema           { return &schema{kind: kindList, elem: elem, single: true} }
+
+func deprecated(s *schema, replacement string) *schema {
+	cp := *s
+	cp.deprecated = replacement
+	return &cp
+}
+
+func listSource() *schema { return &schema{kind: kindScalar, check: checkListSource} }
+func hostsSource() *schema {
+	return &schema{kind: kindScalar, check: func(s string) error {
+		if strings.Contains(s, "\n") {
+			return nil
+		}
+		return checkSourceLocation(s)
+	}}
+}
+
+var loadingSchema = object(map[string]*schema{
+	"refreshPeriod": duration(),
+	"downloads": object(map[string]*schema{
+		"timeout":      duration(),
+		"readTimeout":  duration(),
+		"writeTimeout": duration(),
+		"attempts":     integer(),
+		"cooldown":     duration(),
+	}),
+	"concurrency":        integer(),
+	"maxErrorsPerSource": integer(),
+	"strategy":           enum("blocking", "failOnError", "fast"),
+})
+
+var configSchema = object(map[string]*schema{
+	"upstreams": object(map[string]*schema{
+		"groups":    mapOf(oneOrList(upstream())),
+		"strategy":  enum("parallel_best", "strict", "random"),
+		"timeout":   duration(),
+		"userAgent": str(),
+		"init":      object(map[string]*schema{"strategy": enum("blocking", "failOnError", "fast")}),
+	}),
+	"connectIPVersion": enum("dual", "v4", "v6"),
+	"customDNS": object(map[string]*schema{
+		"customTTL":           duration(),
+		"rewrite":             mapOf(str()),
+		"mapping":             mapOf(&schema{kind: kindScalar, check: checkMapping}),
+		"filterUnmappedTypes": boolean(),
+		"zone":                str(),
+	}),
+	"conditional": object(map[string]*schema{
+		"fallbackUpstream": boolean(),
+		"rewrite":          mapOf(str()),
+		"mapping":          mapOf(&schema{kind: kindScalar, check: checkUpstreamList}),
+	}),
+	"blocking": object(map[string]*schema{
+		"denylists":         mapOf(oneOrList(listSource())),
+		"allowlists":        mapOf(oneOrList(listSource())),
+		"clientGroupsBlock": mapOf(oneOrList(str())),
+		"blockType":         {kind: kindScalar, check: checkBlockType},
+		"blockTTL":          duration(),
+		"loading":           loadingSchema,
+
+		"blackLists":            deprecated(mapOf(oneOrList(listSource())), "blocking.denylists"),
+		"whiteLists":            deprecated(mapOf(oneOrList(listSource())), "blocking.allowlists"),
+		"refreshPeriod":         deprecated(duration(), "blocking.loading.refreshPeriod"),
+		"downloadTimeout":       deprecated(duration(), "blocking.loading.downloads.timeout"),
+		"downloadAttempts":      deprecated(integer(), "blocking.loading.downloads.attempts"),
+		"downloadCooldown":      deprecated(duration(), "blocking.loading.downloads.cooldown"),
+		"failStartOnListError":  deprecated(boolean(), "blocking.loading.strategy"),
+		"processingConcurrency": deprecated(integer(), "blocking.loading.concurrency"),
+		"startStrategy":         deprecated(enum("blocking", "failOnError", "fast"), "blocking.loading.strategy"),
+	}),
+	"caching": object(map[string]*schema{
+		"minTime":               duration(),
+		"maxTime":               duration(),
+		"maxItemsCount":         integer(),
+		"prefetching":           boolean(),
+		"prefetchExpires":       duration(),
+		"prefetchThreshold":     integer(),
+		"prefetchMaxItemsCount": integer(),
+		"cacheTimeNegative":     duration(),
+	}),
+	"clientLookup": object(map[string]*schema{
+		"upstream":        upstream(),
+		"singleNameOrder": listOf(integer()),
+		"clients":         mapOf(oneOrList(ip())),
+	}),
+	"prometheus": object(map[string]*schema{
+		"enable": boolean(),
+		"path":   str(),
+	}),
+	"queryLog": object(map[string]*schema{
+		"type":             enum("mysql", "postgresql", "timescale", "csv", "csv-client", "console", "none"),
+		"target":           str(),
+		"logRetentionDays": integer(),
+		"creationAttempts": integer(),
+		"creationCooldown": duration(),
+		"flushInterval":    duration(),
+		"fields":           listOf(enum("clientIP", "clientName", "responseReason", "responseAnswer", "question", "duration")),
+		"ignore":           object(map[string]*schema{"sudn": boolean()}),
+	}),
+	"redis": object(map[string]*schema{
+		"address":            str(),
+		"username":           str(),
+		"password":           str(),
+		"database":           integer(),
+		"required":           boolean(),
+		"connectionAttempts": integer(),
+		"connectionCooldown": duration(),
+		"sentinelUsername":   str(),
+		"sentinelPassword":   str(),
+		"sentinelAddresses":  listOf(str()),
+	}),
+	"minTlsServeVersion": enum("1.0", "1.1", "1.2", "1.3"),
+	"certFile":           str(),
+	"keyFile":            str(),
+	"ports": object(map[string]*schema{
+		"dns":   oneOrList(port()),
+		"tls":   oneOrList(port()),
+		"http":  oneOrList(port()),
+		"https": oneOrList(port()),
+	}),
+	"bootstrapDns": oneOrList(&schema{kind: kindScalar, check: checkUpstream, object: object(map[string]*schema{
+		"upstream": upstream(),
+		"ips":      listOf(ip()),
+	})}),
+	"fqdnOnly":  object(map[string]*schema{"enable": boolean()}),
+	"filtering": object(map[string]*schema{"queryTypes": listOf(str())}),
+	"hostsFile": object(map[string]*schema{
+		"sources":        oneOrList(hostsSource()),
+		"hostsTTL":       duration(),
+		"filterLoopback": boolean(),
+		"loading":        loadingSchema,
+
+		"filePath":      deprecated(str(), "hostsFile.sources"),
+		"refreshPeriod": deprecated(duration(), "hostsFile.loading.refreshPeriod"),
+	}),
+	"log": object(map[string]*schema{
+		"level":     enum("trace", "debug", "info", "warn", "error", "fatal"),
+		"format":    enum("text", "json"),
+		"timestamp": boolean(),
+		"privacy":   boolean(),
+	}),
+	"ede": object(map[string]*schema{"enable": boolean()}),
+	"ecs": object(map[string]*schema{
+		"useAsClient": boolean(),
+		"forward":     boolean(),
+		"ipv4Mask":    integer(),
+		"ipv6Mask":    integer(),
+	}),
+	"specialUseDomains": object(map[string]*schema{
+		"enable":            boolean(),
+		"rfc6762-appendixG": boolean(),
+	}),
+
+	"upstream":            deprecated(mapOf(oneOrList(upstream())), "upstreams.groups"),
+	"upstreamTimeout":     deprecated(duration(), "upstreams.timeout"),
+	"startVerifyUpstream": deprecated(boolean(), "upstreams.init.strategy"),
+	"port":                deprecated(oneOrList(port()), "ports.dns"),
+	"tlsPort":             deprecated(oneOrList(port()), "ports.tls"),
+	"httpPort":            deprecated(oneOrList(port()), "ports.http"),
+	"httpsPort":           deprecated(oneOrList(port()), "ports.https"),
+	"logLevel":            deprecated(enum("trace", "debug", "info", "warn", "error", "fatal"), "log.level"),
+	"logFormat":           deprecated(enum("text", "json"), "log.format"),
+	"logPrivacy":          deprecated(boolean(), "log.privacy"),
+	"logTimestamp":        deprecated(boolean(), "log.timestamp"),
+	"dohUserAgent":        deprecated(str(), "upstreams.userAgent"),
+	"disableIPv6":         deprecated(boolean(), "filtering.queryTypes"),
+})
+
+type validator struct {
+	issues []ConfigIssue
+}
+
+func (v *validator) add(n *yaml.Node, path, severity, format string, args ...any) {
+	v.issues = append(v.issues, ConfigIssue{
+		Line:     n.Line,
+		Column:   n.Column,
+		Path:     path,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func resolve(n *yaml.Node) *yaml.Node {
+	for n.Kind == yaml.AliasNode && n.Alias != nil {
+		n = n.Alias
+	}
+	return n
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+var kindNames = map[yaml.Kind]string{
+	yaml.MappingNode:  "a mapping",
+	yaml.SequenceNode: "a list",
+	yaml.ScalarNode:   "a value",
+}
+
+func (v *validator) walk(s *schema, n *yaml.Node, path string) {
+	n = resolve(n)
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!null" {
+		return // an empty key leaves Blocky's default
+	}
+
+	switch s.kind {
+	case kindObject:
+		if n.Kind != yaml.MappingNode {
+			v.add(n, path, SeverityError, "expected a mapping, got %s", kindNames[n.Kind])
+			return
+		}
+		v.walkMapping(n, path, func(key *yaml.Node, val *yaml.Node) {
+			p := joinPath(path, key.Value)
+			field, ok := s.fields[key.Value]
+			if !ok {
+				v.unknownKey(s, key, p)
+				return
+			}
+			if field.deprecated != "" {
+				v.add(key, p, SeverityWarning, "%s is deprecated, use %s", key.Value, field.deprecated)
+			}
+			v.walk(field, val, p)
+		})
+	case kindMap:
+		if n.Kind != yaml.MappingNode {
+			v.add(n, path, SeverityError, "expected a mapping, got %s", kindNames[n.Kind])
+			return
+		}
+		v.walkMapping(n, path, func(key *yaml.Node, val *yaml.Node) {
+			v.walk(s.elem, val, joinPath(path, key.Value))
+		})
+	case kindList:
+		if n.Kind != yaml.SequenceNode {
+			if s.single && n.Kind != yaml.MappingNode || s.single && s.elem.object != nil {
+				v.walk(s.elem, n, path)
+				return
+			}
+			v.add(n, path, SeverityError, "expected a list, got %s", kindNames[n.Kind])
+			return
+		}
+		for i, item := range n.Content {
+			v.walk(s.elem, item, fmt.Sprintf("%s[%d]", path, i))
+		}
+	default:
+		if n.Kind == yaml.MappingNode && s.object != nil {
+			v.walk(s.object, n, path)
+			return
+		}
+		if n.Kind != yaml.ScalarNode {
+			v.add(n, path, SeverityError, "expected a single value, got %s", kindNames[n.Kind])
+			return
+		}
+		switch {
+		case s.kind == kindBool && n.Tag != "!!bool":
+			v.add(n, path, SeverityError, "expected true or false, got %q", n.Value)
+		case s.kind == kindInt && n.Tag != "!!int":
+			v.add(n, path, SeverityError, "expected an integer, got %q", n.Value)
+		case s.check != nil:
+			if err := s.check(n.Value); err != nil {
+				v.add(n, path, SeverityError, "%s", err)
+			}
+		}
+	}
+}
+
+// walkMapping calls fn for each key of n, including keys merged in with
+// "<<", and reports duplicates.
+func (v *validator) walkMapping(n *yaml.Node, path string, fn func(key, val *yaml.Node)) {
+	seen := make(map[string]int)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, val := n.Content[i], n.Content[i+1]
+		if key.Tag == "!!merge" {
+			merged := resolve(val)
+			if merged.Kind == yaml.MappingNode {
+				for j := 0; j+1 < len(merged.Content); j += 2 {
+					fn(merged.Content[j], merged.Content[j+1])
+				}
+			}
+			continue
+		}
+		if line, dup := seen[key.Value]; dup {
+			v.add(key, joinPath(path, key.Value), SeverityError, "duplicate key %s, first defined on line %d", key.Value, line)
+			continue
+		}
+		seen[key.Value] = key.Line
+		fn(key, val)
+	}
+}
+
+// unknownKey warns about a key missing from the schema, suggesting a known
+// key within two edits (one for short keys) as the likely intended one. It
+// is not an error: the guess may be wrong and the key may be newer than the
+// schema.
+func (v *validator) unknownKey(s *schema, key *yaml.Node, path string) {
+	maxDist := 2
+	if len(key.Value) <= 4 {
+		maxDist = 1
+	}
+	best, bestDist := "", maxDist+1
+	for name := range s.fields {
+		if d := editDistance(strings.ToLower(key.Value), strings.ToLower(name)); d < bestDist || d == bestDist && name < best {
+			best, bestDist = name, d
+		}
+	}
+	if best != "" {
+		v.add(key, path, SeverityWarning, "unknown key %s, did you mean %s?", key.Value, best)
+		return
+	}
+	v.add(key, path, SeverityWarning, "unknown key %s", key.Value)
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// checkClientGroups warns about clientGroupsBlock entries naming list
+// groups that are not defined in blocking.denylists.
+func (v *validator) checkClientGroups(root *yaml.Node) {
+	blocking := mappingValue(root, "blocking")
+	groups := mappingValue(blocking, "clientGroupsBlock")
+	if groups == nil || groups.Kind != yaml.MappingNode {
+		return
+	}
+	defined := make(map[string]bool)
+	for _, name := range []string{"denylists", "blackLists"} {
+		if lists := mappingValue(blocking, name); lists != nil && lists.Kind == yaml.MappingNode {
+			for i := 0; i+1 < len(lists.Content); i += 2 {
+				defined[lists.Content[i].Value] = true
+			}
+		}
+	}
+	for i := 0; i+1 < len(groups.Content); i += 2 {
+		client, val := groups.Content[i], resolve(groups.Content[i+1])
+		names := val.Content
+		if val.Kind == yaml.ScalarNode {
+			names = []*yaml.Node{val}
+		}
+		for _, name := range names {
+			if name.Kind == yaml.ScalarNode && !defined[name.Value] {
+				v.add(name, "blocking.clientGroupsBlock."+client.Value, SeverityWarning, "list group %s is not defined in blocking.denylists", name.Value)
+			}
+		}
+	}
+}
+
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	if n == nil {
+		return nil
+	}
+	n = resolve(n)
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return resolve(n.Content[i+1])
+		}
+	}
+	return nil
+}
+
+// checkDuration accepts Go durations such as "30s" or "1h30m" and plain
+// numbers, which Blocky reads as minutes.
+func checkDuration(s string) error {
+	if _, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return nil
+	}
+	if _, err := time.ParseDuration(s); err != nil {
+		return fmt.Errorf("invalid duration %q, expected e.g. 30s, 5m or 1h", s)
+	}
+	return nil
+}
+
+func checkIP(s string) error {
+	if _, err := netip.ParseAddr(s); err != nil {
+		return fmt.Errorf("invalid IP address %q", s)
+	}
+	return nil
+}
+
+// checkPorts accepts a port, "address:port", or a comma-separated list of
+// those.
+func checkPorts(s string) error {
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if _, portStr, err := net.SplitHostPort(p); err == nil {
+			p = portStr
+		}
+		if n, err := strconv.Atoi(p); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid port %q", p)
+		}
+	}
+	return nil
+}
+
+var upstreamProtocols = []string{"tcp+udp", "tcp-tls", "https"}
+
+// checkUpstream accepts Blocky's upstream syntax:
+// [protocol:]host[:port][/path][#commonName].
+func checkUpstream(s string) error {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return fmt.Errorf("empty upstream")
+	}
+	if strings.HasPrefix(s, "https://") {
+		u, err := url.Parse(s)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid upstream URL %q", s)
+		}
+		return nil
+	}
+	rest := s
+	for _, proto := range upstreamProtocols {
+		if r, ok := strings.CutPrefix(s, proto+":"); ok {
+			rest = strings.TrimPrefix(r, "//")
+			break
+		}
+	}
+	if i := strings.IndexAny(rest, "/#"); i >= 0 {
+		rest = rest[:i]
+	}
+
+	host := rest
+	if h, p, err := net.SplitHostPort(rest); err == nil {
+		if n, err := strconv.Atoi(p); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid port in upstream %q", s)
+		}
+		host = h
+	} else if strings.Count(rest, ":") == 1 {
+		return fmt.Errorf("invalid upstream %q", s)
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return nil
+	}
+	if err := checkDomain(host); err != nil {
+		return fmt.Errorf("invalid upstream %q: %w", s, err)
+	}
+	return nil
+}
+
+func checkUpstreamList(s string) error {
+	for _, u := range strings.Split(s, ",") {
+		if err := checkUpstream(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkMapping accepts the value of a customDNS mapping: comma-separated
+// IP addresses or a single CNAME target.
+func checkMapping(s string) error {
+	parts := strings.Split(s, ",")
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		if _, err := netip.ParseAddr(p); err == nil {
+			continue
+		}
+		if len(parts) == 1 && checkDomain(p) == nil {
+			return nil
+		}
+		return fmt.Errorf("invalid mapping %q, expected IP addresses or a domain", s)
+	}
+	return nil
+}
+
+// checkBlockType accepts zeroIp, nxDomain or comma-separated IPs.
+func checkBlockType(s string) error {
+	if strings.EqualFold(s, "zeroIp") || strings.EqualFold(s, "nxDomain") {
+		return nil
+	}
+	for _, p := range strings.Split(s, ",") {
+		if _, err := netip.ParseAddr(strings.TrimSpace(p)); err != nil {
+			return fmt.Errorf("invalid blockType %q, expected zeroIp, nxDomain or IP addresses", s)
+		}
+	}
+	return nil
+}
+
+// checkListSource accepts a URL, a file path or an inline block of list
+// entries.
+func checkListSource(s string) error {
+	if !strings.Contains(s, "\n") {
+		return checkSourceLocation(s)
+	}
+	for i, line := range strings.Split(s, "\n") {
+		if line = stripComment(line); line == "" {
+			continue
+		}
+		if err := ValidateListEntry(line); err != nil {
+			return fmt.Errorf("inline entry %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func checkSourceLocation(s string) error {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return fmt.Errorf("empty list source")
+	}
+	if strings.Contains(s, "://") {
+		u, err := url.Parse(s)
+		if err != nil {
+			return fmt.Errorf("invalid list URL %q", s)
+		}
+		switch u.Scheme {
+		case "http", "https":
+			if u.Host == "" {
+				return fmt.Errorf("list URL %q has no host", s)
+			}
+		case "file":
+		default:
+			return fmt.Errorf("unsupported list URL scheme %q", u.Scheme)
+		}
+	}
+	return nil
+}
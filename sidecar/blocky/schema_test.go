@@ -0,0 +1,192 @@
+package blocky
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const validConfig = `upstreams:
+  groups:
+    default:
+      - 1.1.1.1
+      - tcp-tls:dns.quad9.net:853
+      - https://dns.google/dns-query
+  strategy: parallel_best
+  timeout: 2s
+blocking:
+  denylists:
+    ads:
+      - https://raw.githubusercontent.com/StevenBlack/hosts/master/hosts
+      - /etc/blocky/local-deny.txt
+      - |
+        # inline
+        ads.example.com
+        *.tracker.net
+        /^metrics[0-9]+\.example\.org$/
+    strict: [https://example.org/strict.txt]
+  allowlists:
+    ads: [/etc/blocky/allow.txt]
+  clientGroupsBlock:
+    default: [ads]
+    192.168.1.0/24: [ads, strict]
+  blockType: zeroIp
+  blockTTL: 1m
+  loading:
+    refreshPeriod: 4h
+    strategy: fast
+    downloads:
+      attempts: 3
+caching:
+  minTime: 5
+  prefetching: true
+customDNS:
+  mapping:
+    nas.lan: 192.168.1.10
+    printer.lan: 192.168.1.11,fd00::11
+ports:
+  dns: 53
+  http: 127.0.0.1:4000
+bootstrapDns:
+  - upstream: https://dns.google/dns-query
+    ips: [8.8.8.8]
+queryLog:
+  type: csv
+  target: /var/log/blocky
+  logRetentionDays: 7
+log:
+  level: info
+prometheus:
+  enable: true
+`
+
+func TestValidateConfigValid(t *testing.T) {
+	if issues := ValidateConfig([]byte(validConfig)); len(issues) != 0 {
+		t.Errorf("issues = %+v, want none", issues)
+	}
+}
+
+func TestValidateConfigIssues(t *testing.T) {
+	config := `upstreams:
+  grops:
+    default: [1.1.1.1]
+  strategy: fastest
+blocking:
+  blackLists:
+    ads: [https://example.org/ads.txt]
+  clientGroupsBlock:
+    default: [ads, kids]
+  blockTTL: ten minutes
+  loading:
+    downloads:
+      attempts: three
+caching:
+  prefetching: yes please
+customDNS:
+  mapping:
+    bad.lan: 192.168.1.300,10.0.0.1
+ports:
+  dns: 70000
+futureFeature: true
+`
+	want := []struct {
+		line     int
+		severity string
+		path     string
+		message  string
+	}{
+		{2, SeverityWarning, "upstreams.grops", "did you mean groups?"},
+		{4, SeverityError, "upstreams.strategy", "must be one of"},
+		{6, SeverityWarning, "blocking.blackLists", "use blocking.denylists"},
+		{9, SeverityWarning, "blocking.clientGroupsBlock.default", "list group kids is not defined"},
+		{10, SeverityError, "blocking.blockTTL", "invalid duration"},
+		{13, SeverityError, "blocking.loading.downloads.attempts", "expected an integer"},
+		{15, SeverityError, "caching.prefetching", "expected true or false"},
+		{18, SeverityError, "customDNS.mapping.bad.lan", "invalid mapping"},
+		{20, SeverityError, "ports.dns", "invalid port"},
+		{21, SeverityWarning, "futureFeature", "unknown key"},
+	}
+
+	issues := ValidateConfig([]byte(config))
+	if len(issues) != len(want) {
+		t.Fatalf("got %d issues, want %d: %+v", len(issues), len(want), issues)
+	}
+	for _, w := range want {
+		found := false
+		for _, is := range issues {
+			if is.Line == w.line && is.Severity == w.severity && is.Path == w.path && strings.Contains(is.Message, w.message) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("missing %s on line %d at %s: %q", w.severity, w.line, w.path, w.message)
+		}
+	}
+}
+
+func TestValidateConfigStructure(t *testing.T) {
+	tests := []struct {
+		name, config, message string
+	}{
+		{"syntax", "blocking:\n  denylists: [a\n", "did not find expected"},
+		{"object as list", "blocking:\n  - denylists\n", "expected a mapping"},
+		{"duplicate key", "log:\n  level: info\n  level: debug\n", "duplicate key level, first defined on line 2"},
+		{"bad regex", "blocking:\n  denylists:\n    ads:\n      - |\n        /(unclosed/\n", "invalid regex"},
+		{"bad upstream", "upstreams:\n  groups:\n    default: [1.1.1.1:port]\n", "invalid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issues := ValidateConfig([]byte(tt.config))
+			if !HasErrors(issues) || !strings.Contains(issues[0].Message, tt.message) {
+				t.Errorf("issues = %+v, want error containing %q", issues, tt.message)
+			}
+		})
+	}
+}
+
+func TestValidateInlineListComments(t *testing.T) {
+	config := "blocking:\n  denylists:\n    ads:\n      - |\n        # trackers\n        ads.example.com # tracker\n        0.0.0.0 a.example b.example\t# hosts\n"
+	if issues := ValidateConfig([]byte(config)); len(issues) != 0 {
+		t.Errorf("issues = %+v, want none", issues)
+	}
+	config = "blocking:\n  denylists:\n    ads:\n      - |\n        ads.example.com#tracker\n"
+	if issues := ValidateConfig([]byte(config)); !HasErrors(issues) {
+		t.Error("# without whitespace before it taken as a comment")
+	}
+}
+
+func TestValidateListEntry(t *testing.T) {
+	valid := []string{"example.com", "example.com.", "*.example.com", `/^ad[sx]\./`, "10.0.0.1", "10.0.0.0/8", "0.0.0.0 ads.example.com tracker.example.com", "xn--bcher-kva.example"}
+	for _, e := range valid {
+		if err := ValidateListEntry(e); err != nil {
+			t.Errorf("ValidateListEntry(%q) = %v, want nil", e, err)
+		}
+	}
+	invalid := []string{"", "ads.*.example.com", "/[a-/", "exa mple.com", "-bad.example.com", "bad..example.com", "ex!ample.com"}
+	for _, e := range invalid {
+		if err := ValidateListEntry(e); err == nil {
+			t.Errorf("ValidateListEntry(%q) = nil, want error", e)
+		}
+	}
+}
+
+func TestWriteConfigRejectsInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, []byte(validConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := WriteConfig(path, []byte("ports:\n  dns: 70000\n"))
+	var verr *ValidationError
+	if !errors.As(err, &verr) || verr.Issues[0].Line != 2 {
+		t.Fatalf("WriteConfig error = %v, want validation error on line 2", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != validConfig {
+		t.Error("config was modified")
+	}
+	if backups, _ := filepath.Glob(path + ".bak.*"); len(backups) != 0 {
+		t.Errorf("backups = %v, want none", backups)
+	}
+}
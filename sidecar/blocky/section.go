@@ -0,0 +1,398 @@
+package blocky
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// ErrSectionNotFound is returned for a section path that is not in the
+	// config.
+	ErrSectionNotFound = errors.New("section not found")
+	// ErrInvalidPatch is returned for a section patch that is not JSON.
+	ErrInvalidPatch = errors.New("patch is not valid JSON")
+)
+
+// splitSectionPath splits a path like "blocking.denylists" into keys.
+// Numeric keys index lists.
+func splitSectionPath(path string) ([]string, error) {
+	keys := strings.Split(path, ".")
+	for _, k := range keys {
+		if k == "" {
+			return nil, fmt.Errorf("invalid section path %q", path)
+		}
+	}
+	return keys, nil
+}
+
+// GetSection returns the value at path in the config as JSON-compatible
+// data.
+func GetSection(data []byte, path string) (any, error) {
+	keys, err := splitSectionPath(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid YAML: %w", err)
+	}
+	n := docRoot(&doc)
+	for _, key := range keys {
+		if n = child(n, key); n == nil {
+			return nil, ErrSectionNotFound
+		}
+	}
+	return jsonValue(nodeValue(n)), nil
+}
+
+func child(n *yaml.Node, key string) *yaml.Node {
+	if n == nil {
+		return nil
+	}
+	switch n = resolve(n); n.Kind {
+	case yaml.MappingNode:
+		if v := mappingEntries(n).values[key]; v != nil {
+			return resolve(v)
+		}
+	case yaml.SequenceNode:
+		if i, err := strconv.Atoi(key); err == nil && i >= 0 && i < len(n.Content) {
+			return resolve(n.Content[i])
+		}
+	}
+	return nil
+}
+
+// jsonValue converts mappings with non-string keys, which encoding/json
+// cannot handle.
+func jsonValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			v[k] = jsonValue(e)
+		}
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = jsonValue(e)
+		}
+		return m
+	case []any:
+		for i, e := range v {
+			v[i] = jsonValue(e)
+		}
+	}
+	return v
+}
+
+// PatchSection applies a JSON merge patch (RFC 7396) to the section at path
+// and returns the new config: objects are merged, null removes a key, and
+// any other value replaces the section.
+func PatchSection(data []byte, path string, patch []byte) ([]byte, error) {
+	keys, err := splitSectionPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(patch) {
+		return nil, ErrInvalidPatch
+	}
+	var p yaml.Node
+	if err := yaml.Unmarshal(patch, &p); err != nil || len(p.Content) == 0 {
+		return nil, ErrInvalidPatch
+	}
+	value := p.Content[0]
+	plainStyle(value)
+
+	return editSection(data, keys[0], func(old *yaml.Node) (*yaml.Node, error) {
+		return patchAt(old, keys[1:], value)
+	})
+}
+
+// editSection replaces the value of the top-level key with what edit
+// returns for it; nil removes the key. Only that section is re-encoded, so
+// comments and formatting elsewhere are kept.
+func editSection(data []byte, top string, edit func(old *yaml.Node) (*yaml.Node, error)) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid YAML: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, errors.New("config is not a mapping")
+	}
+
+	lines := splitLines(data)
+	i := findKey(root, top)
+	start, end := len(lines), len(lines)
+	var key, old *yaml.Node
+	if i >= 0 {
+		key, old = root.Content[i], root.Content[i+1]
+		start, end = sectionLines(lines, root, i)
+	}
+	val, err := edit(old)
+	if err != nil {
+		return nil, err
+	}
+
+	var chunk []byte
+	switch {
+	case i >= 0 && val == nil:
+		root.Content = append(root.Content[:i], root.Content[i+2:]...)
+	case i >= 0:
+		root.Content[i+1] = val
+	case val != nil:
+		key = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: top}
+		root.Content = append(root.Content, key, val)
+	default:
+		return data, nil
+	}
+	indent := detectIndent(lines)
+	if val != nil {
+		if chunk, err = encodeSection(key, val, indent); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, l := range lines[:start] {
+		buf.WriteString(l)
+	}
+	if start > 0 && !strings.HasSuffix(lines[start-1], "\n") {
+		buf.WriteByte('\n')
+	}
+	buf.Write(chunk)
+	for _, l := range lines[end:] {
+		buf.WriteString(l)
+	}
+	out := buf.Bytes()
+
+	// The splice assumes a block mapping with one key per line; if the
+	// result does not decode to the edited config, encode it as a whole.
+	var check yaml.Node
+	if root.Style&yaml.FlowStyle != 0 || yaml.Unmarshal(out, &check) != nil ||
+		!reflect.DeepEqual(nodeValue(docRoot(&check)), nodeValue(root)) {
+		return encodeDoc(&doc, indent)
+	}
+	return out, nil
+}
+
+// patchAt applies patch to the node at keys below n and returns the new n,
+// or nil if it was removed.
+func patchAt(n *yaml.Node, keys []string, patch *yaml.Node) (*yaml.Node, error) {
+	if len(keys) == 0 {
+		return mergePatch(n, patch), nil
+	}
+	if n != nil && n.Kind == yaml.AliasNode {
+		return nil, fmt.Errorf("cannot edit %q through an alias", keys[0])
+	}
+	if n == nil || isNull(n) {
+		if isNull(patch) {
+			return n, nil
+		}
+		m := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		if n != nil {
+			copyComments(m, n)
+		}
+		n = m
+	}
+
+	switch n.Kind {
+	case yaml.MappingNode:
+		if i := findKey(n, keys[0]); i >= 0 {
+			v, err := patchAt(n.Content[i+1], keys[1:], patch)
+			if err != nil {
+				return nil, err
+			}
+			if v == nil {
+				n.Content = append(n.Content[:i], n.Content[i+2:]...)
+			} else {
+				n.Content[i+1] = v
+			}
+			return n, nil
+		}
+		v, err := patchAt(nil, keys[1:], patch)
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: keys[0]}, v)
+		}
+		return n, nil
+	case yaml.SequenceNode:
+		i, err := strconv.Atoi(keys[0])
+		if err != nil || i < 0 || i >= len(n.Content) {
+			return nil, ErrSectionNotFound
+		}
+		v, err := patchAt(n.Content[i], keys[1:], patch)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			n.Content = append(n.Content[:i], n.Content[i+1:]...)
+		} else {
+			n.Content[i] = v
+		}
+		return n, nil
+	}
+	return nil, fmt.Errorf("cannot edit %q inside a value", keys[0])
+}
+
+// mergePatch merges patch into dst as in RFC 7396 and returns the result,
+// or nil if patch is null.
+func mergePatch(dst, patch *yaml.Node) *yaml.Node {
+	if isNull(patch) {
+		return nil
+	}
+	if patch.Kind != yaml.MappingNode {
+		return replaceNode(dst, patch)
+	}
+	if dst == nil || dst.Kind != yaml.MappingNode {
+		m := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		if dst != nil {
+			copyComments(m, dst)
+		}
+		dst = m
+	}
+	for i := 0; i+1 < len(patch.Content); i += 2 {
+		key, val := patch.Content[i], patch.Content[i+1]
+		j := findKey(dst, key.Value)
+		if j < 0 {
+			if v := mergePatch(nil, val); v != nil {
+				dst.Content = append(dst.Content, key, v)
+			}
+			continue
+		}
+		if v := mergePatch(dst.Content[j+1], val); v != nil {
+			dst.Content[j+1] = v
+		} else {
+			dst.Content = append(dst.Content[:j], dst.Content[j+2:]...)
+		}
+	}
+	return dst
+}
+
+// replaceNode returns src in place of dst, keeping dst's comments and
+// style, and the list items of dst that src still contains.
+func replaceNode(dst, src *yaml.Node) *yaml.Node {
+	if dst == nil {
+		return src
+	}
+	if dst.Kind == yaml.ScalarNode && src.Kind == yaml.ScalarNode && dst.Value == src.Value && dst.Tag == src.Tag {
+		return dst
+	}
+	if dst.Kind == src.Kind && dst.Kind == yaml.SequenceNode {
+		used := make(map[*yaml.Node]bool)
+		for i, item := range src.Content {
+			for _, old := range dst.Content {
+				if !used[old] && old.Kind == yaml.ScalarNode && item.Kind == yaml.ScalarNode &&
+					old.Value == item.Value && old.Tag == item.Tag {
+					used[old] = true
+					src.Content[i] = old
+					break
+				}
+			}
+		}
+		src.Style = dst.Style
+	}
+	if dst.Kind == src.Kind && dst.Kind == yaml.ScalarNode && dst.Tag == src.Tag {
+		src.Style = dst.Style
+	}
+	copyComments(src, dst)
+	return src
+}
+
+func findKey(n *yaml.Node, key string) int {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key && n.Content[i].Tag != "!!merge" {
+			return i
+		}
+	}
+	return -1
+}
+
+func isNull(n *yaml.Node) bool {
+	return n.Kind == yaml.ScalarNode && n.Tag == "!!null"
+}
+
+func copyComments(dst, src *yaml.Node) {
+	dst.HeadComment = src.HeadComment
+	dst.LineComment = src.LineComment
+	dst.FootComment = src.FootComment
+}
+
+// plainStyle drops the JSON quoting and flow style of a parsed patch, so it
+// is written like the rest of the config.
+func plainStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, c := range n.Content {
+		plainStyle(c)
+	}
+}
+
+// sectionLines returns the lines [start, end) of the top-level pair at
+// root.Content[i], without the comments and blank lines leading into the
+// next section.
+func sectionLines(lines []string, root *yaml.Node, i int) (start, end int) {
+	start = root.Content[i].Line - 1
+	end = len(lines)
+	if i+2 < len(root.Content) {
+		end = root.Content[i+2].Line - 1
+	}
+	for end > start+1 && (strings.TrimSpace(lines[end-1]) == "" || strings.HasPrefix(lines[end-1], "#")) {
+		end--
+	}
+	return start, end
+}
+
+// encodeSection encodes one top-level pair. Comments above the key and
+// after the section stay in the original text, so they are left out.
+func encodeSection(key, val *yaml.Node, indent int) ([]byte, error) {
+	k := *key
+	k.HeadComment, k.FootComment = "", ""
+	m := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{&k, val}}
+	out, err := encodeDoc(m, indent)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for i, l := range splitLines(out) {
+		if i > 0 && strings.HasPrefix(l, "#") {
+			continue
+		}
+		buf.WriteString(l)
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeDoc(n *yaml.Node, indent int) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(indent)
+	if err := enc.Encode(n); err != nil {
+		return nil, fmt.Errorf("encode config: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("encode config: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// detectIndent returns the indentation of the first nested line, or 2.
+func detectIndent(lines []string) int {
+	for _, l := range lines {
+		t := strings.TrimLeft(l, " ")
+		if n := len(l) - len(t); n > 0 && strings.TrimSpace(t) != "" && t[0] != '#' {
+			return min(n, 8)
+		}
+	}
+	return 2
+}
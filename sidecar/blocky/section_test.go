@@ -0,0 +1,168 @@
+package blocky
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const sectionConfig = `# Blocky config
+upstreams:
+  groups:
+    default:
+      - 1.1.1.1 # cloudflare
+      - 9.9.9.9
+
+# Blocking
+blocking:
+  denylists:
+    ads:
+      - https://a.example/list.txt   # main list
+  clientGroupsBlock:
+    default: [ads]
+
+ports:
+  dns: 53 # standard
+`
+
+func TestGetSection(t *testing.T) {
+	got, err := GetSection([]byte(sectionConfig), "upstreams.groups.default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []any{"1.1.1.1", "9.9.9.9"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetSection = %#v, want %#v", got, want)
+	}
+	if got, _ := GetSection([]byte(sectionConfig), "upstreams.groups.default.1"); got != "9.9.9.9" {
+		t.Errorf("list index = %#v", got)
+	}
+	if _, err := GetSection([]byte(sectionConfig), "blocking.allowlists"); !errors.Is(err, ErrSectionNotFound) {
+		t.Errorf("missing section error = %v, want ErrSectionNotFound", err)
+	}
+	if _, err := GetSection([]byte(sectionConfig), "blocking..ads"); err == nil {
+		t.Error("empty path key accepted")
+	}
+}
+
+func TestPatchSection(t *testing.T) {
+	tests := []struct {
+		name, path, patch, want string
+	}{
+		{
+			name:  "replace list keeps item comments",
+			path:  "upstreams.groups.default",
+			patch: `["1.1.1.1", "8.8.8.8"]`,
+			want: `# Blocky config
+upstreams:
+  groups:
+    default:
+      - 1.1.1.1 # cloudflare
+      - 8.8.8.8
+
+# Blocking
+blocking:
+  denylists:
+    ads:
+      - https://a.example/list.txt   # main list
+  clientGroupsBlock:
+    default: [ads]
+
+ports:
+  dns: 53 # standard
+`,
+		},
+		{
+			name:  "merge object and delete key",
+			path:  "blocking",
+			patch: `{"clientGroupsBlock": null, "blockType": "nxDomain"}`,
+			want: `# Blocky config
+upstreams:
+  groups:
+    default:
+      - 1.1.1.1 # cloudflare
+      - 9.9.9.9
+
+# Blocking
+blocking:
+  denylists:
+    ads:
+      - https://a.example/list.txt # main list
+  blockType: nxDomain
+
+ports:
+  dns: 53 # standard
+`,
+		},
+		{
+			name:  "create nested section",
+			path:  "caching.prefetching",
+			patch: `true`,
+			want: sectionConfig + `caching:
+  prefetching: true
+`,
+		},
+		{
+			name:  "remove top-level section",
+			path:  "upstreams",
+			patch: `null`,
+			want: `# Blocky config
+
+# Blocking
+blocking:
+  denylists:
+    ads:
+      - https://a.example/list.txt   # main list
+  clientGroupsBlock:
+    default: [ads]
+
+ports:
+  dns: 53 # standard
+`,
+		},
+		{
+			name:  "string that looks like a number stays a string",
+			path:  "ports.http",
+			patch: `"4000"`,
+			want: `# Blocky config
+upstreams:
+  groups:
+    default:
+      - 1.1.1.1 # cloudflare
+      - 9.9.9.9
+
+# Blocking
+blocking:
+  denylists:
+    ads:
+      - https://a.example/list.txt   # main list
+  clientGroupsBlock:
+    default: [ads]
+
+ports:
+  dns: 53 # standard
+  http: "4000"
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PatchSection([]byte(sectionConfig), tt.path, []byte(tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("PatchSection =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := PatchSection([]byte(sectionConfig), "ports", []byte("{dns: 53}")); !errors.Is(err, ErrInvalidPatch) {
+		t.Errorf("YAML patch error = %v, want ErrInvalidPatch", err)
+	}
+	if _, err := PatchSection([]byte(sectionConfig), "ports.dns.udp", []byte("1")); err == nil {
+		t.Error("patch inside a scalar accepted")
+	}
+	if got, err := PatchSection([]byte(sectionConfig), "nothing", []byte("null")); err != nil || string(got) != sectionConfig {
+		t.Errorf("removing a missing key changed the config: %v", err)
+	}
+}
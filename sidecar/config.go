@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -15,12 +16,31 @@ type Config struct {
 	APIKey      string   `yaml:"api_key"`
 	CORSOrigins []string `yaml:"cors_origins"`
 	DNSResolver string   `yaml:"dns_resolver"`
+	DataDir     string   `yaml:"data_dir"`
 	Blocky      struct {
 		Dir         string `yaml:"dir"`
 		ConfigPath  string `yaml:"config_path"`
 		LogDir      string `yaml:"log_dir"`
 		ServiceName string `yaml:"service_name"`
 	} `yaml:"blocky"`
+	StatsCache struct {
+		MaxMB           int           `yaml:"max_mb"`
+		PrewarmDays     int           `yaml:"prewarm_days"`
+		RefreshInterval time.Duration `yaml:"refresh_interval"`
+	} `yaml:"stats_cache"`
+	Stream struct {
+		MaxReplay int           `yaml:"max_replay"`
+		Heartbeat time.Duration `yaml:"heartbeat"`
+	} `yaml:"stream"`
+	Backups struct {
+		Keep       int `yaml:"keep"`
+		MaxAgeDays int `yaml:"max_age_days"`
+	} `yaml:"backups"`
+	SearchIndex struct {
+		Dir       string `yaml:"dir"`
+		Disabled  bool   `yaml:"disabled"`
+		BuildDays int    `yaml:"build_days"`
+	} `yaml:"search_index"`
 }
 
 func LoadConfig(path string) (*Config, error) {
@@ -56,5 +76,40 @@ func LoadConfig(path string) (*Config, error) {
 		cfg.Blocky.ServiceName = "blocky"
 	}
 
+	if cfg.DataDir == "" {
+		cfg.DataDir = filepath.Join(dir, "visor-data")
+	}
+
+	if cfg.SearchIndex.Dir == "" {
+		cfg.SearchIndex.Dir = filepath.Join(cfg.Blocky.LogDir, ".visor-index")
+	}
+	if cfg.SearchIndex.Disabled {
+		cfg.SearchIndex.Dir = ""
+	}
+	if cfg.SearchIndex.BuildDays <= 0 {
+		cfg.SearchIndex.BuildDays = 30
+	}
+
+	if cfg.Stream.MaxReplay <= 0 {
+		cfg.Stream.MaxReplay = 1000
+	}
+	if cfg.Stream.Heartbeat <= 0 {
+		cfg.Stream.Heartbeat = 15 * time.Second
+	}
+
+	if cfg.Backups.Keep == 0 {
+		cfg.Backups.Keep = 50
+	}
+
+	if cfg.StatsCache.MaxMB == 0 {
+		cfg.StatsCache.MaxMB = 256
+	}
+	if cfg.StatsCache.PrewarmDays == 0 {
+		cfg.StatsCache.PrewarmDays = 7
+	}
+	if cfg.StatsCache.RefreshInterval == 0 {
+		cfg.StatsCache.RefreshInterval = time.Minute
+	}
+
 	return &cfg, nil
 }
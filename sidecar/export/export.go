@@ -0,0 +1,96 @@
+// Package export writes query log entries in file formats for offline analysis.
+package export
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/JCHHeilmann/blocky-visor/sidecar/logparser"
+)
+
+// Writer streams log entries in an export format. Close writes any trailing
+// data but does not close the underlying io.Writer.
+type Writer interface {
+	Write(e *logparser.LogEntry) error
+	Close() error
+}
+
+// Format describes a supported export format.
+type Format struct {
+	ContentType string
+	Extension   string
+	New         func(w io.Writer, withNames bool) Writer
+}
+
+var formats = map[string]Format{
+	"csv":     {ContentType: "text/csv; charset=utf-8", Extension: "csv", New: newCSVWriter},
+	"ndjson":  {ContentType: "application/x-ndjson", Extension: "ndjson", New: newNDJSONWriter},
+	"parquet": {ContentType: "application/vnd.apache.parquet", Extension: "parquet", New: newParquetWriter},
+}
+
+// Lookup returns the format with the given name.
+func Lookup(name string) (Format, error) {
+	f, ok := formats[name]
+	if !ok {
+		names := make([]string, 0, len(formats))
+		for n := range formats {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return Format{}, fmt.Errorf("unknown export format %q (supported: %v)", name, names)
+	}
+	return f, nil
+}
+
+// column is one exported field. Columns are shared by the CSV and Parquet
+// writers so both produce the same layout.
+type column struct {
+	name  string
+	kind  columnKind
+	str   func(e *logparser.LogEntry) string
+	float func(e *logparser.LogEntry) float64
+	time  func(e *logparser.LogEntry) time.Time
+}
+
+type columnKind int
+
+const (
+	kindString columnKind = iota
+	kindFloat
+	kindTime
+)
+
+func (c column) text(e *logparser.LogEntry) string {
+	switch c.kind {
+	case kindFloat:
+		return strconv.FormatFloat(c.float(e), 'f', -1, 64)
+	case kindTime:
+		return c.time(e).Format(time.RFC3339)
+	default:
+		return c.str(e)
+	}
+}
+
+func columns(withNames bool) []column {
+	cols := []column{
+		{name: "timestamp", kind: kindTime, time: func(e *logparser.LogEntry) time.Time { return e.Timestamp }},
+		{name: "client_ip", str: func(e *logparser.LogEntry) string { return e.ClientIP }},
+		{name: "client_name", str: func(e *logparser.LogEntry) string { return e.ClientName }},
+	}
+	if withNames {
+		cols = append(cols, column{name: "resolved_name", str: func(e *logparser.LogEntry) string { return e.ResolvedName }})
+	}
+	return append(cols,
+		column{name: "duration_ms", kind: kindFloat, float: func(e *logparser.LogEntry) float64 { return e.DurationMs }},
+		column{name: "response_reason", str: func(e *logparser.LogEntry) string { return e.ResponseReason }},
+		column{name: "domain", str: func(e *logparser.LogEntry) string { return e.Domain }},
+		column{name: "response_answer", str: func(e *logparser.LogEntry) string { return e.ResponseAnswer }},
+		column{name: "return_code", str: func(e *logparser.LogEntry) string { return e.ReturnCode }},
+		column{name: "response_category", str: func(e *logparser.LogEntry) string { return e.ResponseCategory }},
+		column{name: "query_type", str: func(e *logparser.LogEntry) string { return e.QueryType }},
+		column{name: "source", str: func(e *logparser.LogEntry) string { return e.Source }},
+	)
+}
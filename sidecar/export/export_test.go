@@ -0,0 +1,259 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JCHHeilmann/blocky-visor/sidecar/logparser"
+)
+
+func testEntries(n int) []*logparser.LogEntry {
+	base := time.Date(2026, 2, 14, 10, 0, 0, 0, time.UTC)
+	entries := make([]*logparser.LogEntry, n)
+	for i := range entries {
+		entries[i] = &logparser.LogEntry{
+			Timestamp:        base.Add(time.Duration(i) * time.Second),
+			ClientIP:         "10.0.0.1",
+			ClientName:       "PC",
+			ResolvedName:     "pc.lan",
+			DurationMs:       float64(i) + 0.5,
+			ResponseReason:   "RESOLVED",
+			Domain:           "example.com.",
+			ResponseAnswer:   "A (1.2.3.4)",
+			ReturnCode:       "NOERROR",
+			ResponseCategory: "RESOLVED",
+			QueryType:        "A",
+			Source:           "udp",
+		}
+	}
+	return entries
+}
+
+func writeAll(t *testing.T, format string, withNames bool, entries []*logparser.LogEntry) []byte {
+	t.Helper()
+	f, err := Lookup(format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := f.New(&buf, withNames)
+	for _, e := range entries {
+		if err := w.Write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestLookupUnknownFormat(t *testing.T) {
+	if _, err := Lookup("xlsx"); err == nil {
+		t.Error("Lookup(xlsx) succeeded, want error")
+	}
+}
+
+func TestCSVExport(t *testing.T) {
+	out := writeAll(t, "csv", false, testEntries(2))
+	rows, err := csv.NewReader(bytes.NewReader(out)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("rows = %d, want 3", len(rows))
+	}
+	if rows[0][0] != "timestamp" || rows[0][3] != "duration_ms" {
+		t.Errorf("header = %v", rows[0])
+	}
+	if rows[2][0] != "2026-02-14T10:00:01Z" || rows[2][3] != "1.5" {
+		t.Errorf("row = %v", rows[2])
+	}
+	if strings.Contains(string(out), "pc.lan") {
+		t.Error("resolved name exported without names=true")
+	}
+
+	empty := writeAll(t, "csv", true, nil)
+	if got := strings.TrimSpace(string(empty)); !strings.Contains(got, "resolved_name") || strings.Count(got, "\n") != 0 {
+		t.Errorf("empty export = %q, want header only", got)
+	}
+}
+
+func TestNDJSONExport(t *testing.T) {
+	entries := testEntries(3)
+	out := writeAll(t, "ndjson", false, entries)
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("lines = %d, want 3", len(lines))
+	}
+	var e logparser.LogEntry
+	if err := json.Unmarshal([]byte(lines[1]), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Domain != "example.com." || e.ResolvedName != "" {
+		t.Errorf("entry = %+v", e)
+	}
+	if entries[1].ResolvedName != "pc.lan" {
+		t.Error("writer modified the caller's entry")
+	}
+}
+
+func TestParquetExport(t *testing.T) {
+	n := parquetRowGroupSize + 5
+	entries := testEntries(n)
+	out := writeAll(t, "parquet", true, entries)
+
+	if !bytes.HasPrefix(out, []byte("PAR1")) || !bytes.HasSuffix(out, []byte("PAR1")) {
+		t.Fatal("missing PAR1 magic")
+	}
+	metaLen := int(binary.LittleEndian.Uint32(out[len(out)-8:]))
+	meta := readThriftStruct(t, out[len(out)-8-metaLen:len(out)-8])
+
+	if got := meta[3].(int64); got != int64(n) {
+		t.Errorf("num_rows = %d, want %d", got, n)
+	}
+	schema := meta[2].([]any)
+	cols := columns(true)
+	if len(schema) != len(cols)+1 {
+		t.Fatalf("schema elements = %d, want %d", len(schema), len(cols)+1)
+	}
+	for i, col := range cols {
+		if name := schema[i+1].(map[int16]any)[4].(string); name != col.name {
+			t.Errorf("schema[%d] = %q, want %q", i+1, name, col.name)
+		}
+	}
+
+	groups := meta[4].([]any)
+	if len(groups) != 2 {
+		t.Fatalf("row groups = %d, want 2", len(groups))
+	}
+	var rows int64
+	for _, g := range groups {
+		rows += g.(map[int16]any)[3].(int64)
+	}
+	if rows != int64(n) {
+		t.Errorf("row group rows = %d, want %d", rows, n)
+	}
+
+	// Decode the duration column of the second row group and check its values.
+	chunk := groups[1].(map[int16]any)[1].([]any)[4].(map[int16]any)
+	cm := chunk[3].(map[int16]any)
+	if name := cm[3].([]any)[0].(string); name != "duration_ms" {
+		t.Fatalf("column path = %q, want duration_ms", name)
+	}
+	off := cm[9].(int64)
+	size := cm[7].(int64)
+	page := out[off : off+size]
+	r := &thriftReader{t: t, buf: page}
+	header := r.readStruct()
+	values := r.buf[r.pos:]
+	if got := header[2].(int64); got != int64(len(values)) {
+		t.Fatalf("page size = %d, want %d", got, len(values))
+	}
+	for i := range 5 {
+		got := math.Float64frombits(binary.LittleEndian.Uint64(values[i*8:]))
+		want := entries[parquetRowGroupSize+i].DurationMs
+		if got != want {
+			t.Errorf("duration[%d] = %v, want %v", i, got, want)
+		}
+	}
+}
+
+// thriftReader is a small Thrift compact protocol decoder for checking the
+// writer's output. Structs decode to map[field id]value, integers to int64.
+type thriftReader struct {
+	t   *testing.T
+	buf []byte
+	pos int
+}
+
+func readThriftStruct(t *testing.T, b []byte) map[int16]any {
+	r := &thriftReader{t: t, buf: b}
+	s := r.readStruct()
+	if r.pos != len(b) {
+		t.Fatalf("thrift: %d trailing bytes", len(b)-r.pos)
+	}
+	return s
+}
+
+func (r *thriftReader) byte() byte {
+	b := r.buf[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *thriftReader) varint() int64 {
+	v, n := binary.Varint(r.buf[r.pos:])
+	if n <= 0 {
+		r.t.Fatal("thrift: bad varint")
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		r.t.Fatal("thrift: bad uvarint")
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) readStruct() map[int16]any {
+	s := map[int16]any{}
+	var id int16
+	for {
+		h := r.byte()
+		if h == 0 {
+			return s
+		}
+		typ := h & 0x0f
+		if delta := h >> 4; delta != 0 {
+			id += int16(delta)
+		} else {
+			id = int16(r.varint())
+		}
+		switch typ {
+		case thriftTrue:
+			s[id] = true
+		case thriftFalse:
+			s[id] = false
+		default:
+			s[id] = r.value(typ)
+		}
+	}
+}
+
+func (r *thriftReader) value(typ byte) any {
+	switch typ {
+	case thriftI32, thriftI64:
+		return r.varint()
+	case thriftBinary:
+		n := int(r.uvarint())
+		s := string(r.buf[r.pos : r.pos+n])
+		r.pos += n
+		return s
+	case thriftList:
+		h := r.byte()
+		n := int(h >> 4)
+		if n == 15 {
+			n = int(r.uvarint())
+		}
+		list := make([]any, n)
+		for i := range list {
+			list[i] = r.value(h & 0x0f)
+		}
+		return list
+	case thriftStruct:
+		return r.readStruct()
+	}
+	r.t.Fatalf("thrift: unsupported type %d", typ)
+	return nil
+}
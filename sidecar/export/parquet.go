@@ -0,0 +1,306 @@
+package export
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/JCHHeilmann/blocky-visor/sidecar/logparser"
+)
+
+// parquetWriter writes a minimal Apache Parquet file: all columns are
+// REQUIRED, PLAIN-encoded and uncompressed, one data page per column chunk.
+// Rows are buffered into row groups of parquetRowGroupSize, so memory use is
+// bounded regardless of the export size; only the per-row-group metadata is
+// kept until the footer is written.
+
+const parquetRowGroupSize = 10000
+
+// Parquet enum values from parquet.thrift.
+const (
+	ptInt64     = 2
+	ptDouble    = 5
+	ptByteArray = 6
+
+	repRequired = 0
+
+	ctUTF8            = 0
+	ctTimestampMillis = 9
+
+	encPlain = 0
+	encRLE   = 3
+
+	pageData = 0
+)
+
+type parquetWriter struct {
+	w      io.Writer
+	err    error
+	offset int64
+	cols   []column
+	pages  [][]byte // plain-encoded values of the current row group, per column
+	rows   int
+	total  int64
+	groups []rowGroupMeta
+}
+
+type rowGroupMeta struct {
+	rows    int
+	size    int64
+	columns []chunkMeta
+}
+
+type chunkMeta struct {
+	offset int64
+	size   int64
+}
+
+func newParquetWriter(w io.Writer, withNames bool) Writer {
+	cols := columns(withNames)
+	p := &parquetWriter{w: w, cols: cols, pages: make([][]byte, len(cols))}
+	p.write([]byte("PAR1"))
+	return p
+}
+
+func (p *parquetWriter) write(b []byte) {
+	if p.err != nil {
+		return
+	}
+	n, err := p.w.Write(b)
+	p.offset += int64(n)
+	p.err = err
+}
+
+func (p *parquetWriter) Write(e *logparser.LogEntry) error {
+	for i, col := range p.cols {
+		buf := p.pages[i]
+		switch col.kind {
+		case kindTime:
+			buf = binary.LittleEndian.AppendUint64(buf, uint64(col.time(e).UnixMilli()))
+		case kindFloat:
+			buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(col.float(e)))
+		default:
+			s := col.str(e)
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(len(s)))
+			buf = append(buf, s...)
+		}
+		p.pages[i] = buf
+	}
+	p.rows++
+	if p.rows >= parquetRowGroupSize {
+		p.flushRowGroup()
+	}
+	return p.err
+}
+
+func (p *parquetWriter) flushRowGroup() {
+	if p.rows == 0 {
+		return
+	}
+	g := rowGroupMeta{rows: p.rows, columns: make([]chunkMeta, len(p.cols))}
+	for i := range p.cols {
+		var t thriftWriter
+		t.i32(1, pageData)
+		t.i32(2, int32(len(p.pages[i])))
+		t.i32(3, int32(len(p.pages[i])))
+		t.beginStruct(5) // DataPageHeader
+		t.i32(1, int32(p.rows))
+		t.i32(2, encPlain)
+		t.i32(3, encRLE)
+		t.i32(4, encRLE)
+		t.endStruct()
+		header := t.finish()
+
+		start := p.offset
+		p.write(header)
+		p.write(p.pages[i])
+		g.columns[i] = chunkMeta{offset: start, size: p.offset - start}
+		g.size += p.offset - start
+		p.pages[i] = p.pages[i][:0]
+	}
+	p.groups = append(p.groups, g)
+	p.total += int64(p.rows)
+	p.rows = 0
+}
+
+func (p *parquetWriter) Close() error {
+	p.flushRowGroup()
+
+	var t thriftWriter
+	t.i32(1, 1) // version
+
+	t.listHeader(2, thriftStruct, len(p.cols)+1)
+	t.beginElem()
+	t.binary(4, "schema")
+	t.i32(5, int32(len(p.cols)))
+	t.endStruct()
+	for _, col := range p.cols {
+		t.beginElem()
+		switch col.kind {
+		case kindTime:
+			t.i32(1, ptInt64)
+			t.i32(3, repRequired)
+			t.binary(4, col.name)
+			t.i32(6, ctTimestampMillis)
+			t.beginStruct(10) // LogicalType
+			t.beginStruct(8)  // TIMESTAMP
+			t.boolean(1, true)
+			t.beginStruct(2) // TimeUnit
+			t.beginStruct(1) // MILLIS
+			t.endStruct()
+			t.endStruct()
+			t.endStruct()
+			t.endStruct()
+		case kindFloat:
+			t.i32(1, ptDouble)
+			t.i32(3, repRequired)
+			t.binary(4, col.name)
+		default:
+			t.i32(1, ptByteArray)
+			t.i32(3, repRequired)
+			t.binary(4, col.name)
+			t.i32(6, ctUTF8)
+			t.beginStruct(10) // LogicalType
+			t.beginStruct(1)  // STRING
+			t.endStruct()
+			t.endStruct()
+		}
+		t.endStruct()
+	}
+
+	t.i64(3, p.total)
+
+	t.listHeader(4, thriftStruct, len(p.groups))
+	for _, g := range p.groups {
+		t.beginElem()
+		t.listHeader(1, thriftStruct, len(g.columns))
+		for i, c := range g.columns {
+			col := p.cols[i]
+			t.beginElem()
+			t.i64(2, c.offset)
+			t.beginStruct(3) // ColumnMetaData
+			switch col.kind {
+			case kindTime:
+				t.i32(1, ptInt64)
+			case kindFloat:
+				t.i32(1, ptDouble)
+			default:
+				t.i32(1, ptByteArray)
+			}
+			t.listHeader(2, thriftI32, 2)
+			t.elemI32(encPlain)
+			t.elemI32(encRLE)
+			t.listHeader(3, thriftBinary, 1)
+			t.elemBinary(col.name)
+			t.i32(4, 0) // UNCOMPRESSED
+			t.i64(5, int64(g.rows))
+			t.i64(6, c.size)
+			t.i64(7, c.size)
+			t.i64(9, c.offset)
+			t.endStruct()
+			t.endStruct()
+		}
+		t.i64(2, g.size)
+		t.i64(3, int64(g.rows))
+		t.endStruct()
+	}
+	t.binary(6, "blocky-visor-sidecar")
+	meta := t.finish()
+
+	p.write(meta)
+	p.write(binary.LittleEndian.AppendUint32(nil, uint32(len(meta))))
+	p.write([]byte("PAR1"))
+	return p.err
+}
+
+// thriftWriter encodes structs with the Thrift compact protocol, which is
+// what Parquet uses for page headers and file metadata. It starts inside an
+// implicit top-level struct that finish terminates.
+type thriftWriter struct {
+	buf  []byte
+	last []int16 // last field id per open struct
+	cur  int16
+}
+
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+func (t *thriftWriter) fieldHeader(typ byte, id int16) {
+	if delta := id - t.cur; delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.buf = binary.AppendVarint(t.buf, int64(id))
+	}
+	t.cur = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.fieldHeader(thriftI32, id)
+	t.buf = binary.AppendVarint(t.buf, int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.fieldHeader(thriftI64, id)
+	t.buf = binary.AppendVarint(t.buf, v)
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.fieldHeader(thriftBinary, id)
+	t.elemBinary(s)
+}
+
+func (t *thriftWriter) boolean(id int16, v bool) {
+	if v {
+		t.fieldHeader(thriftTrue, id)
+	} else {
+		t.fieldHeader(thriftFalse, id)
+	}
+}
+
+func (t *thriftWriter) beginStruct(id int16) {
+	t.fieldHeader(thriftStruct, id)
+	t.beginElem()
+}
+
+// beginElem opens a struct that is a list element (no field header).
+func (t *thriftWriter) beginElem() {
+	t.last = append(t.last, t.cur)
+	t.cur = 0
+}
+
+func (t *thriftWriter) endStruct() {
+	t.buf = append(t.buf, 0)
+	t.cur = t.last[len(t.last)-1]
+	t.last = t.last[:len(t.last)-1]
+}
+
+func (t *thriftWriter) listHeader(id int16, elemType byte, n int) {
+	t.fieldHeader(thriftList, id)
+	if n < 15 {
+		t.buf = append(t.buf, byte(n)<<4|elemType)
+	} else {
+		t.buf = append(t.buf, 0xf0|elemType)
+		t.buf = binary.AppendUvarint(t.buf, uint64(n))
+	}
+}
+
+func (t *thriftWriter) elemI32(v int32) {
+	t.buf = binary.AppendVarint(t.buf, int64(v))
+}
+
+func (t *thriftWriter) elemBinary(s string) {
+	t.buf = binary.AppendUvarint(t.buf, uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
+
+func (t *thriftWriter) finish() []byte {
+	return append(t.buf, 0)
+}
@@ -0,0 +1,74 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+
+	"github.com/JCHHeilmann/blocky-visor/sidecar/logparser"
+)
+
+type csvWriter struct {
+	w      *csv.Writer
+	cols   []column
+	header bool
+	record []string
+}
+
+func newCSVWriter(w io.Writer, withNames bool) Writer {
+	cols := columns(withNames)
+	return &csvWriter{w: csv.NewWriter(w), cols: cols, record: make([]string, len(cols))}
+}
+
+func (c *csvWriter) Write(e *logparser.LogEntry) error {
+	if !c.header {
+		for i, col := range c.cols {
+			c.record[i] = col.name
+		}
+		if err := c.w.Write(c.record); err != nil {
+			return err
+		}
+		c.header = true
+	}
+	for i, col := range c.cols {
+		c.record[i] = col.text(e)
+	}
+	return c.w.Write(c.record)
+}
+
+func (c *csvWriter) Close() error {
+	if !c.header {
+		// Always emit the header, even for an empty export.
+		for i, col := range c.cols {
+			c.record[i] = col.name
+		}
+		c.w.Write(c.record)
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type ndjsonWriter struct {
+	bw        *bufio.Writer
+	enc       *json.Encoder
+	withNames bool
+}
+
+func newNDJSONWriter(w io.Writer, withNames bool) Writer {
+	bw := bufio.NewWriter(w)
+	return &ndjsonWriter{bw: bw, enc: json.NewEncoder(bw), withNames: withNames}
+}
+
+func (n *ndjsonWriter) Write(e *logparser.LogEntry) error {
+	if !n.withNames && e.ResolvedName != "" {
+		cp := *e
+		cp.ResolvedName = ""
+		e = &cp
+	}
+	return n.enc.Encode(e)
+}
+
+func (n *ndjsonWriter) Close() error {
+	return n.bw.Flush()
+}
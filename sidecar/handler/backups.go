@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/JCHHeilmann/blocky-visor/sidecar/blocky"
+	"github.com/go-chi/chi/v5"
+)
+
+func ListBackups(configPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		backups, err := blocky.ListBackups(configPath)
+		if err != nil {
+			http.Error(w, jsonErr(err.Error()), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backups)
+	}
+}
+
+func GetBackup(configPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := blocky.ReadBackup(configPath, chi.URLParam(r, "id"))
+		if err != nil {
+			writeBackupErr(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(data)
+	}
+}
+
+// DiffBackup shows what restoring a backup would change in the current
+// config.
+func DiffBackup(configPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := blocky.ReadBackup(configPath, chi.URLParam(r, "id"))
+		if err != nil {
+			writeBackupErr(w, err)
+			return
+		}
+		current, err := blocky.ReadConfig(configPath)
+		if err != nil {
+			http.Error(w, jsonErr(err.Error()), http.StatusInternalServerError)
+			return
+		}
+		diff, err := blocky.DiffConfig(current, data)
+		if err != nil {
+			http.Error(w, jsonErr(err.Error()), http.StatusUnprocessableEntity)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(diff)
+	}
+}
+
+// RestoreBackup replaces the config with a backup. Like PutConfig, it
+// requires the If-Match header to carry the ETag of the current config.
+func RestoreBackup(configPath string, retention blocky.RetentionPolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ifMatch := r.Header.Get("If-Match")
+		if ifMatch == "" {
+			http.Error(w, jsonErr("If-Match header required"), http.StatusPreconditionRequired)
+			return
+		}
+		id := chi.URLParam(r, "id")
+		previous, _ := blocky.ReadConfig(configPath)
+		backupPath, warnings, err := blocky.RestoreBackup(configPath, id, ifMatch)
+		if errors.Is(err, blocky.ErrBackupNotFound) {
+			writeBackupErr(w, err)
+			return
+		}
+		if err != nil {
+			writeSaveErr(w, configPath, err)
+			return
+		}
+		restored, err := blocky.ReadConfig(configPath)
+		if err == nil {
+			logConfigChanges(previous, restored)
+		}
+		pruneBackups(configPath, retention)
+
+		etag := blocky.ConfigETag(restored)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", etag)
+		json.NewEncoder(w).Encode(map[string]any{
+			"status":   "restored",
+			"restored": id,
+			"backup":   backupPath,
+			"etag":     etag,
+			"warnings": nonNilIssues(warnings),
+		})
+	}
+}
+
+// pruneBackups applies the retention policy after a new backup was made.
+func pruneBackups(configPath string, retention blocky.RetentionPolicy) {
+	removed, err := blocky.PruneBackups(configPath, retention)
+	if err != nil {
+		log.Printf("prune config backups: %v", err)
+	}
+	if len(removed) > 0 {
+		log.Printf("pruned %d config backups", len(removed))
+	}
+}
+
+func writeBackupErr(w http.ResponseWriter, err error) {
+	if errors.Is(err, blocky.ErrBackupNotFound) {
+		http.Error(w, jsonErr(err.Error()), http.StatusNotFound)
+		return
+	}
+	http.Error(w, jsonErr(err.Error()), http.StatusInternalServerError)
+}
@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/netip"
+
+	"github.com/JCHHeilmann/blocky-visor/sidecar/blocky"
+	"github.com/JCHHeilmann/blocky-visor/sidecar/resolver"
+)
+
+type clientGroupsRequest struct {
+	Client string    `json:"client"`
+	Groups *[]string `json:"groups"`
+	Apply  string    `json:"apply"`
+}
+
+// GetClientGroups returns the clientGroupsBlock entries and the denylist
+// groups clients can be assigned to.
+func GetClientGroups(configPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := blocky.ReadConfig(configPath)
+		if err != nil {
+			http.Error(w, jsonErr(err.Error()), http.StatusInternalServerError)
+			return
+		}
+		clients, err := blocky.ClientGroups(data)
+		if err != nil {
+			http.Error(w, jsonErr(err.Error()), http.StatusInternalServerError)
+			return
+		}
+		groups, err := blocky.DenylistGroups(data)
+		if err != nil {
+			http.Error(w, jsonErr(err.Error()), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", blocky.ConfigETag(data))
+		json.NewEncoder(w).Encode(map[string]any{
+			"clients": clients,
+			"groups":  groups,
+		})
+	}
+}
+
+// SetClientGroups assigns a client (IP, name or CIDR) to denylist groups,
+// replacing the groups it had. The client is in the body rather than the
+// path because a CIDR contains a slash.
+func SetClientGroups(configPath, serviceName string, retention blocky.RetentionPolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var in clientGroupsRequest
+		defer r.Body.Close()
+		if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&in); err != nil {
+			http.Error(w, jsonErr("invalid JSON body"), http.StatusBadRequest)
+			return
+		}
+		// Blocky gives a client without groups the default ones, so an
+		// empty list would not do what it seems to.
+		if in.Groups == nil || len(*in.Groups) == 0 {
+			http.Error(w, jsonErr("groups is required; use DELETE to put a client back on the default groups"), http.StatusBadRequest)
+			return
+		}
+		saveClientGroups(w, r, configPath, serviceName, retention, in.Client, *in.Groups, in.Apply)
+	}
+}
+
+// RemoveClientGroups removes a client from clientGroupsBlock, so it falls
+// back to the default groups. The client and apply options are query
+// parameters.
+func RemoveClientGroups(configPath, serviceName string, retention blocky.RetentionPolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		saveClientGroups(w, r, configPath, serviceName, retention, q.Get("client"), nil, q.Get("apply"))
+	}
+}
+
+// MatchClientGroups shows which entries and groups Blocky applies to a
+// client. Names are looked up from the IP if none are given.
+func MatchClientGroups(configPath string, hr *resolver.HostResolver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := r.URL.Query().Get("ip")
+		names := r.URL.Query()["name"]
+		if ip == "" && len(names) == 0 {
+			http.Error(w, jsonErr("ip or name is required"), http.StatusBadRequest)
+			return
+		}
+		if ip != "" {
+			if _, err := netip.ParseAddr(ip); err != nil {
+				http.Error(w, jsonErr("invalid ip"), http.StatusBadRequest)
+				return
+			}
+			if len(names) == 0 {
+				if name := hr.Lookup(ip); name != "" {
+					names = []string{name}
+				}
+			}
+		}
+
+		data, err := blocky.ReadConfig(configPath)
+		if err != nil {
+			http.Error(w, jsonErr(err.Error()), http.StatusInternalServerError)
+			return
+		}
+		m, err := blocky.MatchClientGroups(data, ip, names)
+		if err != nil {
+			http.Error(w, jsonErr(err.Error()), http.StatusInternalServerError)
+			return
+		}
+		if names == nil {
+			names = []string{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"ip":      ip,
+			"names":   names,
+			"groups":  m.Groups,
+			"matches": m.Matches,
+			"default": m.Default,
+		})
+	}
+}
+
+// saveClientGroups writes a client's groups (nil removes the client) like
+// PatchSection, so the If-Match header carrying the ETag from
+// GetClientGroups is required. It restarts Blocky if asked to, as Blocky
+// only reads clientGroupsBlock on start.
+func saveClientGroups(w http.ResponseWriter, r *http.Request, configPath, serviceName string, retention blocky.RetentionPolicy, client string, groups []string, apply string) {
+	if client == "" {
+		http.Error(w, jsonErr("client is required"), http.StatusBadRequest)
+		return
+	}
+	if apply != "" && apply != blocky.ApplyRestart {
+		http.Error(w, jsonErr("apply must be restart"), http.StatusBadRequest)
+		return
+	}
+
+	current, ok := readConfigIfMatch(w, r, configPath)
+	if !ok {
+		return
+	}
+	data, err := blocky.SetClientGroups(current, client, groups)
+	if err != nil {
+		writeClientGroupsErr(w, err)
+		return
+	}
+
+	resp := map[string]any{"status": "unchanged", "client": client}
+	if groups != nil {
+		resp["groups"] = groups
+	}
+	if !bytes.Equal(data, current) {
+		backupPath, warnings, err := blocky.WriteConfigIfMatch(configPath, data, blocky.ConfigETag(current))
+		if err != nil {
+			writeSaveErr(w, configPath, err)
+			return
+		}
+		if groups == nil {
+			log.Printf("clientGroupsBlock: removed %s", client)
+			resp["status"] = "removed"
+		} else {
+			log.Printf("clientGroupsBlock: %s -> %v", client, groups)
+			resp["status"] = "saved"
+		}
+		pruneBackups(configPath, retention)
+		resp["backup"] = backupPath
+		resp["warnings"] = nonNilIssues(warnings)
+		applyListChange(configPath, serviceName, apply, resp)
+	}
+
+	etag := blocky.ConfigETag(data)
+	resp["etag"] = etag
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func writeClientGroupsErr(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, blocky.ErrInvalidClient), errors.Is(err, blocky.ErrUnknownListGroup):
+		http.Error(w, jsonErr(err.Error()), http.StatusBadRequest)
+	default:
+		http.Error(w, jsonErr(err.Error()), http.StatusInternalServerError)
+	}
+}
@@ -2,8 +2,11 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
+	"log"
 	"net/http"
+	"strings"
 
 	"github.com/JCHHeilmann/blocky-visor/sidecar/blocky"
 )
@@ -16,11 +19,14 @@ func GetConfig(configPath string) http.HandlerFunc {
 			return
 		}
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("ETag", blocky.ConfigETag(data))
 		w.Write(data)
 	}
 }
 
-func PutConfig(configPath string) http.HandlerFunc {
+// PutConfig saves a new config. The If-Match header must carry the ETag
+// from GetConfig, so a save never overwrites changes made in between.
+func PutConfig(configPath string, retention blocky.RetentionPolicy) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		data, err := io.ReadAll(io.LimitReader(r.Body, 1<<20)) // 1MB max
 		if err != nil {
@@ -29,25 +35,125 @@ func PutConfig(configPath string) http.HandlerFunc {
 		}
 		defer r.Body.Close()
 
+		ifMatch := r.Header.Get("If-Match")
+		if ifMatch == "" {
+			http.Error(w, jsonErr("If-Match header required"), http.StatusPreconditionRequired)
+			return
+		}
 		if len(data) == 0 {
 			http.Error(w, jsonErr("empty config body"), http.StatusBadRequest)
 			return
 		}
 
-		backupPath, err := blocky.WriteConfig(configPath, data)
+		previous, backupPath, warnings, err := blocky.ReplaceConfig(configPath, data, ifMatch)
 		if err != nil {
-			http.Error(w, jsonErr(err.Error()), http.StatusBadRequest)
+			writeSaveErr(w, configPath, err)
 			return
 		}
+		logConfigChanges(previous, data)
+		pruneBackups(configPath, retention)
 
+		etag := blocky.ConfigETag(data)
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{
-			"status": "saved",
-			"backup": backupPath,
+		w.Header().Set("ETag", etag)
+		json.NewEncoder(w).Encode(map[string]any{
+			"status":   "saved",
+			"backup":   backupPath,
+			"etag":     etag,
+			"warnings": nonNilIssues(warnings),
 		})
 	}
 }
 
+// DiffConfig compares the live config with the proposed one in the body
+// without writing anything. Schema issues of the proposed config are
+// included so a review step can show them too.
+func DiffConfig(configPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(io.LimitReader(r.Body, 1<<20)) // 1MB max
+		if err != nil {
+			http.Error(w, jsonErr("failed to read body"), http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		current, err := blocky.ReadConfig(configPath)
+		if err != nil {
+			http.Error(w, jsonErr(err.Error()), http.StatusInternalServerError)
+			return
+		}
+		diff, err := blocky.DiffConfig(current, data)
+		if err != nil {
+			http.Error(w, jsonErr(err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			*blocky.ConfigDiff
+			Issues []blocky.ConfigIssue `json:"issues"`
+		}{diff, nonNilIssues(blocky.ValidateConfig(data))})
+	}
+}
+
+// logConfigChanges records which keys a save changed.
+func logConfigChanges(old, new []byte) {
+	diff, err := blocky.DiffConfig(old, new)
+	if err != nil || len(diff.Changes) == 0 {
+		return
+	}
+	paths := make([]string, 0, len(diff.Changes))
+	for _, c := range diff.Changes {
+		paths = append(paths, c.Type+" "+c.Path)
+	}
+	log.Printf("config saved: %s", strings.Join(paths, ", "))
+}
+
+// writeConfigIssues rejects a config that failed schema validation.
+func writeConfigIssues(w http.ResponseWriter, issues []blocky.ConfigIssue) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error":  (&blocky.ValidationError{Issues: issues}).Error(),
+		"issues": issues,
+	})
+}
+
+// writeSaveErr maps a failed WriteConfigIfMatch to 422 for a config that
+// failed validation, 412 for one that changed since it was read and 500
+// otherwise.
+func writeSaveErr(w http.ResponseWriter, configPath string, err error) {
+	var verr *blocky.ValidationError
+	switch {
+	case errors.As(err, &verr):
+		writeConfigIssues(w, verr.Issues)
+	case errors.Is(err, blocky.ErrConfigChanged):
+		writeConfigConflict(w, configPath)
+	default:
+		http.Error(w, jsonErr(err.Error()), http.StatusInternalServerError)
+	}
+}
+
+// writeConfigConflict answers a save based on an outdated config with the
+// current ETag, so the client can reload and retry.
+func writeConfigConflict(w http.ResponseWriter, configPath string) {
+	resp := map[string]string{"error": blocky.ErrConfigChanged.Error()}
+	if current, err := blocky.ReadConfig(configPath); err == nil {
+		resp["etag"] = blocky.ConfigETag(current)
+		w.Header().Set("ETag", resp["etag"])
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusPreconditionFailed)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func nonNilIssues(issues []blocky.ConfigIssue) []blocky.ConfigIssue {
+	if issues == nil {
+		return []blocky.ConfigIssue{}
+	}
+	return issues
+}
+
 func jsonErr(msg string) string {
 	b, _ := json.Marshal(map[string]string{"error": msg})
 	return string(b)
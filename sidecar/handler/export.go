@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/JCHHeilmann/blocky-visor/sidecar/export"
+	"github.com/JCHHeilmann/blocky-visor/sidecar/logparser"
+	"github.com/JCHHeilmann/blocky-visor/sidecar/resolver"
+)
+
+func ExportLogs(logDir string, hr *resolver.HostResolver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start, end := parseLogsRange(r)
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "csv"
+		}
+		f, err := export.Lookup(format)
+		if err != nil {
+			http.Error(w, jsonErr(err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		query, err := parseFilter(r)
+		if err != nil {
+			http.Error(w, jsonErr(err.Error()), http.StatusBadRequest)
+			return
+		}
+		withNames := r.URL.Query().Get("names") == "true"
+
+		filename := fmt.Sprintf("blocky-logs_%s_%s.%s", start.Format("2006-01-02"), end.Format("2006-01-02"), f.Extension)
+		w.Header().Set("Content-Type", f.ContentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+		ew := f.New(w, withNames)
+		ctx := r.Context()
+		var writeErr error
+		err = logparser.ScanForward(logDir, start, end, func(e *logparser.LogEntry) bool {
+			if withNames || query.NeedsResolvedName() {
+				e.ResolvedName = hr.Lookup(e.ClientIP)
+			}
+			if !query.Match(e) {
+				return true
+			}
+			if writeErr = ew.Write(e); writeErr != nil {
+				return false
+			}
+			return ctx.Err() == nil
+		})
+		if err == nil {
+			err = writeErr
+		}
+		if err == nil {
+			err = ew.Close()
+		}
+		// Headers are already sent; all we can do is stop and log.
+		if err != nil && ctx.Err() == nil {
+			log.Printf("export logs: %v", err)
+		}
+	}
+}
@@ -3,9 +3,16 @@ package handler
 import (
 	"encoding/json"
 	"net/http"
+
+	"github.com/JCHHeilmann/blocky-visor/sidecar/logparser"
 )
 
-func Health(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+func Health(cache *logparser.StatsCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"status": "ok",
+			"warmup": cache.Warmup(),
+		})
+	}
 }
@@ -0,0 +1,196 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/JCHHeilmann/blocky-visor/sidecar/blocky"
+	"github.com/go-chi/chi/v5"
+)
+
+// listKinds maps the {kind} URL parameter to the config key.
+var listKinds = map[string]string{
+	"allow": blocky.Allowlist,
+	"deny":  blocky.Denylist,
+}
+
+type listEntryRequest struct {
+	Entry  string `json:"entry"`
+	Source string `json:"source"`
+	Apply  string `json:"apply"`
+}
+
+// GetLists returns the allow- and denylist groups with their sources.
+func GetLists(configPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := blocky.ReadConfig(configPath)
+		if err != nil {
+			http.Error(w, jsonErr(err.Error()), http.StatusInternalServerError)
+			return
+		}
+		resp := make(map[string]map[string][]blocky.ListSource, len(listKinds))
+		for _, kind := range listKinds {
+			groups, err := blocky.ListGroups(configPath, data, kind)
+			if err != nil {
+				http.Error(w, jsonErr(err.Error()), http.StatusInternalServerError)
+				return
+			}
+			resp[kind] = groups
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// AddListEntry adds a domain, wildcard or regex to an allow- or denylist
+// group, inline in the config or in one of the group's list files.
+func AddListEntry(configPath, serviceName string, retention blocky.RetentionPolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var in listEntryRequest
+		defer r.Body.Close()
+		if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&in); err != nil {
+			http.Error(w, jsonErr("invalid JSON body"), http.StatusBadRequest)
+			return
+		}
+		kind, ok := checkListRequest(w, r, in)
+		if !ok {
+			return
+		}
+		group := chi.URLParam(r, "group")
+		edit, err := blocky.AddListEntry(configPath, kind, group, in.Entry, in.Source)
+		if err != nil {
+			writeListErr(w, err)
+			return
+		}
+
+		resp := map[string]any{"status": "exists", "source": edit.Source}
+		if edit.Changed {
+			log.Printf("%s.%s: added %s to %s", kind, group, in.Entry, edit.Source)
+			resp["status"] = "added"
+			resp["backup"] = edit.Backup
+			applyListEdits(configPath, serviceName, retention, in.Apply, []blocky.ListEdit{*edit}, resp)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// RemoveListEntry removes an entry from an allow- or denylist group. The
+// entry, source and apply options are query parameters.
+func RemoveListEntry(configPath, serviceName string, retention blocky.RetentionPolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		in := listEntryRequest{Entry: q.Get("entry"), Source: q.Get("source"), Apply: q.Get("apply")}
+		kind, ok := checkListRequest(w, r, in)
+		if !ok {
+			return
+		}
+		group := chi.URLParam(r, "group")
+		edits, err := blocky.RemoveListEntry(configPath, kind, group, in.Entry, in.Source)
+		if err != nil && len(edits) == 0 {
+			writeListErr(w, err)
+			return
+		}
+		for _, e := range edits {
+			log.Printf("%s.%s: removed %s from %s", kind, group, in.Entry, e.Source)
+		}
+
+		// Edits saved before a failure stay saved, so they are applied and
+		// reported along with the error.
+		resp := map[string]any{"status": "removed", "edits": edits}
+		applyListEdits(configPath, serviceName, retention, in.Apply, edits, resp)
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			log.Printf("%s.%s: removing %s: %v", kind, group, in.Entry, err)
+			resp["status"] = "partial"
+			resp["error"] = err.Error()
+			w.WriteHeader(listErrStatus(err))
+		}
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// checkListRequest resolves the list kind and rejects options that cannot
+// work before anything is changed.
+func checkListRequest(w http.ResponseWriter, r *http.Request, in listEntryRequest) (string, bool) {
+	kind, ok := listKinds[chi.URLParam(r, "kind")]
+	if !ok {
+		http.Error(w, jsonErr("unknown list kind, expected allow or deny"), http.StatusNotFound)
+		return "", false
+	}
+	if in.Entry == "" {
+		http.Error(w, jsonErr("entry is required"), http.StatusBadRequest)
+		return "", false
+	}
+	if !checkApply(w, in.Apply, in.Source) {
+		return "", false
+	}
+	return kind, true
+}
+
+// checkApply rejects an apply option that cannot work before anything is
+// changed.
+func checkApply(w http.ResponseWriter, apply, source string) bool {
+	switch apply {
+	case "", blocky.ApplyRestart:
+	case blocky.ApplyReload:
+		// Blocky re-reads list files on refresh, but not its config.
+		if source == "" || source == blocky.SourceInline {
+			http.Error(w, jsonErr("reload only applies list file changes; use restart for inline lists"), http.StatusBadRequest)
+			return false
+		}
+	default:
+		http.Error(w, jsonErr("apply must be restart or reload"), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// applyListEdits prunes the backups the edits made and restarts or reloads
+// Blocky if asked to. The edits are already saved, so a failure to apply
+// them is reported in the response rather than as an error.
+func applyListEdits(configPath, serviceName string, retention blocky.RetentionPolicy, apply string, edits []blocky.ListEdit, resp map[string]any) {
+	for _, e := range edits {
+		pruneBackups(e.File, retention)
+	}
+	applyListChange(configPath, serviceName, apply, resp)
+}
+
+func applyListChange(configPath, serviceName, apply string, resp map[string]any) {
+	if apply == "" {
+		return
+	}
+	if err := blocky.ApplyListChange(configPath, serviceName, apply); err != nil {
+		log.Printf("apply list change: %v", err)
+		resp["apply_error"] = err.Error()
+		return
+	}
+	resp["applied"] = apply
+}
+
+func writeListErr(w http.ResponseWriter, err error) {
+	var verr *blocky.ValidationError
+	if errors.As(err, &verr) {
+		writeConfigIssues(w, verr.Issues)
+		return
+	}
+	http.Error(w, jsonErr(err.Error()), listErrStatus(err))
+}
+
+func listErrStatus(err error) int {
+	switch {
+	case errors.Is(err, blocky.ErrInvalidEntry):
+		return http.StatusBadRequest
+	case errors.Is(err, blocky.ErrEntryNotFound), errors.Is(err, blocky.ErrListSourceNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, blocky.ErrConfigChanged):
+		return http.StatusConflict
+	case errors.As(err, new(*blocky.ValidationError)):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/JCHHeilmann/blocky-visor/sidecar/logparser"
+	"github.com/JCHHeilmann/blocky-visor/sidecar/logtail"
+)
+
+// StreamStats sends the rolling 1/5/15-minute aggregates as a server-sent
+// event every second. ?top= limits the domain and client lists (default 10).
+// The stats are only fed while at least one client is connected.
+func StreamStats(feed *logtail.StatsFeed) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, `{"error":"streaming not supported"}`, http.StatusInternalServerError)
+			return
+		}
+
+		top := 10
+		if v := r.URL.Query().Get("top"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > logparser.RollingTop {
+				http.Error(w, jsonErr(fmt.Sprintf("top must be between 1 and %d", logparser.RollingTop)), http.StatusBadRequest)
+				return
+			}
+			top = n
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no")
+
+		rs := feed.Acquire()
+		defer feed.Release()
+
+		send := func(now time.Time) {
+			snap := rs.Snapshot(now)
+			out := logparser.RollingSnapshot{Time: snap.Time, Windows: make(map[string]logparser.RollingWindow, len(snap.Windows))}
+			for name, win := range snap.Windows {
+				win.TopDomains = win.TopDomains[:min(top, len(win.TopDomains))]
+				win.TopClients = win.TopClients[:min(top, len(win.TopClients))]
+				out.Windows[name] = win
+			}
+			data, err := json.Marshal(out)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "event: stats\ndata: %s\n\n", data)
+			flusher.Flush()
+		}
+
+		send(time.Now())
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case now := <-ticker.C:
+				send(now)
+			}
+		}
+	}
+}
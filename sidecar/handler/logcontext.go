@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/JCHHeilmann/blocky-visor/sidecar/logparser"
+	"github.com/JCHHeilmann/blocky-visor/sidecar/resolver"
+)
+
+// GetLogContext returns the queries a client made around a given time.
+// ?ts= accepts RFC 3339 (as in LogEntry JSON) or the log's own format.
+func GetLogContext(logDir string, hr *resolver.HostResolver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := logparser.ContextQuery{
+			Client:     r.URL.Query().Get("client"),
+			Domain:     r.URL.Query().Get("domain"),
+			N:          10,
+			AllClients: r.URL.Query().Get("all") == "true",
+		}
+		if q.Client == "" {
+			http.Error(w, jsonErr("client is required"), http.StatusBadRequest)
+			return
+		}
+		ts, err := parseTimestampParam(r.URL.Query().Get("ts"))
+		if err != nil {
+			http.Error(w, jsonErr("invalid ts: "+err.Error()), http.StatusBadRequest)
+			return
+		}
+		q.Timestamp = ts
+		if v := r.URL.Query().Get("n"); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 500 {
+				q.N = n
+			}
+		}
+
+		resp, err := logparser.SurroundingEntries(logDir, q)
+		if errors.Is(err, fs.ErrNotExist) {
+			http.Error(w, jsonErr("no log file for "+ts.Format("2006-01-02")), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, jsonErr(err.Error()), http.StatusInternalServerError)
+			return
+		}
+
+		if resp.Anchor != nil {
+			resp.Anchor.ResolvedName = hr.Lookup(resp.Anchor.ClientIP)
+		}
+		for _, e := range resp.Before {
+			e.ResolvedName = hr.Lookup(e.ClientIP)
+		}
+		for _, e := range resp.After {
+			e.ResolvedName = hr.Lookup(e.ClientIP)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// parseTimestampParam parses a log timestamp. Log times carry no zone and
+// are parsed as UTC, so an RFC 3339 value keeps its wall-clock time and
+// its offset is dropped rather than converted.
+func parseTimestampParam(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, errors.New("missing")
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC), nil
+	}
+	return time.Parse("2006-01-02 15:04:05", s)
+}
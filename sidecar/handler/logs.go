@@ -2,8 +2,9 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
-	"sort"
 	"strconv"
 	"time"
 
@@ -11,9 +12,9 @@ import (
 	"github.com/JCHHeilmann/blocky-visor/sidecar/resolver"
 )
 
-func GetLogs(logDir string, hr *resolver.HostResolver) http.HandlerFunc {
+func GetLogs(ix *logparser.Indexer, hr *resolver.HostResolver) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		start, end := parseRange(r)
+		start, end := parseLogsRange(r)
 
 		limit := 100
 		if v := r.URL.Query().Get("limit"); v != "" {
@@ -22,68 +23,140 @@ func GetLogs(logDir string, hr *resolver.HostResolver) http.HandlerFunc {
 			}
 		}
 
-		offset := 0
-		if v := r.URL.Query().Get("offset"); v != "" {
-			if n, err := strconv.Atoi(v); err == nil && n >= 0 {
-				offset = n
+		var from *logparser.Cursor
+		if v := r.URL.Query().Get("cursor"); v != "" {
+			c, err := logparser.DecodeCursor(v)
+			if err != nil {
+				http.Error(w, jsonErr(err.Error()), http.StatusBadRequest)
+				return
 			}
+			from = &c
 		}
 
-		filter := logparser.LogFilter{
-			Client: r.URL.Query().Get("client"),
-			Domain: r.URL.Query().Get("domain"),
-			Type:   r.URL.Query().Get("type"),
+		query, err := parseFilter(r)
+		if err != nil {
+			http.Error(w, jsonErr(err.Error()), http.StatusBadRequest)
+			return
 		}
 
-		// Default range for logs: today + yesterday for more history
-		if r.URL.Query().Get("range") == "" {
-			now := time.Now()
-			today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
-			start = today.AddDate(0, 0, -1)
-			end = now
+		collapser, err := parseCollapse(r)
+		if err != nil {
+			http.Error(w, jsonErr(err.Error()), http.StatusBadRequest)
+			return
 		}
 
-		entries, _, err := logparser.LoadEntriesForRange(logDir, start, end)
+		entries := make([]*logparser.LogEntry, 0, limit)
+		var next logparser.Cursor
+		more := false
+		err = ix.ScanReverse(start, end, from, query, hr.Lookup, func(e *logparser.LogEntry, c logparser.Cursor) bool {
+			// Client predicates also match resolved names; lookups are
+			// cached per IP so this stays cheap while scanning.
+			if query.NeedsResolvedName() {
+				e.ResolvedName = hr.Lookup(e.ClientIP)
+			}
+			if !query.Match(e) {
+				return true
+			}
+			if collapser != nil {
+				// Keep folding repeats into the page's groups until an
+				// entry would start a group past the limit; the next page
+				// resumes at that entry.
+				if _, merged := collapser.Add(e); merged {
+					next = c
+					return true
+				}
+				if len(entries) == limit {
+					more = true
+					return false
+				}
+			}
+			entries = append(entries, e)
+			next = c
+			more = len(entries) == limit && collapser == nil
+			return collapser != nil || len(entries) < limit
+		})
+		if errors.Is(err, logparser.ErrStaleCursor) {
+			http.Error(w, jsonErr(err.Error()+"; reload from the first page"), http.StatusGone)
+			return
+		}
 		if err != nil {
 			http.Error(w, jsonErr(err.Error()), http.StatusInternalServerError)
 			return
 		}
 
-		// Enrich with resolved hostnames before filtering
+		// Enrich only the entries being returned
 		enrichEntries(entries, hr)
 
-		// Filter
-		filtered := logparser.FilterEntries(entries, filter)
+		resp := logparser.LogsResponse{Limit: limit, Entries: entries}
+		if more {
+			resp.NextCursor = next.Encode()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
 
-		// Sort reverse chronological
-		sort.Slice(filtered, func(i, j int) bool {
-			return filtered[i].Timestamp.After(filtered[j].Timestamp)
-		})
+// parseLogsRange is parseRange with a default of today + yesterday, giving
+// the log views more history than the stats default.
+func parseLogsRange(r *http.Request) (time.Time, time.Time) {
+	if search, ok := savedSearch(r); ok && r.URL.Query().Get("range") == "" && search.Range != "" {
+		q := r.URL.Query()
+		q.Set("range", search.Range)
+		r = r.Clone(r.Context())
+		r.URL.RawQuery = q.Encode()
+	}
+	if r.URL.Query().Get("range") == "" {
+		now := time.Now()
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+		return today.AddDate(0, 0, -1), now
+	}
+	return parseRange(r)
+}
 
-		// Paginate
-		total := len(filtered)
-		if offset >= total {
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(logparser.LogsResponse{Total: total, Offset: offset, Limit: limit, Entries: []*logparser.LogEntry{}})
-			return
-		}
-		endIdx := offset + limit
-		if endIdx > total {
-			endIdx = total
+// parseFilter compiles the q search expression together with the simple
+// client, domain and type filters and the saved search, if any.
+func parseFilter(r *http.Request) (*logparser.Query, error) {
+	query, err := logparser.LogFilter{
+		Query:  r.URL.Query().Get("q"),
+		Client: r.URL.Query().Get("client"),
+		Domain: r.URL.Query().Get("domain"),
+		Type:   r.URL.Query().Get("type"),
+	}.Compile()
+	if err != nil {
+		return nil, err
+	}
+	if search, ok := savedSearch(r); ok {
+		saved, err := logparser.ParseQuery(search.Query)
+		if err != nil {
+			return nil, fmt.Errorf("saved search %s: %w", search.ID, err)
 		}
+		query = saved.And(query)
+	}
+	return query, nil
+}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(logparser.LogsResponse{
-			Total:   total,
-			Offset:  offset,
-			Limit:   limit,
-			Entries: filtered[offset:endIdx],
-		})
+// parseCollapse returns a Collapser when ?collapse=true, folding only
+// consecutive repeats unless ?collapse_window= (e.g. 5m) is given.
+func parseCollapse(r *http.Request) (*logparser.Collapser, error) {
+	if r.URL.Query().Get("collapse") != "true" {
+		return nil, nil
 	}
+	var window time.Duration
+	if v := r.URL.Query().Get("collapse_window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 || d > 24*time.Hour {
+			return nil, fmt.Errorf("invalid collapse_window %q", v)
+		}
+		window = d
+	}
+	return logparser.NewCollapser(window), nil
 }
 
 func enrichEntries(entries []*logparser.LogEntry, hr *resolver.HostResolver) {
 	for _, e := range entries {
+		if e.ResolvedName != "" {
+			continue
+		}
 		if name := hr.Lookup(e.ClientIP); name != "" {
 			e.ResolvedName = name
 		}
@@ -1,21 +1,55 @@
 package handler
 
 import (
-	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
-	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/JCHHeilmann/blocky-visor/sidecar/logparser"
+	"github.com/JCHHeilmann/blocky-visor/sidecar/logtail"
 	"github.com/JCHHeilmann/blocky-visor/sidecar/resolver"
 )
 
-func StreamLogs(logDir string, hr *resolver.HostResolver) http.HandlerFunc {
+// maxReplayDays bounds how far back a Last-Event-ID may point before the
+// stream starts over with a fresh backfill.
+const maxReplayDays = 7
+
+// eventID identifies a position in the logs: the file date and the byte
+// offset just past an entry's line, sent as "2006-01-02:offset".
+type eventID struct {
+	day    string
+	offset int64
+}
+
+func (id eventID) String() string {
+	return id.day + ":" + strconv.FormatInt(id.offset, 10)
+}
+
+func parseEventID(s string) (eventID, error) {
+	day, off, ok := strings.Cut(s, ":")
+	if !ok {
+		return eventID{}, errors.New("missing offset")
+	}
+	if _, err := time.Parse("2006-01-02", day); err != nil {
+		return eventID{}, err
+	}
+	offset, err := strconv.ParseInt(off, 10, 64)
+	if err != nil || offset < 0 {
+		return eventID{}, errors.New("invalid offset")
+	}
+	return eventID{day, offset}, nil
+}
+
+// StreamLogs sends live log entries as server-sent events. Each event has
+// an id; a client reconnecting with Last-Event-ID receives up to maxReplay
+// entries it missed instead of a fresh backfill. A comment is sent every
+// heartbeat to keep idle connections open through proxies.
+func StreamLogs(logDir string, tailer *logtail.Tailer, hr *resolver.HostResolver, maxReplay int, heartbeat time.Duration) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		flusher, ok := w.(http.Flusher)
 		if !ok {
@@ -23,12 +57,36 @@ func StreamLogs(logDir string, hr *resolver.HostResolver) http.HandlerFunc {
 			return
 		}
 
-		filter := logparser.LogFilter{
-			Client: r.URL.Query().Get("client"),
-			Domain: r.URL.Query().Get("domain"),
-			Type:   r.URL.Query().Get("type"),
+		query, err := parseFilter(r)
+		if err != nil {
+			http.Error(w, jsonErr(err.Error()), http.StatusBadRequest)
+			return
+		}
+		collapser, err := parseCollapse(r)
+		if err != nil {
+			http.Error(w, jsonErr(err.Error()), http.StatusBadRequest)
+			return
 		}
 
+		var resume *eventID
+		lastID := r.Header.Get("Last-Event-ID")
+		if lastID == "" {
+			lastID = r.URL.Query().Get("last_event_id")
+		}
+		if lastID != "" {
+			id, err := parseEventID(lastID)
+			if err != nil {
+				http.Error(w, jsonErr("invalid Last-Event-ID: "+err.Error()), http.StatusBadRequest)
+				return
+			}
+			resume = &id
+		}
+
+		// Subscribe before reading history so no line falls between the
+		// two; live events already covered by it are skipped.
+		sub := tailer.Subscribe(query.Match)
+		defer tailer.Unsubscribe(sub)
+
 		w.Header().Set("Content-Type", "text/event-stream")
 		w.Header().Set("Cache-Control", "no-cache")
 		w.Header().Set("Connection", "keep-alive")
@@ -36,52 +94,112 @@ func StreamLogs(logDir string, hr *resolver.HostResolver) http.HandlerFunc {
 		flusher.Flush()
 
 		ctx := r.Context()
+		now := time.Now()
+		today := now.Format("2006-01-02")
 
-		currentDate := time.Now().Format("2006-01-02")
-		logPath := filepath.Join(logDir, currentDate+"_ALL.log")
-
-		// Send recent historical entries on connect
-		const backfillCount = 50
-		var fileOffset int64
-		if f, err := os.Open(logPath); err == nil {
-			var allFiltered []*logparser.LogEntry
-			scanner := bufio.NewScanner(f)
-			scanner.Buffer(make([]byte, 0, 1024*1024), 1024*1024)
-			for scanner.Scan() {
-				line := scanner.Text()
-				if line == "" {
-					continue
+		send := func(event string, id eventID, v any) {
+			data, err := json.Marshal(v)
+			if err != nil {
+				return
+			}
+			if event != "" {
+				fmt.Fprintf(w, "event: %s\n", event)
+			}
+			fmt.Fprintf(w, "id: %s\ndata: %s\n\n", id, data)
+		}
+
+		// Collapse a copy: live entries are shared with other subscribers.
+		fold := func(e *logparser.LogEntry) (*logparser.LogEntry, string) {
+			if collapser == nil {
+				return e, ""
+			}
+			cp := *e
+			group, merged := collapser.Add(&cp)
+			if merged {
+				return group, "repeat"
+			}
+			return group, ""
+		}
+
+		// covered maps each day to the offset up to which history was sent.
+		covered := map[string]int64{}
+		oldest := now.AddDate(0, 0, -maxReplayDays).Format("2006-01-02")
+		if resume != nil && resume.day >= oldest && resume.day <= today {
+			type replayed struct {
+				e  *logparser.LogEntry
+				id eventID
+			}
+			// Keep the newest maxReplay entries of the gap. They are
+			// collapsed only once trimmed, so every repeat refers to a row
+			// sent before it.
+			var missed []replayed
+			skipped := 0
+			for d, _ := time.Parse("2006-01-02", resume.day); ; d = d.AddDate(0, 0, 1) {
+				day := d.Format("2006-01-02")
+				if day > today {
+					break
 				}
-				entry, err := logparser.ParseLine(line)
-				if err != nil {
-					continue
+				from := int64(0)
+				if day == resume.day {
+					from = resume.offset
 				}
-				if name := hr.Lookup(entry.ClientIP); name != "" {
-					entry.ResolvedName = name
+				end, _ := logparser.ScanFrom(filepath.Join(logDir, day+"_ALL.log"), from, func(e *logparser.LogEntry, end int64) bool {
+					e.ResolvedName = hr.Lookup(e.ClientIP)
+					if !query.Match(e) {
+						return true
+					}
+					missed = append(missed, replayed{e, eventID{day, end}})
+					if len(missed) > maxReplay {
+						missed = missed[1:]
+						skipped++
+					}
+					return true
+				})
+				covered[day] = end
+			}
+			if skipped > 0 {
+				fmt.Fprintf(w, "event: gap\ndata: {\"skipped\":%d}\n\n", skipped)
+			}
+			// Sent as folded, each repeat carries its group's counts so far.
+			for _, m := range missed {
+				row, event := fold(m.e)
+				send(event, m.id, row)
+			}
+			flusher.Flush()
+		} else {
+			// Send recent historical entries on connect
+			const backfillCount = 50
+			var recent []*logparser.LogEntry
+			end, _ := logparser.ScanFrom(filepath.Join(logDir, today+"_ALL.log"), 0, func(e *logparser.LogEntry, _ int64) bool {
+				if name := hr.Lookup(e.ClientIP); name != "" {
+					e.ResolvedName = name
 				}
-				if logparser.MatchesFilter(entry, filter) {
-					allFiltered = append(allFiltered, entry)
+				if !query.Match(e) {
+					return true
+				}
+				recent = append(recent, e)
+				if len(recent) > backfillCount {
+					recent = recent[1:]
+				}
+				return true
+			})
+			covered[today] = end
+
+			// Collapse only the last N entries, so the collapser holds just
+			// the groups sent, and send them as a single "backfill" event.
+			var rows []*logparser.LogEntry
+			for _, e := range recent {
+				if row, event := fold(e); event == "" {
+					rows = append(rows, row)
 				}
 			}
-			// Take last N entries, send as a single "backfill" event
-			start := 0
-			if len(allFiltered) > backfillCount {
-				start = len(allFiltered) - backfillCount
-			}
-			if len(allFiltered[start:]) > 0 {
-				data, _ := json.Marshal(allFiltered[start:])
-				fmt.Fprintf(w, "event: backfill\ndata: %s\n\n", data)
+			if len(rows) > 0 {
+				send("backfill", eventID{today, end}, rows)
 				flusher.Flush()
 			}
-
-			info, _ := f.Stat()
-			if info != nil {
-				fileOffset = info.Size()
-			}
-			f.Close()
 		}
 
-		ticker := time.NewTicker(500 * time.Millisecond)
+		ticker := time.NewTicker(heartbeat)
 		defer ticker.Stop()
 
 		for {
@@ -89,80 +207,30 @@ func StreamLogs(logDir string, hr *resolver.HostResolver) http.HandlerFunc {
 			case <-ctx.Done():
 				return
 			case <-ticker.C:
-				// Check for day rollover
-				newDate := time.Now().Format("2006-01-02")
-				if newDate != currentDate {
-					currentDate = newDate
-					logPath = filepath.Join(logDir, currentDate+"_ALL.log")
-					fileOffset = 0
-				}
-
-				f, err := os.Open(logPath)
-				if err != nil {
-					continue
-				}
-
-				info, err := f.Stat()
-				if err != nil {
-					f.Close()
-					continue
-				}
-
-				// File was truncated or rotated
-				if info.Size() < fileOffset {
-					fileOffset = 0
-				}
-
-				if info.Size() <= fileOffset {
-					f.Close()
-					continue
+				fmt.Fprint(w, ": heartbeat\n\n")
+				flusher.Flush()
+			case ev, ok := <-sub.C:
+				if !ok {
+					// Dropped for falling behind; the client reconnects
+					// and resumes from its last event id.
+					fmt.Fprintf(w, "event: lagging\ndata: {\"disconnected\":true}\n\n")
+					flusher.Flush()
+					return
 				}
-
-				if _, err := f.Seek(fileOffset, io.SeekStart); err != nil {
-					f.Close()
+				if end, ok := covered[ev.Day]; ok && ev.Offset <= end {
 					continue
 				}
-
-				buf := make([]byte, info.Size()-fileOffset)
-				n, err := f.Read(buf)
-				f.Close()
-				if err != nil && err != io.EOF {
-					continue
+				if n := sub.Dropped(); n > 0 {
+					fmt.Fprintf(w, "event: lagging\ndata: {\"dropped\":%d}\n\n", n)
 				}
-				fileOffset += int64(n)
 
-				// Parse lines from the chunk
-				lines := splitLines(buf[:n])
-				for _, line := range lines {
-					if line == "" {
-						continue
-					}
-					entry, err := logparser.ParseLine(line)
-					if err != nil {
-						continue
-					}
-					if name := hr.Lookup(entry.ClientIP); name != "" {
-						entry.ResolvedName = name
-					}
-					if !logparser.MatchesFilter(entry, filter) {
-						continue
-					}
-					data, err := json.Marshal(entry)
-					if err != nil {
-						continue
-					}
-					fmt.Fprintf(w, "data: %s\n\n", data)
+				// A repeat updates the row of its group instead of adding one.
+				row, event := fold(ev.Entry)
+				send(event, eventID{ev.Day, ev.Offset}, row)
+				if len(sub.C) == 0 {
+					flusher.Flush()
 				}
-				flusher.Flush()
 			}
 		}
 	}
 }
-
-func splitLines(data []byte) []string {
-	lines := strings.Split(string(data), "\n")
-	if len(lines) > 0 && lines[len(lines)-1] == "" {
-		lines = lines[:len(lines)-1]
-	}
-	return lines
-}
@@ -0,0 +1,355 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/JCHHeilmann/blocky-visor/sidecar/logparser"
+	"github.com/JCHHeilmann/blocky-visor/sidecar/logtail"
+	"github.com/JCHHeilmann/blocky-visor/sidecar/resolver"
+	"github.com/JCHHeilmann/blocky-visor/sidecar/savedsearch"
+	"github.com/JCHHeilmann/blocky-visor/sidecar/websocket"
+)
+
+// wsProtocol is the subprotocol spoken on /api/logs/ws. Browsers cannot set
+// headers on WebSocket requests, so they authenticate by offering the API
+// key as a second subprotocol, "key." followed by the base64url-encoded key
+// without padding. Other clients may send X-API-Key instead.
+const wsProtocol = "blocky-visor.logs.v1"
+
+const (
+	wsPingInterval = 25 * time.Second
+	wsIdleTimeout  = 60 * time.Second
+	wsBackfillMax  = 1000
+)
+
+// wsRequest is a message from the client. Op is one of:
+//
+//	filter    replace the filter (q, client, domain, type, search) and
+//	          receive a fresh backfill
+//	pause     stop sending live entries until resume
+//	resume    continue; the status reports how many were missed
+//	backfill  send count older entries
+//	sample    send only a fraction rate (0 < rate <= 1) of live entries
+type wsRequest struct {
+	Op     string  `json:"op"`
+	Q      string  `json:"q"`
+	Client string  `json:"client"`
+	Domain string  `json:"domain"`
+	Type   string  `json:"type"`
+	Search string  `json:"search"`
+	Count  int     `json:"count"`
+	Rate   float64 `json:"rate"`
+}
+
+type wsFilter struct {
+	Q      string `json:"q,omitempty"`
+	Client string `json:"client,omitempty"`
+	Domain string `json:"domain,omitempty"`
+	Type   string `json:"type,omitempty"`
+	Search string `json:"search,omitempty"`
+}
+
+type wsEntryMsg struct {
+	Type  string              `json:"type"` // "entry"
+	ID    string              `json:"id"`
+	Entry *logparser.LogEntry `json:"entry"`
+}
+
+type wsBackfillMsg struct {
+	Type    string                `json:"type"` // "backfill"
+	Entries []*logparser.LogEntry `json:"entries"`
+	Reset   bool                  `json:"reset"` // replaces all entries shown so far
+	More    bool                  `json:"more"`
+}
+
+type wsStatusMsg struct {
+	Type   string   `json:"type"` // "status"
+	Paused bool     `json:"paused"`
+	Sample float64  `json:"sample"`
+	Filter wsFilter `json:"filter"`
+	Missed int      `json:"missed,omitempty"` // live entries skipped while paused
+}
+
+type wsErrorMsg struct {
+	Type  string `json:"type"` // "error" or "lagging"
+	Error string `json:"error,omitempty"`
+	Count int64  `json:"dropped,omitempty"`
+}
+
+// wsAuthorized checks the API key from X-API-Key or the handshake's
+// subprotocols. The ?key= parameter is deliberately not accepted.
+func wsAuthorized(r *http.Request, apiKey string) bool {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) == 1
+	}
+	for _, p := range websocket.Subprotocols(r) {
+		enc, ok := strings.CutPrefix(p, "key.")
+		if !ok {
+			continue
+		}
+		key, err := base64.RawURLEncoding.DecodeString(enc)
+		return err == nil && subtle.ConstantTimeCompare(key, []byte(apiKey)) == 1
+	}
+	return false
+}
+
+// LiveLogsWS carries the live log over a WebSocket. Unlike StreamLogs the
+// client can change the filter, pause, page back and sample without
+// reconnecting. Initial filters may be given as query parameters.
+func LiveLogsWS(apiKey, logDir string, ix *logparser.Indexer, tailer *logtail.Tailer, hr *resolver.HostResolver, searches *savedsearch.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !wsAuthorized(r, apiKey) {
+			http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		protocol := ""
+		for _, p := range websocket.Subprotocols(r) {
+			if p == wsProtocol {
+				protocol = p
+			}
+		}
+		if protocol == "" && len(websocket.Subprotocols(r)) > 0 {
+			http.Error(w, jsonErr("unsupported subprotocol, want "+wsProtocol), http.StatusBadRequest)
+			return
+		}
+
+		s := &wsSession{logDir: logDir, ix: ix, hr: hr, searches: searches, sample: 1}
+		initial := wsRequest{
+			Q:      r.URL.Query().Get("q"),
+			Client: r.URL.Query().Get("client"),
+			Domain: r.URL.Query().Get("domain"),
+			Type:   r.URL.Query().Get("type"),
+			Search: r.URL.Query().Get("search"),
+		}
+		if err := s.setFilter(initial); err != nil {
+			http.Error(w, jsonErr(err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		conn, err := websocket.Upgrade(w, r, protocol)
+		if err != nil {
+			return
+		}
+		conn.SetIdleTimeout(wsIdleTimeout)
+		s.conn = conn
+		defer conn.Close(websocket.CloseNormal, "")
+
+		sub := tailer.Subscribe(func(e *logparser.LogEntry) bool { return s.query.Load().Match(e) })
+		defer tailer.Unsubscribe(sub)
+
+		// done stops the reader once the handler has returned, so it never
+		// blocks on a request nobody will receive.
+		done := make(chan struct{})
+		defer close(done)
+		requests := make(chan wsRequest, 16)
+		go func() {
+			defer close(requests)
+			for {
+				_, data, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				var req wsRequest
+				if err := json.Unmarshal(data, &req); err != nil {
+					s.send(wsErrorMsg{Type: "error", Error: "invalid JSON message"})
+					continue
+				}
+				select {
+				case requests <- req:
+				case <-done:
+					return
+				}
+			}
+		}()
+
+		s.backfill(50, true)
+		s.sendStatus()
+
+		ping := time.NewTicker(wsPingInterval)
+		defer ping.Stop()
+		for {
+			select {
+			case req, ok := <-requests:
+				if !ok {
+					return
+				}
+				s.handle(req)
+			case ev, ok := <-sub.C:
+				if !ok {
+					s.send(wsErrorMsg{Type: "lagging", Error: "too far behind, reconnect"})
+					return
+				}
+				if n := sub.Dropped(); n > 0 {
+					s.send(wsErrorMsg{Type: "lagging", Count: n})
+				}
+				s.live(ev)
+			case <-ping.C:
+				if err := conn.Ping(); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// wsSession is the state of one WebSocket client. Only query is read from
+// the tailer's goroutine; everything else belongs to the handler loop.
+type wsSession struct {
+	conn     *websocket.Conn
+	logDir   string
+	ix       *logparser.Indexer
+	hr       *resolver.HostResolver
+	searches *savedsearch.Store
+
+	query   atomic.Pointer[logparser.Query]
+	filter  wsFilter
+	paused  bool
+	missed  int
+	sample  float64
+	credit  float64
+	cursor  *logparser.Cursor // next backfill page, nil when exhausted
+	covered logparser.Cursor  // live entries up to here were backfilled
+}
+
+func (s *wsSession) send(v any) bool {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return false
+	}
+	return s.conn.WriteMessage(websocket.TextMessage, data) == nil
+}
+
+func (s *wsSession) sendStatus() {
+	s.send(wsStatusMsg{Type: "status", Paused: s.paused, Sample: s.sample, Filter: s.filter})
+}
+
+func (s *wsSession) setFilter(req wsRequest) error {
+	query, err := logparser.LogFilter{Query: req.Q, Client: req.Client, Domain: req.Domain, Type: req.Type}.Compile()
+	if err != nil {
+		return err
+	}
+	if req.Search != "" {
+		search, err := s.searches.Get(req.Search)
+		if err != nil {
+			return err
+		}
+		saved, err := logparser.ParseQuery(search.Query)
+		if err != nil {
+			return fmt.Errorf("saved search %s: %w", search.ID, err)
+		}
+		query = saved.And(query)
+	}
+	s.query.Store(query)
+	s.filter = wsFilter{Q: req.Q, Client: req.Client, Domain: req.Domain, Type: req.Type, Search: req.Search}
+	return nil
+}
+
+func (s *wsSession) handle(req wsRequest) {
+	switch req.Op {
+	case "filter":
+		if err := s.setFilter(req); err != nil {
+			s.send(wsErrorMsg{Type: "error", Error: err.Error()})
+			return
+		}
+		s.backfill(50, true)
+	case "pause":
+		s.paused = true
+	case "resume":
+		s.paused = false
+		s.send(wsStatusMsg{Type: "status", Paused: false, Sample: s.sample, Filter: s.filter, Missed: s.missed})
+		s.missed = 0
+		return
+	case "backfill":
+		n := req.Count
+		if n <= 0 || n > wsBackfillMax {
+			n = 100
+		}
+		s.backfill(n, false)
+		return
+	case "sample":
+		if req.Rate <= 0 || req.Rate > 1 {
+			s.send(wsErrorMsg{Type: "error", Error: "rate must be in (0, 1]"})
+			return
+		}
+		s.sample, s.credit = req.Rate, 0
+	default:
+		s.send(wsErrorMsg{Type: "error", Error: fmt.Sprintf("unknown op %q", req.Op)})
+		return
+	}
+	s.sendStatus()
+}
+
+// backfill sends up to n entries older than those sent so far, newest
+// first. With reset it starts over at the current end of today's log, and
+// live entries before that point are suppressed.
+func (s *wsSession) backfill(n int, reset bool) {
+	now := time.Now()
+	if reset {
+		today := now.Format("2006-01-02")
+		end, _ := logparser.CompleteLinesEnd(filepath.Join(s.logDir, today+"_ALL.log"))
+		s.covered = logparser.Cursor{Day: today, Offset: end}
+		s.cursor = &s.covered
+	}
+	if s.cursor == nil {
+		s.send(wsBackfillMsg{Type: "backfill", Entries: []*logparser.LogEntry{}, Reset: reset})
+		return
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	query := s.query.Load()
+	entries := make([]*logparser.LogEntry, 0, n)
+	var next logparser.Cursor
+	err := s.ix.ScanReverse(today.AddDate(0, 0, -1), now, s.cursor, query, s.hr.Lookup, func(e *logparser.LogEntry, c logparser.Cursor) bool {
+		if query.NeedsResolvedName() {
+			e.ResolvedName = s.hr.Lookup(e.ClientIP)
+		}
+		if !query.Match(e) {
+			return true
+		}
+		entries = append(entries, e)
+		next = c
+		return len(entries) < n
+	})
+	if errors.Is(err, logparser.ErrStaleCursor) {
+		s.send(wsErrorMsg{Type: "error", Error: err.Error()})
+	} else if err != nil {
+		log.Printf("websocket backfill: %v", err)
+	}
+	enrichEntries(entries, s.hr)
+
+	s.cursor = nil
+	if len(entries) == n {
+		s.cursor = &next
+	}
+	s.send(wsBackfillMsg{Type: "backfill", Entries: entries, Reset: reset, More: s.cursor != nil})
+}
+
+func (s *wsSession) live(ev logtail.Event) {
+	if ev.Day < s.covered.Day || ev.Day == s.covered.Day && ev.Offset <= s.covered.Offset {
+		return
+	}
+	// The filter may have changed after the tailer matched the entry.
+	if !s.query.Load().Match(ev.Entry) {
+		return
+	}
+	if s.paused {
+		s.missed++
+		return
+	}
+	s.credit += s.sample
+	if s.credit < 1 {
+		return
+	}
+	s.credit--
+	s.send(wsEntryMsg{Type: "entry", ID: eventID{ev.Day, ev.Offset}.String(), Entry: ev.Entry})
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/JCHHeilmann/blocky-visor/sidecar/logparser"
+	"github.com/JCHHeilmann/blocky-visor/sidecar/resolver"
+)
+
+type passiveDNSResponse struct {
+	Query   string                       `json:"query"`
+	Total   int                          `json:"total"`
+	Records []logparser.PassiveDNSRecord `json:"records"`
+}
+
+// GetPassiveDNS looks up which domains resolved to an IP or CIDR (?ip=), or
+// which addresses a domain resolved to (?domain=).
+func GetPassiveDNS(pdns *logparser.PassiveDNS, hr *resolver.HostResolver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := r.URL.Query().Get("ip")
+		domain := r.URL.Query().Get("domain")
+		if (ip == "") == (domain == "") {
+			http.Error(w, jsonErr("exactly one of ip or domain is required"), http.StatusBadRequest)
+			return
+		}
+
+		limit := 100
+		if v := r.URL.Query().Get("limit"); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 1000 {
+				limit = n
+			}
+		}
+
+		if err := pdns.Refresh(); err != nil {
+			log.Printf("passive dns: %v", err)
+		}
+
+		var records []logparser.PassiveDNSRecord
+		query := domain
+		if ip != "" {
+			prefix, err := parsePrefix(ip)
+			if err != nil {
+				http.Error(w, jsonErr("invalid ip or cidr: "+ip), http.StatusBadRequest)
+				return
+			}
+			query = prefix.String()
+			records = pdns.LookupIP(prefix)
+		} else {
+			records = pdns.LookupDomain(domain)
+		}
+
+		total := len(records)
+		if len(records) > limit {
+			records = records[:limit]
+		}
+		for i := range records {
+			for j := range records[i].Clients {
+				c := &records[i].Clients[j]
+				c.ResolvedName = hr.Lookup(c.IP)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(passiveDNSResponse{Query: query, Total: total, Records: records})
+	}
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		return netip.ParsePrefix(s)
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/JCHHeilmann/blocky-visor/sidecar/savedsearch"
+	"github.com/go-chi/chi/v5"
+)
+
+func ListSearches(store *savedsearch.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(store.List())
+	}
+}
+
+func GetSearch(store *savedsearch.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		search, err := store.Get(chi.URLParam(r, "id"))
+		if err != nil {
+			writeSearchErr(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(search)
+	}
+}
+
+func CreateSearch(store *savedsearch.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		in, ok := decodeSearch(w, r)
+		if !ok {
+			return
+		}
+		search, err := store.Create(in)
+		if err != nil {
+			writeSearchErr(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(search)
+	}
+}
+
+func UpdateSearch(store *savedsearch.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		in, ok := decodeSearch(w, r)
+		if !ok {
+			return
+		}
+		search, err := store.Update(chi.URLParam(r, "id"), in)
+		if err != nil {
+			writeSearchErr(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(search)
+	}
+}
+
+func DeleteSearch(store *savedsearch.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := store.Delete(chi.URLParam(r, "id")); err != nil {
+			writeSearchErr(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func decodeSearch(w http.ResponseWriter, r *http.Request) (savedsearch.Search, bool) {
+	var in savedsearch.Search
+	defer r.Body.Close()
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&in); err != nil {
+		http.Error(w, jsonErr("invalid JSON body"), http.StatusBadRequest)
+		return in, false
+	}
+	if err := in.Validate(); err != nil {
+		http.Error(w, jsonErr(err.Error()), http.StatusBadRequest)
+		return in, false
+	}
+	return in, true
+}
+
+func writeSearchErr(w http.ResponseWriter, err error) {
+	if errors.Is(err, savedsearch.ErrNotFound) {
+		http.Error(w, jsonErr(err.Error()), http.StatusNotFound)
+		return
+	}
+	http.Error(w, jsonErr(err.Error()), http.StatusInternalServerError)
+}
+
+type savedSearchKey struct{}
+
+// WithSavedSearch resolves ?search=<id> for the log endpoints. The saved
+// expression is ANDed with any inline filters and its range is used when
+// the request has none.
+func WithSavedSearch(store *savedsearch.Store) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.URL.Query().Get("search")
+			if id == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			search, err := store.Get(id)
+			if err != nil {
+				writeSearchErr(w, err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), savedSearchKey{}, search)))
+		})
+	}
+}
+
+func savedSearch(r *http.Request) (savedsearch.Search, bool) {
+	search, ok := r.Context().Value(savedSearchKey{}).(savedsearch.Search)
+	return search, ok
+}
@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/JCHHeilmann/blocky-visor/sidecar/blocky"
+	"github.com/go-chi/chi/v5"
+)
+
+// GetSection returns one subtree of the config, such as
+// "blocking.denylists", as JSON.
+func GetSection(configPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := blocky.ReadConfig(configPath)
+		if err != nil {
+			http.Error(w, jsonErr(err.Error()), http.StatusInternalServerError)
+			return
+		}
+		value, err := blocky.GetSection(data, chi.URLParam(r, "path"))
+		if err != nil {
+			writeSectionErr(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", blocky.ConfigETag(data))
+		json.NewEncoder(w).Encode(value)
+	}
+}
+
+// PatchSection applies a JSON merge patch to one subtree of the config and
+// saves it like PutConfig, including the required If-Match header.
+func PatchSection(configPath string, retention blocky.RetentionPolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		patch, err := io.ReadAll(io.LimitReader(r.Body, 1<<20)) // 1MB max
+		if err != nil {
+			http.Error(w, jsonErr("failed to read body"), http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		current, ok := readConfigIfMatch(w, r, configPath)
+		if !ok {
+			return
+		}
+
+		path := chi.URLParam(r, "path")
+		data, err := blocky.PatchSection(current, path, patch)
+		if err != nil {
+			writeSectionErr(w, err)
+			return
+		}
+		value, _ := blocky.GetSection(data, path)
+		if bytes.Equal(data, current) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("ETag", blocky.ConfigETag(data))
+			json.NewEncoder(w).Encode(map[string]any{
+				"status": "unchanged",
+				"etag":   blocky.ConfigETag(data),
+				"value":  value,
+			})
+			return
+		}
+
+		backupPath, warnings, err := blocky.WriteConfigIfMatch(configPath, data, blocky.ConfigETag(current))
+		if err != nil {
+			writeSaveErr(w, configPath, err)
+			return
+		}
+		logConfigChanges(current, data)
+		pruneBackups(configPath, retention)
+
+		etag := blocky.ConfigETag(data)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", etag)
+		json.NewEncoder(w).Encode(map[string]any{
+			"status":   "saved",
+			"backup":   backupPath,
+			"etag":     etag,
+			"warnings": nonNilIssues(warnings),
+			"value":    value,
+		})
+	}
+}
+
+// readConfigIfMatch reads the config for a change based on it. It answers
+// the request itself and returns false if the If-Match header is missing
+// or no longer matches.
+func readConfigIfMatch(w http.ResponseWriter, r *http.Request, configPath string) ([]byte, bool) {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		http.Error(w, jsonErr("If-Match header required"), http.StatusPreconditionRequired)
+		return nil, false
+	}
+	current, err := blocky.ReadConfig(configPath)
+	if err != nil {
+		http.Error(w, jsonErr(err.Error()), http.StatusInternalServerError)
+		return nil, false
+	}
+	if !blocky.MatchETag(ifMatch, current) {
+		writeConfigConflict(w, configPath)
+		return nil, false
+	}
+	return current, true
+}
+
+func writeSectionErr(w http.ResponseWriter, err error) {
+	if errors.Is(err, blocky.ErrSectionNotFound) {
+		http.Error(w, jsonErr(err.Error()), http.StatusNotFound)
+		return
+	}
+	http.Error(w, jsonErr(err.Error()), http.StatusBadRequest)
+}
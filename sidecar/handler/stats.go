@@ -27,6 +27,13 @@ func GetTimeline(logDir string, cache *logparser.StatsCache) http.HandlerFunc {
 	}
 }
 
+func GetStatsCache(cache *logparser.StatsCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(cache.Stats())
+	}
+}
+
 func parseInterval(r *http.Request) time.Duration {
 	switch r.URL.Query().Get("interval") {
 	case "5m":
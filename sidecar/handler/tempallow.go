@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/JCHHeilmann/blocky-visor/sidecar/tempallow"
+	"github.com/go-chi/chi/v5"
+)
+
+func ListTempAllows(store *tempallow.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(store.List())
+	}
+}
+
+// AddTempAllow allowlists an entry for a duration such as "30m". The
+// entry is removed again when it expires.
+func AddTempAllow(store *tempallow.Store, configPath, serviceName string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var in struct {
+			Entry    string `json:"entry"`
+			Group    string `json:"group"`
+			Source   string `json:"source"`
+			Duration string `json:"duration"`
+			Apply    string `json:"apply"`
+		}
+		defer r.Body.Close()
+		if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&in); err != nil {
+			http.Error(w, jsonErr("invalid JSON body"), http.StatusBadRequest)
+			return
+		}
+		d, err := time.ParseDuration(in.Duration)
+		if err != nil || d < tempallow.MinDuration || d > tempallow.MaxDuration {
+			http.Error(w, jsonErr(fmt.Sprintf("duration must be between %v and %v", tempallow.MinDuration, tempallow.MaxDuration)), http.StatusBadRequest)
+			return
+		}
+		if in.Entry == "" || in.Group == "" {
+			http.Error(w, jsonErr("entry and group are required"), http.StatusBadRequest)
+			return
+		}
+		if !checkApply(w, in.Apply, in.Source) {
+			return
+		}
+
+		e, err := store.Add(in.Entry, in.Group, in.Source, in.Apply, d)
+		if err != nil {
+			writeTempAllowErr(w, err)
+			return
+		}
+		log.Printf("temporary allowlist: %s allowed in group %s until %s", e.Entry, e.Group, e.ExpiresAt.Format(time.RFC3339))
+
+		resp := map[string]any{"entry": e}
+		applyListChange(configPath, serviceName, in.Apply, resp)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// CancelTempAllow removes a temporary entry before it expires. The apply
+// query parameter overrides how the entry was to be applied on expiry.
+func CancelTempAllow(store *tempallow.Store, configPath, serviceName string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		e, err := store.Get(id)
+		if err != nil {
+			writeTempAllowErr(w, err)
+			return
+		}
+		apply := e.Apply
+		if v := r.URL.Query().Get("apply"); v != "" {
+			apply = v
+		}
+		if !checkApply(w, apply, e.Source) {
+			return
+		}
+
+		if e, err = store.Cancel(id); err != nil {
+			writeTempAllowErr(w, err)
+			return
+		}
+		log.Printf("temporary allowlist: %s cancelled in group %s", e.Entry, e.Group)
+
+		resp := map[string]any{"status": "cancelled", "entry": e}
+		applyListChange(configPath, serviceName, apply, resp)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func writeTempAllowErr(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, tempallow.ErrNotFound):
+		http.Error(w, jsonErr(err.Error()), http.StatusNotFound)
+	case errors.Is(err, tempallow.ErrAlreadyAllowed):
+		http.Error(w, jsonErr(err.Error()), http.StatusConflict)
+	default:
+		writeListErr(w, err)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build linux
+
+package fsutil
+
+import (
+	"os"
+	"syscall"
+)
+
+// chownLike gives f the owner and group of the file described by info,
+// unless it already has them.
+func chownLike(f *os.File, info os.FileInfo) error {
+	want, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	cur, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if have, ok := cur.Sys().(*syscall.Stat_t); ok && have.Uid == want.Uid && have.Gid == want.Gid {
+		return nil
+	}
+	return f.Chown(int(want.Uid), int(want.Gid))
+}
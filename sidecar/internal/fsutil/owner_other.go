@@ -0,0 +1,9 @@
+//go:build !linux
+
+package fsutil
+
+import "os"
+
+func chownLike(f *os.File, info os.FileInfo) error {
+	return nil
+}
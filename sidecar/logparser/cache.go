@@ -2,104 +2,271 @@ package logparser
 
 import (
 	"bufio"
+	"container/list"
+	"errors"
+	"io/fs"
 	"os"
 	"sync"
 	"time"
 )
 
+// DefaultStatsCacheBytes is the memory budget used by NewStatsCache.
+const DefaultStatsCacheBytes = 256 << 20
+
+// sweepInterval bounds how often the cache checks for deleted log files.
+const sweepInterval = time.Minute
+
 type cachedFile struct {
+	path     string
 	modTime  time.Time
 	size     int64
+	bytes    int64 // estimated resident size of stats + timeline
 	stats    *StatsAccumulator
 	timeline *TimelineAccumulator // always at 1-hour granularity
+	elem     *list.Element
+}
+
+// CacheStats reports StatsCache occupancy and effectiveness.
+type CacheStats struct {
+	Entries        int   `json:"entries"`
+	EstimatedBytes int64 `json:"estimated_bytes"`
+	MaxBytes       int64 `json:"max_bytes"`
+	Hits           int64 `json:"hits"`
+	Misses         int64 `json:"misses"`
+	Coalesced      int64 `json:"coalesced"` // misses served by another caller's in-flight parse
+	Evictions      int64 `json:"evictions"`
+}
+
+// fillCall tracks an in-flight parse of one file so that concurrent
+// requests for it wait for a single result.
+type fillCall struct {
+	done chan struct{}
+	cf   *cachedFile
+	err  error
 }
 
 // StatsCache caches per-file accumulator state to avoid re-parsing immutable
 // historical log files. Today's file is validated by mtime+size on each request.
+// Entries are evicted least-recently-used first once the estimated size of all
+// entries exceeds the memory budget, and dropped when their file disappears.
 type StatsCache struct {
-	mu    sync.RWMutex
-	files map[string]*cachedFile
+	mu        sync.RWMutex
+	files     map[string]*cachedFile
+	lru       *list.List // front = most recently used
+	bytes     int64
+	maxBytes  int64
+	hits      int64
+	misses    int64
+	coalesced int64
+	evictions int64
+	lastSweep time.Time
+	inflight  map[string]*fillCall
+	warmup    WarmupStatus
 }
 
 func NewStatsCache() *StatsCache {
+	return NewBoundedStatsCache(DefaultStatsCacheBytes)
+}
+
+// NewBoundedStatsCache creates a cache limited to roughly maxBytes of
+// accumulator state. A maxBytes of zero or less disables the limit.
+func NewBoundedStatsCache(maxBytes int64) *StatsCache {
 	return &StatsCache{
-		files: make(map[string]*cachedFile),
+		files:    make(map[string]*cachedFile),
+		lru:      list.New(),
+		maxBytes: maxBytes,
+		inflight: make(map[string]*fillCall),
+		warmup:   WarmupStatus{State: "pending"},
 	}
 }
 
-func (c *StatsCache) get(path string, info os.FileInfo) *cachedFile {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+// lookup returns a valid cache entry for path. Caller must hold c.mu.
+func (c *StatsCache) lookup(path string, info os.FileInfo) *cachedFile {
 	cf, ok := c.files[path]
-	if !ok {
-		return nil
-	}
-	if cf.modTime != info.ModTime() || cf.size != info.Size() {
+	if !ok || cf.modTime != info.ModTime() || cf.size != info.Size() {
 		return nil
 	}
+	c.lru.MoveToFront(cf.elem)
 	return cf
 }
 
-func (c *StatsCache) put(path string, info os.FileInfo, stats *StatsAccumulator, timeline *TimelineAccumulator) {
+// load returns the accumulators for a log file, parsing it on a cache miss.
+// Concurrent misses for the same file wait for a single parse; waiters accept
+// its result even if the file has grown since that parse started.
+func (c *StatsCache) load(path string) (*cachedFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
 	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.files[path] = &cachedFile{
+	if cf := c.lookup(path, info); cf != nil {
+		c.hits++
+		c.mu.Unlock()
+		return cf, nil
+	}
+	if call, ok := c.inflight[path]; ok {
+		c.coalesced++
+		c.mu.Unlock()
+		<-call.done
+		return call.cf, call.err
+	}
+	c.misses++
+	call := &fillCall{done: make(chan struct{})}
+	c.inflight[path] = call
+	c.mu.Unlock()
+
+	call.cf, call.err = c.fill(path, info)
+
+	c.mu.Lock()
+	delete(c.inflight, path)
+	c.mu.Unlock()
+	close(call.done)
+	return call.cf, call.err
+}
+
+// fill parses a log file into fresh stats and hourly timeline accumulators
+// and stores them in the cache.
+func (c *StatsCache) fill(path string, info os.FileInfo) (*cachedFile, error) {
+	stats := NewStatsAccumulator(time.Time{}, time.Time{})
+	timeline := NewTimelineAccumulator(time.Hour)
+	err := processFile(path, func(r Record) {
+		stats.AddRecord(r)
+		timeline.AddRecord(r)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cf := &cachedFile{
+		path:     path,
 		modTime:  info.ModTime(),
 		size:     info.Size(),
+		bytes:    stats.estimatedBytes() + timeline.estimatedBytes(),
 		stats:    stats,
 		timeline: timeline,
 	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old, ok := c.files[path]; ok {
+		c.remove(old)
+	}
+	cf.elem = c.lru.PushFront(cf)
+	c.files[path] = cf
+	c.bytes += cf.bytes
+	c.evict()
+	return cf, nil
+}
+
+// evict drops least-recently-used entries until the cache fits its budget.
+// The most recent entry is always kept so a single oversized file still
+// benefits from caching. Caller must hold c.mu.
+func (c *StatsCache) evict() {
+	if c.maxBytes <= 0 {
+		return
+	}
+	for c.bytes > c.maxBytes && c.lru.Len() > 1 {
+		c.remove(c.lru.Back().Value.(*cachedFile))
+		c.evictions++
+	}
+}
+
+// remove deletes an entry. Caller must hold c.mu.
+func (c *StatsCache) remove(cf *cachedFile) {
+	c.lru.Remove(cf.elem)
+	delete(c.files, cf.path)
+	c.bytes -= cf.bytes
 }
 
-// processFile parses a single log file, calling fn for each entry.
-func processFile(path string, fn func(*LogEntry)) error {
+// sweep drops entries whose log file no longer exists. It runs at most once
+// per sweepInterval unless force is set.
+func (c *StatsCache) sweep(force bool) {
+	c.mu.Lock()
+	if !force && time.Since(c.lastSweep) < sweepInterval {
+		c.mu.Unlock()
+		return
+	}
+	c.lastSweep = time.Now()
+	paths := make([]string, 0, len(c.files))
+	for p := range c.files {
+		paths = append(paths, p)
+	}
+	c.mu.Unlock()
+
+	// Stat outside the lock; a concurrent put for a missing file is harmless.
+	var gone []string
+	for _, p := range paths {
+		if _, err := os.Stat(p); errors.Is(err, fs.ErrNotExist) {
+			gone = append(gone, p)
+		}
+	}
+	if len(gone) == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, p := range gone {
+		if cf, ok := c.files[p]; ok {
+			c.remove(cf)
+		}
+	}
+}
+
+// Stats returns current cache occupancy and hit/miss counters, after
+// dropping entries for deleted files.
+func (c *StatsCache) Stats() CacheStats {
+	c.sweep(true)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return CacheStats{
+		Entries:        len(c.files),
+		EstimatedBytes: c.bytes,
+		MaxBytes:       c.maxBytes,
+		Hits:           c.hits,
+		Misses:         c.misses,
+		Coalesced:      c.coalesced,
+		Evictions:      c.evictions,
+	}
+}
+
+// processFile parses a single log file, calling fn for each record.
+func processFile(path string, fn func(Record)) error {
 	f, err := os.Open(path)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
+	p := NewRecordParser()
 	scanner := bufio.NewScanner(f)
 	scanner.Buffer(make([]byte, 0, 1024*1024), 1024*1024)
 	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
+		line := scanner.Bytes()
+		if len(line) == 0 {
 			continue
 		}
-		entry, err := ParseLine(line)
+		rec, err := p.Parse(line)
 		if err != nil {
 			continue
 		}
-		fn(entry)
+		fn(rec)
 	}
 	return scanner.Err()
 }
 
 // ComputeStats builds stats for a date range using cached per-file accumulators.
 func (c *StatsCache) ComputeStats(logDir string, start, end time.Time) *StatsResponse {
+	c.sweep(false)
 	files := LogFilesForRange(logDir, start, end)
 	combined := NewStatsAccumulator(start, end)
 
 	for _, path := range files {
-		info, err := os.Stat(path)
+		cf, err := c.load(path)
 		if err != nil {
 			continue
 		}
-
-		if cached := c.get(path, info); cached != nil {
-			combined.Merge(cached.stats)
-			continue
-		}
-
-		// Cache miss — parse file, cache result
-		fileAcc := NewStatsAccumulator(start, end)
-		fileTl := NewTimelineAccumulator(time.Hour)
-		processFile(path, func(e *LogEntry) {
-			fileAcc.Add(e)
-			fileTl.Add(e)
-		})
-		c.put(path, info, fileAcc, fileTl)
-		combined.Merge(fileAcc)
+		combined.Merge(cf.stats)
 	}
 
 	return combined.Finalize(len(files))
@@ -108,29 +275,16 @@ func (c *StatsCache) ComputeStats(logDir string, start, end time.Time) *StatsRes
 // ComputeTimeline builds timeline for a date range, re-aggregating cached
 // hourly buckets to the requested interval.
 func (c *StatsCache) ComputeTimeline(logDir string, start, end time.Time, interval time.Duration) []TimelineBucket {
+	c.sweep(false)
 	files := LogFilesForRange(logDir, start, end)
 	combined := NewTimelineAccumulator(time.Hour)
 
 	for _, path := range files {
-		info, err := os.Stat(path)
+		cf, err := c.load(path)
 		if err != nil {
 			continue
 		}
-
-		if cached := c.get(path, info); cached != nil {
-			combined.Merge(cached.timeline)
-			continue
-		}
-
-		// Cache miss — parse, cache both stats + timeline
-		fileAcc := NewStatsAccumulator(start, end)
-		fileTl := NewTimelineAccumulator(time.Hour)
-		processFile(path, func(e *LogEntry) {
-			fileAcc.Add(e)
-			fileTl.Add(e)
-		})
-		c.put(path, info, fileAcc, fileTl)
-		combined.Merge(fileTl)
+		combined.Merge(cf.timeline)
 	}
 
 	if interval == time.Hour {
@@ -3,6 +3,7 @@ package logparser
 import (
 	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -280,6 +281,153 @@ func TestStatsCacheInvalidation(t *testing.T) {
 	}
 }
 
+func TestStatsCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	dir := t.TempDir()
+	for _, day := range []string{"2026-02-14", "2026-02-15", "2026-02-16"} {
+		writeTestLogFile(t, dir+"/"+day+"_ALL.log", []string{
+			day + " 00:00:00\t10.0.0.1\tPC\t0\tRESOLVED\texample.com.\tA (1.2.3.4)\tNOERROR\tRESOLVED\tA\tblocky",
+		})
+	}
+	computeDay := func(c *StatsCache, d int) {
+		start := time.Date(2026, 2, d, 0, 0, 0, 0, time.UTC)
+		c.ComputeStats(dir, start, start.Add(24*time.Hour-time.Second))
+	}
+
+	// Size the budget so that exactly two single-line days fit.
+	probe := NewBoundedStatsCache(0)
+	computeDay(probe, 14)
+	perFile := probe.Stats().EstimatedBytes
+	cache := NewBoundedStatsCache(2*perFile + perFile/2)
+
+	computeDay(cache, 14)
+	computeDay(cache, 15)
+	computeDay(cache, 14) // touch 14 so 15 becomes least recently used
+	computeDay(cache, 16)
+
+	st := cache.Stats()
+	if st.Entries != 2 {
+		t.Fatalf("Entries = %d, want 2", st.Entries)
+	}
+	if st.Evictions != 1 {
+		t.Errorf("Evictions = %d, want 1", st.Evictions)
+	}
+	if st.EstimatedBytes > st.MaxBytes {
+		t.Errorf("EstimatedBytes = %d exceeds MaxBytes = %d", st.EstimatedBytes, st.MaxBytes)
+	}
+	cache.mu.RLock()
+	_, has14 := cache.files[dir+"/2026-02-14_ALL.log"]
+	_, has15 := cache.files[dir+"/2026-02-15_ALL.log"]
+	cache.mu.RUnlock()
+	if !has14 || has15 {
+		t.Errorf("expected 2026-02-15 to be evicted, has14=%v has15=%v", has14, has15)
+	}
+}
+
+func TestStatsCacheHitMissAndDeletedFiles(t *testing.T) {
+	dir := t.TempDir()
+	logFile := dir + "/2026-02-14_ALL.log"
+	writeTestLogFile(t, logFile, []string{
+		"2026-02-14 00:00:00\t10.0.0.1\tPC\t0\tRESOLVED\texample.com.\tA (1.2.3.4)\tNOERROR\tRESOLVED\tA\tblocky",
+	})
+
+	cache := NewStatsCache()
+	start := time.Date(2026, 2, 14, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2026, 2, 14, 23, 59, 59, 0, time.UTC)
+
+	cache.ComputeStats(dir, start, end)
+	cache.ComputeTimeline(dir, start, end, time.Hour)
+
+	st := cache.Stats()
+	if st.Misses != 1 || st.Hits != 1 {
+		t.Errorf("Misses/Hits = %d/%d, want 1/1", st.Misses, st.Hits)
+	}
+	if st.Entries != 1 || st.EstimatedBytes <= 0 {
+		t.Errorf("Entries = %d, EstimatedBytes = %d, want 1 and > 0", st.Entries, st.EstimatedBytes)
+	}
+
+	if err := os.Remove(logFile); err != nil {
+		t.Fatal(err)
+	}
+	st = cache.Stats()
+	if st.Entries != 0 || st.EstimatedBytes != 0 {
+		t.Errorf("after delete: Entries = %d, EstimatedBytes = %d, want 0", st.Entries, st.EstimatedBytes)
+	}
+}
+
+func TestStatsCacheCoalescesConcurrentMisses(t *testing.T) {
+	dir := t.TempDir()
+	lines := make([]string, 5000)
+	for i := range lines {
+		lines[i] = "2026-02-14 00:00:00\t10.0.0.1\tPC\t0\tRESOLVED\texample.com.\tA (1.2.3.4)\tNOERROR\tRESOLVED\tA\tblocky"
+	}
+	writeTestLogFile(t, dir+"/2026-02-14_ALL.log", lines)
+
+	cache := NewStatsCache()
+	start := time.Date(2026, 2, 14, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2026, 2, 14, 23, 59, 59, 0, time.UTC)
+
+	const callers = 8
+	var wg sync.WaitGroup
+	for i := range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i%2 == 0 {
+				if got := cache.ComputeStats(dir, start, end).Summary.TotalQueries; got != len(lines) {
+					t.Errorf("TotalQueries = %d, want %d", got, len(lines))
+				}
+			} else {
+				if tl := cache.ComputeTimeline(dir, start, end, time.Hour); len(tl) != 1 || tl[0].Total != len(lines) {
+					t.Errorf("timeline = %+v, want one bucket of %d", tl, len(lines))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	st := cache.Stats()
+	if st.Misses != 1 {
+		t.Errorf("Misses = %d, want 1", st.Misses)
+	}
+	if st.Hits+st.Coalesced != callers-1 {
+		t.Errorf("Hits+Coalesced = %d, want %d", st.Hits+st.Coalesced, callers-1)
+	}
+}
+
+func TestStatsCachePrewarm(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	for _, d := range []time.Time{now, now.AddDate(0, 0, -1), now.AddDate(0, 0, -10)} {
+		day := d.Format("2006-01-02")
+		writeTestLogFile(t, dir+"/"+day+"_ALL.log", []string{
+			day + " 00:00:00\t10.0.0.1\tPC\t0\tRESOLVED\texample.com.\tA (1.2.3.4)\tNOERROR\tRESOLVED\tA\tblocky",
+		})
+	}
+
+	cache := NewStatsCache()
+	if st := cache.Warmup(); st.State != "pending" {
+		t.Errorf("initial State = %q, want pending", st.State)
+	}
+	cache.Prewarm(dir, 7)
+
+	st := cache.Warmup()
+	if st.State != "done" || st.FilesTotal != 2 || st.FilesDone != 2 {
+		t.Errorf("Warmup = %+v, want done with 2/2 files", st)
+	}
+	if st.StartedAt == nil || st.FinishedAt == nil {
+		t.Errorf("expected StartedAt and FinishedAt to be set: %+v", st)
+	}
+	if entries := cache.Stats().Entries; entries != 2 {
+		t.Errorf("Entries = %d, want 2 (file outside lookback must not be loaded)", entries)
+	}
+
+	disabled := NewStatsCache()
+	disabled.Prewarm(dir, 0)
+	if st := disabled.Warmup(); st.State != "disabled" || st.FilesTotal != 0 {
+		t.Errorf("Warmup with prewarm off = %+v, want disabled", st)
+	}
+}
+
 func writeTestLogFile(t *testing.T, path string, lines []string) {
 	t.Helper()
 	content := strings.Join(lines, "\n") + "\n"
@@ -0,0 +1,94 @@
+package logparser
+
+import "time"
+
+// maxOpenGroups bounds the groups a windowed Collapser keeps before dropping
+// those that can no longer be extended.
+const maxOpenGroups = 4096
+
+type collapseKey struct {
+	client, domain, qtype, outcome string
+}
+
+func collapseKeyOf(e *LogEntry) collapseKey {
+	outcome := "resolved"
+	if e.IsBlocked() {
+		outcome = "blocked"
+	} else if e.IsCached() {
+		outcome = "cached"
+	}
+	return collapseKey{e.ClientIP, e.Domain, e.QueryType, outcome + " " + e.ReturnCode}
+}
+
+// Collapser folds repeated queries (same client, domain, query type and
+// outcome) into a single row carrying a count and first/last timestamps.
+// With a zero window only consecutive repeats are folded; otherwise a repeat
+// joins its earlier group as long as the group spans at most window.
+// Entries may be added newest-first or oldest-first.
+type Collapser struct {
+	window  time.Duration
+	last    *LogEntry
+	lastKey collapseKey
+	open    map[collapseKey]*LogEntry
+	groups  int
+}
+
+func NewCollapser(window time.Duration) *Collapser {
+	return &Collapser{window: window, open: make(map[collapseKey]*LogEntry)}
+}
+
+// Add returns the group e was folded into and true, or e itself set up as a
+// new group and false. Groups are numbered from 1 in creation order.
+func (c *Collapser) Add(e *LogEntry) (*LogEntry, bool) {
+	key := collapseKeyOf(e)
+	if c.window <= 0 {
+		if c.last != nil && c.lastKey == key {
+			c.extend(c.last, e.Timestamp)
+			return c.last, true
+		}
+		c.last, c.lastKey = c.start(e), key
+		return e, false
+	}
+
+	if g := c.open[key]; g != nil && c.fits(g, e.Timestamp) {
+		c.extend(g, e.Timestamp)
+		return g, true
+	}
+	if len(c.open) >= maxOpenGroups {
+		for k, g := range c.open {
+			if !c.fits(g, e.Timestamp) {
+				delete(c.open, k)
+			}
+		}
+	}
+	c.open[key] = c.start(e)
+	return e, false
+}
+
+func (c *Collapser) start(e *LogEntry) *LogEntry {
+	c.groups++
+	first, last := e.Timestamp, e.Timestamp
+	e.Count, e.FirstSeen, e.LastSeen, e.Group = 1, &first, &last, c.groups
+	return e
+}
+
+func (c *Collapser) fits(g *LogEntry, t time.Time) bool {
+	first, last := *g.FirstSeen, *g.LastSeen
+	if t.Before(first) {
+		first = t
+	}
+	if t.After(last) {
+		last = t
+	}
+	return last.Sub(first) <= c.window
+}
+
+func (c *Collapser) extend(g *LogEntry, t time.Time) {
+	g.Count++
+	if t.Before(*g.FirstSeen) {
+		*g.FirstSeen = t
+	}
+	if t.After(*g.LastSeen) {
+		*g.LastSeen = t
+	}
+}
@@ -0,0 +1,64 @@
+package logparser
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCollapserConsecutive(t *testing.T) {
+	base := time.Date(2026, 2, 14, 10, 0, 0, 0, time.UTC)
+	entry := func(sec int, domain, reason string) *LogEntry {
+		return &LogEntry{Timestamp: base.Add(time.Duration(sec) * time.Second), ClientIP: "10.0.0.5", Domain: domain, QueryType: "A", ResponseReason: reason, ReturnCode: "NOERROR"}
+	}
+
+	// Newest first, as /api/logs scans.
+	input := []*LogEntry{
+		entry(50, "tv.com.", "RESOLVED (udp:1.1.1.1)"),
+		entry(40, "tv.com.", "RESOLVED (udp:8.8.8.8)"),
+		entry(30, "tv.com.", "CACHED"),
+		entry(20, "other.com.", "RESOLVED"),
+		entry(10, "tv.com.", "RESOLVED"),
+	}
+	c := NewCollapser(0)
+	var rows []*LogEntry
+	for _, e := range input {
+		if _, merged := c.Add(e); !merged {
+			rows = append(rows, e)
+		}
+	}
+	if len(rows) != 4 {
+		t.Fatalf("rows = %d, want 4", len(rows))
+	}
+	g := rows[0]
+	if g.Count != 2 || !g.FirstSeen.Equal(base.Add(40*time.Second)) || !g.LastSeen.Equal(base.Add(50*time.Second)) {
+		t.Errorf("group = count %d first %v last %v", g.Count, g.FirstSeen, g.LastSeen)
+	}
+	if rows[1].Count != 1 || rows[3].Group != 4 {
+		t.Errorf("rows[1].Count = %d, rows[3].Group = %d", rows[1].Count, rows[3].Group)
+	}
+}
+
+func TestCollapserWindow(t *testing.T) {
+	base := time.Date(2026, 2, 14, 10, 0, 0, 0, time.UTC)
+	entry := func(min int, domain string) *LogEntry {
+		return &LogEntry{Timestamp: base.Add(time.Duration(min) * time.Minute), ClientIP: "10.0.0.5", Domain: domain, QueryType: "A", ResponseReason: "RESOLVED", ReturnCode: "NOERROR"}
+	}
+
+	// Oldest first, as the live stream sees them.
+	c := NewCollapser(5 * time.Minute)
+	var rows []*LogEntry
+	for _, e := range []*LogEntry{entry(0, "tv.com."), entry(1, "other.com."), entry(3, "tv.com."), entry(5, "tv.com."), entry(6, "tv.com.")} {
+		group, merged := c.Add(e)
+		if !merged {
+			rows = append(rows, e)
+		} else if group.Domain != e.Domain {
+			t.Errorf("merged %s into %s group", e.Domain, group.Domain)
+		}
+	}
+	if len(rows) != 3 {
+		t.Fatalf("rows = %d, want 3", len(rows))
+	}
+	if rows[0].Count != 3 || rows[2].Count != 1 || !rows[2].FirstSeen.Equal(base.Add(6*time.Minute)) {
+		t.Errorf("counts = %d, %d, %d", rows[0].Count, rows[1].Count, rows[2].Count)
+	}
+}
@@ -0,0 +1,116 @@
+package logparser
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// maxContextDays bounds how many days before and after the anchor's day are
+// searched when a client has too few queries near the anchor.
+const maxContextDays = 7
+
+// ContextResponse holds the entries surrounding an anchor entry, each list in
+// chronological order. Anchor is nil if no entry from the client was logged
+// at the requested second; Before and After are then split at that time.
+type ContextResponse struct {
+	Anchor *LogEntry   `json:"anchor"`
+	Before []*LogEntry `json:"before"`
+	After  []*LogEntry `json:"after"`
+}
+
+// ContextQuery selects an anchor entry and its surroundings. Client matches
+// the client IP or name exactly; Domain optionally narrows the anchor when
+// the client made several queries in the same second. With AllClients the
+// surrounding entries come from every client, not just the anchor's.
+type ContextQuery struct {
+	Timestamp  time.Time
+	Client     string
+	Domain     string
+	N          int
+	AllClients bool
+}
+
+func (q ContextQuery) isClient(e *LogEntry) bool {
+	return q.Client == "" || e.ClientIP == q.Client || e.ClientName == q.Client
+}
+
+func (q ContextQuery) isAnchor(e *LogEntry) bool {
+	return e.Timestamp.Equal(q.Timestamp) && q.isClient(e) &&
+		(q.Domain == "" || normalizeDomain(e.Domain) == normalizeDomain(q.Domain))
+}
+
+func (q ContextQuery) include(e *LogEntry) bool {
+	return q.AllClients || q.isClient(e)
+}
+
+// SurroundingEntries returns up to q.N entries before and after the anchor,
+// continuing into the previous and next day files across midnight. Files
+// are streamed and reading stops as soon as each side has q.N entries.
+func SurroundingEntries(logDir string, q ContextQuery) (*ContextResponse, error) {
+	ts := q.Timestamp.UTC()
+	day := time.Date(ts.Year(), ts.Month(), ts.Day(), 0, 0, 0, 0, time.UTC)
+
+	resp := &ContextResponse{Before: []*LogEntry{}, After: []*LogEntry{}}
+	passed := false // past the anchor or its time
+	_, err := ScanFrom(dayLogPath(logDir, day), 0, func(e *LogEntry, _ int64) bool {
+		if !passed {
+			if q.isAnchor(e) {
+				resp.Anchor = e
+				passed = true
+				return true
+			}
+			if !e.Timestamp.After(ts) {
+				if q.include(e) {
+					resp.Before = append(resp.Before, e)
+					if len(resp.Before) > q.N {
+						resp.Before = resp.Before[1:]
+					}
+				}
+				return true
+			}
+			passed = true
+		}
+		if q.include(e) {
+			resp.After = append(resp.After, e)
+		}
+		return len(resp.After) < q.N
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if need := q.N - len(resp.Before); need > 0 {
+		var older []*LogEntry // newest first
+		err := ScanReverse(logDir, day.AddDate(0, 0, -maxContextDays), day.AddDate(0, 0, -1), nil, func(e *LogEntry, _ Cursor) bool {
+			if q.include(e) {
+				older = append(older, e)
+			}
+			return len(older) < need
+		})
+		if err != nil {
+			return nil, err
+		}
+		for i, j := 0, len(older)-1; i < j; i, j = i+1, j-1 {
+			older[i], older[j] = older[j], older[i]
+		}
+		resp.Before = append(older, resp.Before...)
+	}
+
+	if len(resp.After) < q.N {
+		err := ScanForward(logDir, day.AddDate(0, 0, 1), day.AddDate(0, 0, maxContextDays), func(e *LogEntry) bool {
+			if q.include(e) {
+				resp.After = append(resp.After, e)
+			}
+			return len(resp.After) < q.N
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+func dayLogPath(logDir string, day time.Time) string {
+	return filepath.Join(logDir, fmt.Sprintf("%s_ALL.log", day.Format("2006-01-02")))
+}
@@ -0,0 +1,93 @@
+package logparser
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSurroundingEntries(t *testing.T) {
+	dir := t.TempDir()
+	writeTestLogFile(t, filepath.Join(dir, "2026-02-13_ALL.log"), []string{
+		"2026-02-13 23:58:00\t10.0.0.1\tPC\t5\tRESOLVED\ta.com.\tA (1.1.1.1)\tNOERROR\tRESOLVED\tA\tblocky",
+		"2026-02-13 23:59:00\t10.0.0.1\tPC\t5\tRESOLVED\tb.com.\tA (1.1.1.1)\tNOERROR\tRESOLVED\tA\tblocky",
+	})
+	writeTestLogFile(t, filepath.Join(dir, "2026-02-14_ALL.log"), []string{
+		"2026-02-14 00:00:10\t10.0.0.2\tPhone\t5\tRESOLVED\tphone.com.\tA (1.1.1.1)\tNOERROR\tRESOLVED\tA\tblocky",
+		"2026-02-14 00:00:20\t10.0.0.1\tPC\t5\tRESOLVED\tc.com.\tA (1.1.1.1)\tNOERROR\tRESOLVED\tA\tblocky",
+		"2026-02-14 00:00:30\t10.0.0.1\tPC\t0\tBLOCKED (ads)\tads.com.\tA (0.0.0.0)\tNOERROR\tBLOCKED (ads)\tA\tblocky",
+		"2026-02-14 00:00:30\t10.0.0.1\tPC\t5\tRESOLVED\td.com.\tA (1.1.1.1)\tNOERROR\tRESOLVED\tA\tblocky",
+		"2026-02-14 00:00:40\t10.0.0.2\tPhone\t5\tRESOLVED\tphone2.com.\tA (1.1.1.1)\tNOERROR\tRESOLVED\tA\tblocky",
+		"2026-02-14 00:00:50\t10.0.0.1\tPC\t5\tRESOLVED\te.com.\tA (1.1.1.1)\tNOERROR\tRESOLVED\tA\tblocky",
+	})
+
+	domains := func(entries []*LogEntry) []string {
+		var out []string
+		for _, e := range entries {
+			out = append(out, e.Domain)
+		}
+		return out
+	}
+	equal := func(a, b []string) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	ts := time.Date(2026, 2, 14, 0, 0, 30, 0, time.UTC)
+	resp, err := SurroundingEntries(dir, ContextQuery{Timestamp: ts, Client: "10.0.0.1", Domain: "ads.com", N: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Anchor == nil || resp.Anchor.Domain != "ads.com." {
+		t.Fatalf("anchor = %+v, want ads.com.", resp.Anchor)
+	}
+	if got, want := domains(resp.Before), []string{"a.com.", "b.com.", "c.com."}; !equal(got, want) {
+		t.Errorf("before = %v, want %v", got, want)
+	}
+	if got, want := domains(resp.After), []string{"d.com.", "e.com."}; !equal(got, want) {
+		t.Errorf("after = %v, want %v", got, want)
+	}
+
+	resp, err = SurroundingEntries(dir, ContextQuery{Timestamp: ts, Client: "PC", N: 2, AllClients: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := domains(resp.Before), []string{"phone.com.", "c.com."}; !equal(got, want) {
+		t.Errorf("all clients before = %v, want %v", got, want)
+	}
+	if got, want := domains(resp.After), []string{"d.com.", "phone2.com."}; !equal(got, want) {
+		t.Errorf("all clients after = %v, want %v", got, want)
+	}
+
+	// No entry at the requested second: split at that time without an anchor.
+	resp, err = SurroundingEntries(dir, ContextQuery{Timestamp: ts.Add(-5 * time.Second), Client: "10.0.0.1", N: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Anchor != nil || !equal(domains(resp.Before), []string{"c.com."}) || !equal(domains(resp.After), []string{"ads.com."}) {
+		t.Errorf("unanchored = %+v %v %v", resp.Anchor, domains(resp.Before), domains(resp.After))
+	}
+
+	// Days without a log file are skipped, not the end of the search.
+	writeTestLogFile(t, filepath.Join(dir, "2026-02-10_ALL.log"), []string{
+		"2026-02-10 12:00:00\t10.0.0.1\tPC\t5\tRESOLVED\told.com.\tA (1.1.1.1)\tNOERROR\tRESOLVED\tA\tblocky",
+	})
+	resp, err = SurroundingEntries(dir, ContextQuery{Timestamp: ts, Client: "10.0.0.1", Domain: "ads.com", N: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := domains(resp.Before), []string{"old.com.", "a.com.", "b.com.", "c.com."}; !equal(got, want) {
+		t.Errorf("before across a gap = %v, want %v", got, want)
+	}
+
+	if _, err := SurroundingEntries(dir, ContextQuery{Timestamp: ts.AddDate(0, 0, 5), Client: "PC", N: 1}); err == nil {
+		t.Error("missing day file: want error")
+	}
+}
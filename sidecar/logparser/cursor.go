@@ -0,0 +1,239 @@
+package logparser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrStaleCursor is returned when the line a cursor points at no longer
+// holds the entry it was made from, because the file was rewritten.
+var ErrStaleCursor = errors.New("log file changed since the cursor was issued")
+
+// Cursor marks a position in the log history for newest-first pagination.
+// The next page starts with the line that ends just before Offset in the log
+// file for Day. Timestamp is that of the last entry already returned, which
+// starts at Offset; a zero Timestamp skips that check.
+type Cursor struct {
+	Day       string // log file date, "2006-01-02"
+	Offset    int64
+	Timestamp time.Time
+}
+
+// Encode returns the opaque string form of the cursor.
+func (c Cursor) Encode() string {
+	raw := fmt.Sprintf("%d:%s:%d", c.Timestamp.Unix(), c.Day, c.Offset)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor parses a cursor produced by Encode.
+func DecodeCursor(s string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor")
+	}
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != 3 {
+		return Cursor{}, fmt.Errorf("invalid cursor")
+	}
+	ts, err1 := strconv.ParseInt(parts[0], 10, 64)
+	offset, err2 := strconv.ParseInt(parts[2], 10, 64)
+	_, err3 := time.Parse("2006-01-02", parts[1])
+	if err1 != nil || err2 != nil || err3 != nil || offset < 0 {
+		return Cursor{}, fmt.Errorf("invalid cursor")
+	}
+	return Cursor{Day: parts[1], Offset: offset, Timestamp: time.Unix(ts, 0).UTC()}, nil
+}
+
+// check reports ErrStaleCursor if the line at c.Offset is not the entry
+// the cursor was made from.
+func (c Cursor) check(logDir string) error {
+	if c.Timestamp.IsZero() {
+		return nil
+	}
+	f, err := os.Open(filepath.Join(logDir, c.Day+"_ALL.log"))
+	if err != nil {
+		return ErrStaleCursor
+	}
+	defer f.Close()
+	line, err := bufio.NewReaderSize(io.NewSectionReader(f, c.Offset, 1<<20), 4096).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	entry, err := ParseLine(strings.TrimRight(line, "\r\n"))
+	if err != nil || entry.Timestamp.Unix() != c.Timestamp.Unix() {
+		return ErrStaleCursor
+	}
+	return nil
+}
+
+// ScanReverse walks the log files in the date range newest-first, reading each
+// file backwards, and calls fn for every parsed entry together with the cursor
+// that resumes just after it. Scanning starts at from if it is non-nil and
+// stops as soon as fn returns false. Memory use is bounded by the longest line.
+func ScanReverse(logDir string, start, end time.Time, from *Cursor, fn func(e *LogEntry, next Cursor) bool) error {
+	if from != nil {
+		if err := from.check(logDir); err != nil {
+			return err
+		}
+	}
+	files := LogFilesForRange(logDir, start, end)
+	for i := len(files) - 1; i >= 0; i-- {
+		day := logFileDay(files[i])
+		offset := int64(-1) // from end of file
+		if from != nil {
+			if day > from.Day {
+				continue
+			}
+			if day == from.Day {
+				offset = from.Offset
+			}
+		}
+		more, err := scanFileReverse(files[i], day, offset, fn)
+		if err != nil {
+			return err
+		}
+		if !more {
+			return nil
+		}
+	}
+	return nil
+}
+
+// logFileDay extracts the date from a "2006-01-02_ALL.log" path.
+func logFileDay(path string) string {
+	name := filepath.Base(path)
+	if len(name) < 10 {
+		return name
+	}
+	return name[:10]
+}
+
+func scanFileReverse(path, day string, offset int64, fn func(*LogEntry, Cursor) bool) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return true, nil // file disappeared; skip like LoadEntriesForRange
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return true, nil
+	}
+	if offset < 0 || offset > info.Size() {
+		offset = info.Size()
+	}
+
+	r := newReverseLineReader(f, offset)
+	for {
+		line, lineStart, err := r.Prev()
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("read %s: %w", filepath.Base(path), err)
+		}
+		if len(line) == 0 {
+			continue
+		}
+		entry, err := ParseLine(string(line))
+		if err != nil {
+			continue
+		}
+		if !fn(entry, Cursor{Day: day, Offset: lineStart, Timestamp: entry.Timestamp}) {
+			return false, nil
+		}
+	}
+}
+
+const reverseChunkSize = 64 * 1024
+
+// reverseLineReader yields the lines of a file from a given offset towards
+// the beginning of the file.
+type reverseLineReader struct {
+	r       io.ReaderAt
+	pos     int64  // file offset of buf[0]
+	buf     []byte // unread bytes in [pos, end of the next line)
+	trimmed bool   // trailing newline of the first line has been dropped
+	done    bool
+}
+
+func newReverseLineReader(r io.ReaderAt, end int64) *reverseLineReader {
+	return &reverseLineReader{r: r, pos: end}
+}
+
+// Prev returns the line preceding the previously returned one, along with
+// the file offset at which it starts. It returns io.EOF once the start of
+// the file is reached.
+func (r *reverseLineReader) Prev() ([]byte, int64, error) {
+	for {
+		if !r.trimmed && (len(r.buf) > 0 || r.pos == 0) {
+			// The newline terminating the last line does not start another one.
+			if n := len(r.buf); n > 0 && r.buf[n-1] == '\n' {
+				r.buf = r.buf[:n-1]
+			}
+			r.trimmed = true
+		}
+		if r.trimmed {
+			if i := bytes.LastIndexByte(r.buf, '\n'); i >= 0 {
+				line := r.buf[i+1:]
+				r.buf = r.buf[:i]
+				return line, r.pos + int64(i) + 1, nil
+			}
+		}
+		if r.pos == 0 {
+			if r.done {
+				return nil, 0, io.EOF
+			}
+			r.done = true
+			return r.buf, 0, nil
+		}
+		n := int64(reverseChunkSize)
+		if n > r.pos {
+			n = r.pos
+		}
+		chunk := make([]byte, n, n+int64(len(r.buf)))
+		if _, err := r.r.ReadAt(chunk, r.pos-n); err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+		r.buf = append(chunk, r.buf...)
+		r.pos -= n
+	}
+}
+
+// CompleteLinesEnd returns the offset just past the last newline in the file,
+// excluding a line that is still being written. A Cursor with this offset
+// starts a newest-first scan at the current end of the log.
+func CompleteLinesEnd(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, reverseChunkSize)
+	for end := info.Size(); end > 0; {
+		start := max(end-reverseChunkSize, 0)
+		n, err := f.ReadAt(buf[:end-start], start)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			return start + int64(i) + 1, nil
+		}
+		end = start
+	}
+	return 0, nil
+}
@@ -0,0 +1,172 @@
+package logparser
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReverseLineReader(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		end  int64
+		want []string
+	}{
+		{"trailing newline", "a\nbb\nccc\n", -1, []string{"ccc", "bb", "a"}},
+		{"no trailing newline", "a\nbb\nccc", -1, []string{"ccc", "bb", "a"}},
+		{"from line start", "a\nbb\nccc\n", 2, []string{"a"}},
+		{"empty lines", "a\n\nb\n", -1, []string{"b", "", "a"}},
+		{"empty file", "", -1, []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			end := tt.end
+			if end < 0 {
+				end = int64(len(tt.data))
+			}
+			r := newReverseLineReader(strings.NewReader(tt.data), end)
+			var got []string
+			for {
+				line, start, err := r.Prev()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.HasPrefix(tt.data[start:], string(line)) {
+					t.Errorf("line %q does not start at offset %d", line, start)
+				}
+				got = append(got, string(line))
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("lines = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanReversePaginatesAcrossFiles(t *testing.T) {
+	dir := t.TempDir()
+	// Enough lines to span several read chunks per file.
+	const perFile = 3000
+	for _, day := range []string{"2026-02-14", "2026-02-15"} {
+		lines := make([]string, perFile)
+		for i := range lines {
+			ts := fmt.Sprintf("%s %02d:%02d:%02d", day, i/3600, i/60%60, i%60)
+			lines[i] = ts + "\t10.0.0.1\tPC\t0\tRESOLVED\thost" + fmt.Sprint(i) + ".example.com.\tA (1.2.3.4)\tNOERROR\tRESOLVED\tA\tblocky"
+		}
+		writeTestLogFile(t, dir+"/"+day+"_ALL.log", lines)
+	}
+
+	start := time.Date(2026, 2, 14, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2026, 2, 15, 23, 59, 59, 0, time.UTC)
+
+	var all []*LogEntry
+	var from *Cursor
+	pages := 0
+	for {
+		var page []*LogEntry
+		var next Cursor
+		err := ScanReverse(dir, start, end, from, func(e *LogEntry, c Cursor) bool {
+			page = append(page, e)
+			next = c
+			return len(page) < 700
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		all = append(all, page...)
+		if len(page) < 700 {
+			break
+		}
+		decoded, err := DecodeCursor(next.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		from = &decoded
+	}
+
+	if len(all) != 2*perFile {
+		t.Fatalf("got %d entries over %d pages, want %d", len(all), pages, 2*perFile)
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i].Timestamp.After(all[i-1].Timestamp) {
+			t.Fatalf("entry %d (%v) is newer than entry %d (%v)", i, all[i].Timestamp, i-1, all[i-1].Timestamp)
+		}
+	}
+	if all[0].Domain != "host2999.example.com." || all[len(all)-1].Domain != "host0.example.com." {
+		t.Errorf("first/last = %q/%q", all[0].Domain, all[len(all)-1].Domain)
+	}
+}
+
+func TestScanReverseStaleCursor(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "2026-02-14_ALL.log")
+	lines := []string{
+		"2026-02-14 10:00:00\t10.0.0.1\tPC\t5\tRESOLVED\ta.com.\tA (1.1.1.1)\tNOERROR\tRESOLVED\tA\tblocky",
+		"2026-02-14 10:00:01\t10.0.0.1\tPC\t5\tRESOLVED\tb.com.\tA (1.1.1.1)\tNOERROR\tRESOLVED\tA\tblocky",
+		"2026-02-14 10:00:02\t10.0.0.1\tPC\t5\tRESOLVED\tc.com.\tA (1.1.1.1)\tNOERROR\tRESOLVED\tA\tblocky",
+	}
+	writeTestLogFile(t, path, lines)
+	day := time.Date(2026, 2, 14, 0, 0, 0, 0, time.UTC)
+
+	var from Cursor
+	ScanReverse(dir, day, day, nil, func(e *LogEntry, c Cursor) bool {
+		from = c
+		return e.Domain != "b.com."
+	})
+	if err := ScanReverse(dir, day, day, &from, func(*LogEntry, Cursor) bool { return true }); err != nil {
+		t.Fatalf("unchanged file: %v", err)
+	}
+
+	// Rewritten with other lines: the cursor's offset now starts a
+	// different entry.
+	writeTestLogFile(t, path, []string{lines[0], lines[2], lines[2]})
+	err := ScanReverse(dir, day, day, &from, func(*LogEntry, Cursor) bool { return true })
+	if !errors.Is(err, ErrStaleCursor) {
+		t.Errorf("rewritten file: err = %v, want ErrStaleCursor", err)
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	for _, s := range []string{"", "!!!", Cursor{Day: "bogus", Offset: 1}.Encode()} {
+		if _, err := DecodeCursor(s); err == nil {
+			t.Errorf("DecodeCursor(%q): expected error", s)
+		}
+	}
+}
+
+func TestScanFromOffsets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "2026-02-14_ALL.log")
+	l1 := "2026-02-14 10:00:00\t10.0.0.1\tPC\t5\tRESOLVED\ta.com.\tA (1.1.1.1)\tNOERROR\tRESOLVED\tA\tblocky\n"
+	l2 := "2026-02-14 10:00:01\t10.0.0.1\tPC\t5\tRESOLVED\tb.com.\tA (1.1.1.1)\tNOERROR\tRESOLVED\tA\tblocky\n"
+	partial := "2026-02-14 10:00:02\t10.0.0.1\tPC"
+	if err := os.WriteFile(path, []byte(l1+l2+partial), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var domains []string
+	var ends []int64
+	end, err := ScanFrom(path, int64(len(l1)), func(e *LogEntry, end int64) bool {
+		domains = append(domains, e.Domain)
+		ends = append(ends, end)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(domains) != 1 || domains[0] != "b.com." || ends[0] != int64(len(l1+l2)) {
+		t.Errorf("scanned %v at %v", domains, ends)
+	}
+	if end != int64(len(l1+l2)) {
+		t.Errorf("end = %d, want %d (before the partial line)", end, len(l1+l2))
+	}
+}
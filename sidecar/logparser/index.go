@@ -0,0 +1,715 @@
+package logparser
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Search indexes map each domain and client seen in a day's log to the byte
+// offsets of its lines, so domain and client searches can seek straight to
+// matching lines instead of scanning every file.
+//
+// An index file ("2006-01-02.idx") starts with indexMagic followed by
+// segments, each covering a contiguous byte range of the log. New log data is
+// indexed by appending a segment, so today's index grows with the log:
+//
+//	segment := uvarint(len(payload)) payload crc32(payload)
+//	payload := uvarint(from) uvarint(to) postings(domains) postings(clients)
+//	postings := uvarint(n) { uvarint(len(key)) key [uvarint(len(name)) name] uvarint(count) {uvarint(delta)} }
+//
+// Client postings carry the client names seen for the IP after the key,
+// joined by tabs, which cannot occur in a log field.
+//
+// Substring and glob domain searches are narrowed with trigram postings
+// over the day's distinct domains, which are derived when an index is
+// loaded rather than stored. Regexp searches test every distinct domain;
+// none of them scan the log lines.
+
+const (
+	indexMagic       = "BVIDX\x01"
+	maxIndexSegments = 64 // rewrite as a single segment beyond this
+	// maxCachedDays bounds the day indexes kept in memory; evicted days
+	// are loaded from their index file again when searched.
+	maxCachedDays = 8
+)
+
+type clientPostings struct {
+	names   []string // every name logged for the IP, so renamed clients still match
+	offsets []uint32
+}
+
+func (c *clientPostings) addNames(names ...string) {
+	for _, name := range names {
+		if name != "" && !slices.Contains(c.names, name) {
+			c.names = append(c.names, name)
+		}
+	}
+}
+
+// dayIndex is the in-memory form of one log file's index.
+type dayIndex struct {
+	covered  int64 // log bytes indexed
+	segments int
+	domains  map[string][]uint32
+	clients  map[string]*clientPostings
+	grams    map[string][]string // trigram -> distinct domains containing it
+
+	path string        // log file, while cached by an Indexer
+	elem *list.Element // position in Indexer.lru
+}
+
+func newDayIndex() *dayIndex {
+	return &dayIndex{
+		domains: make(map[string][]uint32),
+		clients: make(map[string]*clientPostings),
+		grams:   make(map[string][]string),
+	}
+}
+
+// Indexer maintains per-day search indexes for the log files in logDir,
+// stored in dir. Indexes are built lazily when searched and can be built
+// ahead of time with Build. At most maxCachedDays indexes are kept in
+// memory.
+type Indexer struct {
+	logDir string
+	dir    string // empty disables indexing
+
+	mu   sync.Mutex
+	days map[string]*dayIndex // by log file path
+	lru  *list.List           // front = most recently used
+}
+
+// NewIndexer creates an indexer storing index files in dir. If dir is empty,
+// searches fall back to scanning the log files.
+func NewIndexer(logDir, dir string) *Indexer {
+	return &Indexer{logDir: logDir, dir: dir, days: make(map[string]*dayIndex), lru: list.New()}
+}
+
+// cache records idx as the most recently used index of logPath and evicts
+// the least recently used ones beyond maxCachedDays. Caller must hold ix.mu.
+func (ix *Indexer) cache(logPath string, idx *dayIndex) {
+	if old, ok := ix.days[logPath]; ok && old != idx {
+		ix.forget(logPath)
+	}
+	if idx.elem != nil {
+		ix.lru.MoveToFront(idx.elem)
+		return
+	}
+	idx.path = logPath
+	idx.elem = ix.lru.PushFront(idx)
+	ix.days[logPath] = idx
+	for ix.lru.Len() > maxCachedDays {
+		ix.forget(ix.lru.Back().Value.(*dayIndex).path)
+	}
+}
+
+// forget drops the cached index of logPath. Caller must hold ix.mu.
+func (ix *Indexer) forget(logPath string) {
+	if idx, ok := ix.days[logPath]; ok {
+		ix.lru.Remove(idx.elem)
+		idx.elem = nil
+		delete(ix.days, logPath)
+	}
+}
+
+func (ix *Indexer) indexPath(logPath string) string {
+	return filepath.Join(ix.dir, logFileDay(logPath)+".idx")
+}
+
+// Build brings the indexes for the last days days up to date and removes
+// index files whose log file no longer exists.
+func (ix *Indexer) Build(days int) {
+	if ix.dir == "" {
+		return
+	}
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	for _, path := range LogFilesForRange(ix.logDir, today.AddDate(0, 0, -(days-1)), now) {
+		ix.mu.Lock()
+		_, err := ix.update(path)
+		ix.mu.Unlock()
+		if err != nil {
+			log.Printf("search index: %v", err)
+		}
+	}
+
+	idxFiles, _ := filepath.Glob(filepath.Join(ix.dir, "*.idx"))
+	for _, p := range idxFiles {
+		day := strings.TrimSuffix(filepath.Base(p), ".idx")
+		logPath := filepath.Join(ix.logDir, day+"_ALL.log")
+		if _, err := os.Stat(logPath); errors.Is(err, os.ErrNotExist) {
+			os.Remove(p)
+			ix.mu.Lock()
+			ix.forget(logPath)
+			ix.mu.Unlock()
+		}
+	}
+}
+
+// update returns the index for a log file after indexing any data appended
+// since the last update. A log that shrank is re-indexed from scratch.
+// Caller must hold ix.mu.
+func (ix *Indexer) update(logPath string) (*dayIndex, error) {
+	info, err := os.Stat(logPath)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > math.MaxUint32 {
+		return nil, fmt.Errorf("%s is too large to index", filepath.Base(logPath))
+	}
+
+	idx, ok := ix.days[logPath]
+	if !ok {
+		if idx, err = loadDayIndex(ix.indexPath(logPath)); err != nil {
+			idx = nil
+		}
+	}
+	if idx == nil || info.Size() < idx.covered {
+		idx = newDayIndex()
+		if err := os.MkdirAll(ix.dir, 0755); err != nil {
+			return nil, fmt.Errorf("create index dir: %w", err)
+		}
+		if err := os.WriteFile(ix.indexPath(logPath), []byte(indexMagic), 0644); err != nil {
+			return nil, fmt.Errorf("create index: %w", err)
+		}
+	}
+	ix.cache(logPath, idx)
+
+	if info.Size() == idx.covered {
+		return idx, nil
+	}
+	seg, err := indexLogRange(logPath, idx.covered, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	if seg.covered == idx.covered {
+		return idx, nil // only a partial line was appended
+	}
+	from := idx.covered
+	idx.merge(seg)
+
+	if idx.segments > maxIndexSegments {
+		err = writeIndexFile(ix.indexPath(logPath), idx)
+	} else {
+		err = appendIndexSegment(ix.indexPath(logPath), from, seg)
+	}
+	if err != nil {
+		// Keep serving from memory; the file is rebuilt on next load.
+		log.Printf("search index: write %s: %v", ix.indexPath(logPath), err)
+		os.Remove(ix.indexPath(logPath))
+	}
+	return idx, nil
+}
+
+// candidates updates the index for a log file and returns the offsets of
+// lines that may match root, or all=true if every line must be scanned.
+// Client IPs are resolved with ix.mu released, as a lookup can block on
+// DNS; IPs logged meanwhile are resolved in another round.
+func (ix *Indexer) candidates(logPath string, root queryNode, resolve func(string) string) ([]uint32, bool) {
+	names := make(map[string]string)
+	ix.mu.Lock()
+	for {
+		idx, err := ix.update(logPath)
+		if err != nil {
+			ix.mu.Unlock()
+			return nil, true
+		}
+		var missing []string
+		if resolve != nil && root != nil && root.usesClient() {
+			for ip := range idx.clients {
+				if _, ok := names[ip]; !ok {
+					missing = append(missing, ip)
+				}
+			}
+		}
+		if len(missing) == 0 {
+			offsets, all := idx.candidates(root, func(ip string) string { return names[ip] })
+			ix.mu.Unlock()
+			return offsets, all
+		}
+		ix.mu.Unlock()
+		for _, ip := range missing {
+			names[ip] = resolve(ip)
+		}
+		ix.mu.Lock()
+	}
+}
+
+// indexLogRange indexes the complete lines in [from, to) of a log file. The
+// returned index's covered field is the end of the last complete line.
+func indexLogRange(logPath string, from, to int64) (*dayIndex, error) {
+	f, err := os.Open(logPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	seg := newDayIndex()
+	seg.covered = from
+	p := NewRecordParser()
+	br := bufio.NewReaderSize(io.NewSectionReader(f, from, to-from), 64*1024)
+	offset := from
+	for {
+		line, err := br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// Overlong line: skip to its end without indexing it.
+			for err == bufio.ErrBufferFull {
+				offset += int64(len(line))
+				line, err = br.ReadSlice('\n')
+			}
+			offset += int64(len(line))
+			if err == nil {
+				seg.covered = offset
+			}
+			continue
+		}
+		if err != nil {
+			break // EOF: a trailing partial line is left for the next update
+		}
+		if rec, perr := p.Parse(bytes.TrimSuffix(line, []byte{'\n'})); perr == nil {
+			seg.add(rec, uint32(offset))
+		}
+		offset += int64(len(line))
+		seg.covered = offset
+	}
+	seg.segments = 1
+	return seg, nil
+}
+
+func (d *dayIndex) add(r Record, offset uint32) {
+	domain := strings.ToLower(r.Domain)
+	d.domains[domain] = append(d.domains[domain], offset)
+	c, ok := d.clients[r.ClientIP]
+	if !ok {
+		c = &clientPostings{}
+		d.clients[r.ClientIP] = c
+	}
+	c.addNames(r.ClientName)
+	c.offsets = append(c.offsets, offset)
+}
+
+// merge appends a segment covering the bytes directly after d.
+func (d *dayIndex) merge(seg *dayIndex) {
+	for k, v := range seg.domains {
+		if _, ok := d.domains[k]; !ok {
+			d.addGrams(k)
+		}
+		d.domains[k] = append(d.domains[k], v...)
+	}
+	for k, v := range seg.clients {
+		if c, ok := d.clients[k]; ok {
+			c.addNames(v.names...)
+			c.offsets = append(c.offsets, v.offsets...)
+		} else {
+			d.clients[k] = v
+		}
+	}
+	d.covered = seg.covered
+	d.segments += seg.segments
+}
+
+func encodeSegment(from int64, seg *dayIndex) []byte {
+	var buf []byte
+	buf = binary.AppendUvarint(buf, uint64(from))
+	buf = binary.AppendUvarint(buf, uint64(seg.covered))
+	appendPostings := func(key, name string, hasName bool, offsets []uint32) {
+		buf = binary.AppendUvarint(buf, uint64(len(key)))
+		buf = append(buf, key...)
+		if hasName {
+			buf = binary.AppendUvarint(buf, uint64(len(name)))
+			buf = append(buf, name...)
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(offsets)))
+		prev := uint32(0)
+		for _, o := range offsets {
+			buf = binary.AppendUvarint(buf, uint64(o-prev))
+			prev = o
+		}
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(seg.domains)))
+	for k, v := range seg.domains {
+		appendPostings(k, "", false, v)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(seg.clients)))
+	for k, v := range seg.clients {
+		appendPostings(k, strings.Join(v.names, "\t"), true, v.offsets)
+	}
+
+	out := binary.AppendUvarint(nil, uint64(len(buf)))
+	out = append(out, buf...)
+	return binary.LittleEndian.AppendUint32(out, crc32.ChecksumIEEE(buf))
+}
+
+func appendIndexSegment(path string, from int64, seg *dayIndex) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(encodeSegment(from, seg)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeIndexFile rewrites an index as a single segment via a temp file.
+func writeIndexFile(path string, idx *dayIndex) error {
+	data := append([]byte(indexMagic), encodeSegment(0, idx)...)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	idx.segments = 1
+	return nil
+}
+
+var errCorruptIndex = errors.New("corrupt index")
+
+// loadDayIndex reads an index file. A torn trailing segment (e.g. from a
+// crash mid-append) is dropped and the file truncated to the last good one.
+func loadDayIndex(path string) (*dayIndex, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte(indexMagic)) {
+		return nil, errCorruptIndex
+	}
+	idx := newDayIndex()
+	pos := len(indexMagic)
+	for pos < len(data) {
+		seg, n, err := decodeSegment(data[pos:])
+		if err != nil || seg.from != idx.covered {
+			os.Truncate(path, int64(pos))
+			break
+		}
+		idx.merge(seg.dayIndex)
+		pos += n
+	}
+	return idx, nil
+}
+
+type decodedSegment struct {
+	*dayIndex
+	from int64
+}
+
+func decodeSegment(data []byte) (decodedSegment, int, error) {
+	size, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < size+4 {
+		return decodedSegment{}, 0, errCorruptIndex
+	}
+	payload := data[n : n+int(size)]
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(data[n+int(size):]) {
+		return decodedSegment{}, 0, errCorruptIndex
+	}
+
+	r := &uvarintReader{buf: payload}
+	seg := decodedSegment{dayIndex: newDayIndex()}
+	seg.from = int64(r.uvarint())
+	seg.covered = int64(r.uvarint())
+	seg.segments = 1
+	readPostings := func() []uint32 {
+		count := r.uvarint()
+		if count > uint64(len(r.buf)) {
+			r.err = errCorruptIndex
+			return nil
+		}
+		offsets := make([]uint32, count)
+		prev := uint32(0)
+		for i := range offsets {
+			prev += uint32(r.uvarint())
+			offsets[i] = prev
+		}
+		return offsets
+	}
+	for range r.uvarint() {
+		key := r.string()
+		seg.domains[key] = readPostings()
+		if r.err != nil {
+			break
+		}
+	}
+	for range r.uvarint() {
+		ip := r.string()
+		c := &clientPostings{}
+		c.addNames(strings.Split(r.string(), "\t")...)
+		c.offsets = readPostings()
+		seg.clients[ip] = c
+		if r.err != nil {
+			break
+		}
+	}
+	if r.err != nil {
+		return decodedSegment{}, 0, r.err
+	}
+	return seg, n + int(size) + 4, nil
+}
+
+type uvarintReader struct {
+	buf []byte
+	err error
+}
+
+func (r *uvarintReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.err = errCorruptIndex
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *uvarintReader) string() string {
+	n := r.uvarint()
+	if r.err != nil || n > uint64(len(r.buf)) {
+		r.err = errCorruptIndex
+		return ""
+	}
+	s := string(r.buf[:n])
+	r.buf = r.buf[n:]
+	return s
+}
+
+// candidates returns the sorted line offsets that may match n, or all=true
+// if the index cannot narrow the query.
+func (d *dayIndex) candidates(n queryNode, resolve func(ip string) string) (offsets []uint32, all bool) {
+	switch n := n.(type) {
+	case nil:
+		return nil, true
+	case andNode:
+		l, lAll := d.candidates(n.l, resolve)
+		r, rAll := d.candidates(n.r, resolve)
+		switch {
+		case lAll:
+			return r, rAll
+		case rAll:
+			return l, false
+		}
+		return intersectOffsets(l, r), false
+	case orNode:
+		l, lAll := d.candidates(n.l, resolve)
+		if lAll {
+			return nil, true
+		}
+		r, rAll := d.candidates(n.r, resolve)
+		if rAll {
+			return nil, true
+		}
+		return unionOffsets(l, r), false
+	case fieldNode:
+		switch n.field {
+		case fieldDomain:
+			return d.domainOffsets(n), false
+		case fieldClient:
+			return d.clientOffsets(n.m, resolve), false
+		case fieldAny:
+			return unionOffsets(d.domainOffsets(n), d.clientOffsets(n.m, resolve)), false
+		}
+	}
+	return nil, true
+}
+
+func (d *dayIndex) domainOffsets(n fieldNode) []uint32 {
+	var out []uint32
+	test := func(domain string) {
+		if n.m(strings.TrimSuffix(domain, ".")) {
+			out = append(out, d.domains[domain]...)
+		}
+	}
+	if domains, ok := d.gramCandidates(n.lits); ok {
+		for _, domain := range domains {
+			test(domain)
+		}
+	} else {
+		for domain := range d.domains {
+			test(domain)
+		}
+	}
+	slices.Sort(out) // each line has one domain, so lists are disjoint
+	return out
+}
+
+// addGrams adds a new distinct domain to the trigram postings.
+func (d *dayIndex) addGrams(domain string) {
+	name := strings.TrimSuffix(domain, ".")
+	var seen []string
+	for i := 0; i+3 <= len(name); i++ {
+		g := name[i : i+3]
+		if slices.Contains(seen, g) {
+			continue
+		}
+		seen = append(seen, g)
+		d.grams[g] = append(d.grams[g], domain)
+	}
+}
+
+// gramCandidates returns the distinct domains that contain the rarest
+// trigram of the literals a match requires, or ok=false if no literal is
+// long enough to narrow the search.
+func (d *dayIndex) gramCandidates(lits []string) (domains []string, ok bool) {
+	for _, lit := range lits {
+		for i := 0; i+3 <= len(lit); i++ {
+			g := d.grams[lit[i:i+3]]
+			if !ok || len(g) < len(domains) {
+				domains, ok = g, true
+			}
+		}
+	}
+	return domains, ok
+}
+
+func (d *dayIndex) clientOffsets(m textMatcher, resolve func(string) string) []uint32 {
+	var out []uint32
+	for ip, c := range d.clients {
+		if m(ip) || slices.ContainsFunc(c.names, m) || (resolve != nil && matchResolved(m, resolve(ip))) {
+			out = append(out, c.offsets...)
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+func matchResolved(m textMatcher, name string) bool {
+	return name != "" && m(name)
+}
+
+func intersectOffsets(a, b []uint32) []uint32 {
+	var out []uint32
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+func unionOffsets(a, b []uint32) []uint32 {
+	out := make([]uint32, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			out = append(out, a[i])
+			i++
+		case a[i] > b[j]:
+			out = append(out, b[j])
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	out = append(out, a[i:]...)
+	return append(out, b[j:]...)
+}
+
+// ScanReverse behaves like the package-level ScanReverse, but for files with
+// an index it only visits lines that can match q. Callers must still apply
+// q.Match to the entries they receive. resolve maps client IPs to resolved
+// names for client predicates and may be nil.
+func (ix *Indexer) ScanReverse(start, end time.Time, from *Cursor, q *Query, resolve func(ip string) string, fn func(e *LogEntry, next Cursor) bool) error {
+	var root queryNode
+	if q != nil {
+		root = q.root
+	}
+	if ix.dir == "" || root == nil {
+		return ScanReverse(ix.logDir, start, end, from, fn)
+	}
+	if from != nil {
+		if err := from.check(ix.logDir); err != nil {
+			return err
+		}
+	}
+
+	files := LogFilesForRange(ix.logDir, start, end)
+	for i := len(files) - 1; i >= 0; i-- {
+		day := logFileDay(files[i])
+		limit := int64(-1)
+		if from != nil {
+			if day > from.Day {
+				continue
+			}
+			if day == from.Day {
+				limit = from.Offset
+			}
+		}
+
+		var more bool
+		var err error
+		offsets, all := ix.candidates(files[i], root, resolve)
+		if all {
+			more, err = scanFileReverse(files[i], day, limit, fn)
+		} else {
+			more, err = seekLinesReverse(files[i], day, offsets, limit, fn)
+		}
+		if err != nil {
+			return err
+		}
+		if !more {
+			return nil
+		}
+	}
+	return nil
+}
+
+// seekLinesReverse reads the lines at the given offsets, newest first,
+// skipping offsets at or beyond limit when limit is not negative.
+func seekLinesReverse(path, day string, offsets []uint32, limit int64, fn func(*LogEntry, Cursor) bool) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return true, nil
+	}
+	defer f.Close()
+
+	br := bufio.NewReaderSize(nil, 4096)
+	for i := len(offsets) - 1; i >= 0; i-- {
+		off := int64(offsets[i])
+		if limit >= 0 && off >= limit {
+			continue
+		}
+		br.Reset(io.NewSectionReader(f, off, 1<<20))
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return false, fmt.Errorf("read %s: %w", filepath.Base(path), err)
+		}
+		entry, err := ParseLine(strings.TrimSuffix(line, "\n"))
+		if err != nil {
+			continue
+		}
+		if !fn(entry, Cursor{Day: day, Offset: off, Timestamp: entry.Timestamp}) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
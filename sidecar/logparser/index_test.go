@@ -0,0 +1,283 @@
+package logparser
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func indexTestLines(day string, n int) []string {
+	domains := []string{"www.tiktok.com.", "example.com.", "ads.doubleclick.net.", "api.TikTok.com."}
+	clients := []string{"10.0.0.1\tPC", "10.0.0.2\tPhone", "10.0.0.3\ttv"}
+	lines := make([]string, n)
+	for i := range lines {
+		ts := fmt.Sprintf("%s %02d:%02d:%02d", day, i/3600, i/60%60, i%60)
+		lines[i] = ts + "\t" + clients[i%len(clients)] + "\t1\tRESOLVED\t" + domains[i%len(domains)] + "\tA (1.2.3.4)\tNOERROR\tRESOLVED\tA\tblocky"
+	}
+	return lines
+}
+
+func collectScan(t *testing.T, scan func(fn func(*LogEntry, Cursor) bool) error, q *Query) []string {
+	t.Helper()
+	var out []string
+	err := scan(func(e *LogEntry, c Cursor) bool {
+		if q.Match(e) {
+			out = append(out, fmt.Sprintf("%s@%d", e.Timestamp.Format(time.TimeOnly), c.Offset))
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestIndexerMatchesFullScan(t *testing.T) {
+	logDir := t.TempDir()
+	for _, day := range []string{"2026-02-14", "2026-02-15"} {
+		writeTestLogFile(t, filepath.Join(logDir, day+"_ALL.log"), indexTestLines(day, 500))
+	}
+	ix := NewIndexer(logDir, filepath.Join(logDir, ".idx"))
+	start := time.Date(2026, 2, 14, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2026, 2, 15, 23, 0, 0, 0, time.UTC)
+	resolve := func(ip string) string {
+		if ip == "10.0.0.2" {
+			return "phone.fritz.box"
+		}
+		return ""
+	}
+
+	for _, src := range []string{
+		"tiktok",
+		"domain:*.tiktok.com client:tv",
+		"client:fritz",
+		"domain:example OR client:10.0.0.3",
+		"domain:tiktok -client:pc",
+		"qtype:A domain:doubleclick",
+		"domain:tok.co",
+		"domain:API.*.com OR domain:ex?mple",
+	} {
+		q, err := ParseQuery(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := collectScan(t, func(fn func(*LogEntry, Cursor) bool) error {
+			return ScanReverse(logDir, start, end, nil, func(e *LogEntry, c Cursor) bool {
+				e.ResolvedName = resolve(e.ClientIP)
+				return fn(e, c)
+			})
+		}, q)
+		got := collectScan(t, func(fn func(*LogEntry, Cursor) bool) error {
+			return ix.ScanReverse(start, end, nil, q, resolve, func(e *LogEntry, c Cursor) bool {
+				e.ResolvedName = resolve(e.ClientIP)
+				return fn(e, c)
+			})
+		}, q)
+		if len(want) == 0 || fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%q: indexed scan returned %d entries, full scan %d", src, len(got), len(want))
+		}
+	}
+
+	for _, day := range []string{"2026-02-14", "2026-02-15"} {
+		if _, err := os.Stat(filepath.Join(logDir, ".idx", day+".idx")); err != nil {
+			t.Errorf("expected index file for %s: %v", day, err)
+		}
+	}
+}
+
+func TestIndexerClientNames(t *testing.T) {
+	logDir := t.TempDir()
+	logPath := filepath.Join(logDir, "2026-02-14_ALL.log")
+	line := "2026-02-14 10:00:0%d\t10.0.0.1\t%s\t1\tRESOLVED\ta.com.\tA (1.2.3.4)\tNOERROR\tRESOLVED\tA\tblocky"
+	writeTestLogFile(t, logPath, []string{fmt.Sprintf(line, 0, "PC")})
+	day := time.Date(2026, 2, 14, 0, 0, 0, 0, time.UTC)
+
+	var ix *Indexer
+	resolve := func(string) string {
+		if !ix.mu.TryLock() {
+			t.Error("resolve called with the index lock held")
+			return ""
+		}
+		ix.mu.Unlock()
+		return ""
+	}
+	count := func(src string) int {
+		q, err := ParseQuery(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(collectScan(t, func(fn func(*LogEntry, Cursor) bool) error {
+			return ix.ScanReverse(day, day, nil, q, resolve, fn)
+		}, q))
+	}
+
+	ix = NewIndexer(logDir, filepath.Join(logDir, ".idx"))
+	count("client:pc")
+	// The client is renamed; lines under both names must stay findable,
+	// also from the index file.
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(f, fmt.Sprintf(line, 1, "Laptop"))
+	f.Close()
+	for _, fresh := range []bool{false, true} {
+		if fresh {
+			ix = NewIndexer(logDir, filepath.Join(logDir, ".idx"))
+		}
+		if n := count("client:pc"); n != 1 {
+			t.Errorf("client:pc (reloaded %v) = %d entries, want 1", fresh, n)
+		}
+		if n := count("client:laptop"); n != 1 {
+			t.Errorf("client:laptop (reloaded %v) = %d entries, want 1", fresh, n)
+		}
+	}
+}
+
+func TestIndexerIncrementalAndReload(t *testing.T) {
+	logDir := t.TempDir()
+	idxDir := filepath.Join(logDir, ".idx")
+	logPath := filepath.Join(logDir, "2026-02-14_ALL.log")
+	lines := indexTestLines("2026-02-14", 100)
+	writeTestLogFile(t, logPath, lines[:40])
+
+	ix := NewIndexer(logDir, idxDir)
+	ix.mu.Lock()
+	if _, err := ix.update(logPath); err != nil {
+		t.Fatal(err)
+	}
+	ix.mu.Unlock()
+
+	// Append the rest plus a partial line that must not be indexed yet.
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range lines[40:] {
+		fmt.Fprintln(f, l)
+	}
+	fmt.Fprint(f, "2026-02-14 23:59:59\t10.0.0.9")
+	f.Close()
+
+	ix.mu.Lock()
+	idx, err := ix.update(logPath)
+	ix.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.segments != 2 {
+		t.Errorf("segments = %d, want 2", idx.segments)
+	}
+	info, _ := os.Stat(logPath)
+	if idx.covered >= info.Size() {
+		t.Errorf("covered = %d includes the partial trailing line (size %d)", idx.covered, info.Size())
+	}
+
+	// A fresh indexer must load the same state from disk.
+	loaded, err := loadDayIndex(filepath.Join(idxDir, "2026-02-14.idx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.covered != idx.covered || len(loaded.domains) != len(idx.domains) || len(loaded.clients) != len(idx.clients) {
+		t.Errorf("loaded index covered=%d domains=%d clients=%d, want %d/%d/%d",
+			loaded.covered, len(loaded.domains), len(loaded.clients), idx.covered, len(idx.domains), len(idx.clients))
+	}
+	total := 0
+	for _, offs := range loaded.domains {
+		total += len(offs)
+	}
+	if total != len(lines) {
+		t.Errorf("indexed %d lines, want %d", total, len(lines))
+	}
+}
+
+func TestIndexerTornSegmentAndShrink(t *testing.T) {
+	logDir := t.TempDir()
+	idxDir := filepath.Join(logDir, ".idx")
+	logPath := filepath.Join(logDir, "2026-02-14_ALL.log")
+	writeTestLogFile(t, logPath, indexTestLines("2026-02-14", 50))
+
+	ix := NewIndexer(logDir, idxDir)
+	ix.mu.Lock()
+	ix.update(logPath)
+	ix.mu.Unlock()
+
+	// Simulate a crash mid-append.
+	idxPath := filepath.Join(idxDir, "2026-02-14.idx")
+	f, _ := os.OpenFile(idxPath, os.O_APPEND|os.O_WRONLY, 0644)
+	f.Write([]byte{0x80, 0x01, 0x02})
+	f.Close()
+	loaded, err := loadDayIndex(idxPath)
+	if err != nil || loaded.segments != 1 {
+		t.Fatalf("loadDayIndex = %v segments, err %v; want torn segment dropped", loaded, err)
+	}
+
+	// Log replaced by a shorter one: index is rebuilt.
+	writeTestLogFile(t, logPath, indexTestLines("2026-02-14", 10))
+	ix.mu.Lock()
+	idx, err := ix.update(logPath)
+	ix.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Stat(logPath)
+	if idx.covered != info.Size() {
+		t.Errorf("covered = %d after shrink, want %d", idx.covered, info.Size())
+	}
+}
+
+func TestIndexerEvictsAndReloads(t *testing.T) {
+	logDir := t.TempDir()
+	ix := NewIndexer(logDir, filepath.Join(logDir, ".idx"))
+	var paths []string
+	for d := 1; d <= maxCachedDays+2; d++ {
+		path := filepath.Join(logDir, fmt.Sprintf("2026-03-%02d_ALL.log", d))
+		writeTestLogFile(t, path, indexTestLines(fmt.Sprintf("2026-03-%02d", d), 20))
+		paths = append(paths, path)
+		ix.mu.Lock()
+		if _, err := ix.update(path); err != nil {
+			t.Fatal(err)
+		}
+		ix.mu.Unlock()
+	}
+	if len(ix.days) != maxCachedDays || ix.lru.Len() != maxCachedDays {
+		t.Errorf("cached days = %d (lru %d), want %d", len(ix.days), ix.lru.Len(), maxCachedDays)
+	}
+	if _, ok := ix.days[paths[0]]; ok {
+		t.Error("least recently used day still cached")
+	}
+
+	// An evicted day is loaded from its index file again.
+	q, _ := ParseQuery("domain:tiktok")
+	offsets, all := ix.candidates(paths[0], q.root, nil)
+	if all || len(offsets) != 10 {
+		t.Errorf("candidates of evicted day = %d offsets (all=%v), want 10", len(offsets), all)
+	}
+	if _, ok := ix.days[paths[0]]; !ok || len(ix.days) != maxCachedDays {
+		t.Errorf("reloaded day not cached, %d days cached", len(ix.days))
+	}
+}
+
+func TestGramCandidates(t *testing.T) {
+	idx := newDayIndex()
+	seg := newDayIndex()
+	for i, domain := range []string{"www.tiktok.com.", "example.com.", "api.tiktok.com.", "tiktokcdn.net."} {
+		seg.domains[domain] = []uint32{uint32(i)}
+	}
+	idx.merge(seg)
+
+	got, ok := idx.gramCandidates(literals("TikTok.c"))
+	slices.Sort(got)
+	if !ok || fmt.Sprint(got) != "[api.tiktok.com. www.tiktok.com.]" {
+		t.Errorf("gramCandidates(tiktok.c) = %v, %v", got, ok)
+	}
+	if got, ok := idx.gramCandidates(literals("*.nope")); !ok || len(got) != 0 {
+		t.Errorf("gramCandidates(*.nope) = %v, %v, want none", got, ok)
+	}
+	if _, ok := idx.gramCandidates(literals("a*b")); ok {
+		t.Error("gramCandidates narrowed a search without a trigram")
+	}
+}
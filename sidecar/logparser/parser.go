@@ -21,14 +21,30 @@ type LogEntry struct {
 	ResponseCategory string    `json:"response_category"`
 	QueryType        string    `json:"query_type"`
 	Source           string    `json:"source"`
+
+	// Set on collapsed rows only, see Collapser.
+	Count     int        `json:"count,omitempty"`
+	FirstSeen *time.Time `json:"first_seen,omitempty"`
+	LastSeen  *time.Time `json:"last_seen,omitempty"`
+	Group     int        `json:"group,omitempty"`
 }
 
 // ParseLine parses a single TSV log line into a LogEntry.
 // Format: timestamp\tclientIP\tclientName\tduration\tresponseReason\tdomain\tresponseAnswer\treturnCode\tresponseCategory\tqueryType\tsource
 func ParseLine(line string) (*LogEntry, error) {
-	fields := strings.Split(line, "\t")
-	if len(fields) < 11 {
-		return nil, fmt.Errorf("expected 11 tab-separated fields, got %d", len(fields))
+	var fields [11]string
+	n := 0
+	for rest := line; n < len(fields); n++ {
+		field, tail, more := strings.Cut(rest, "\t")
+		fields[n] = field
+		if !more {
+			n++
+			break
+		}
+		rest = tail
+	}
+	if n < 11 {
+		return nil, fmt.Errorf("expected 11 tab-separated fields, got %d", n)
 	}
 
 	ts, err := time.Parse("2006-01-02 15:04:05", fields[0])
@@ -58,11 +74,10 @@ func ParseLine(line string) (*LogEntry, error) {
 
 // IsBlocked returns true if the entry was blocked.
 func (e *LogEntry) IsBlocked() bool {
-	return strings.HasPrefix(strings.ToUpper(e.ResponseReason), "BLOCKED")
+	return isBlockedReason(e.ResponseReason)
 }
 
 // IsCached returns true if the entry was served from cache.
 func (e *LogEntry) IsCached() bool {
-	upper := strings.ToUpper(e.ResponseReason)
-	return upper == "CACHED" || strings.HasPrefix(upper, "CACHED ")
+	return isCachedReason(e.ResponseReason)
 }
@@ -3,6 +3,7 @@ package logparser
 import (
 	"testing"
 	"time"
+	"unsafe"
 )
 
 func TestParseLine(t *testing.T) {
@@ -234,3 +235,103 @@ func TestStatsAggregation(t *testing.T) {
 		t.Errorf("top domain = %q, want %q", stats.TopDomains[0].Domain, "example.com.")
 	}
 }
+
+func TestRecordParserMatchesParseLine(t *testing.T) {
+	lines := []string{
+		"2026-02-14 00:00:37\t10.0.0.101\t10.0.0.101\t0\tCACHED\tbag-cdn.itunes-apple.com.akadns.net.\tCNAME (...), A (151.101.131.6)\tNOERROR\tCACHED\tA\tblocky",
+		"2026-02-14 12:30:00\t10.0.0.50\tdesktop.local\t1\tBLOCKED (ads)\tad.doubleclick.net.\t\tNOERROR\tBLOCKED (ads)\tA\tblocky",
+		"2026-02-14 23:59:59\t10.0.0.50\tdesktop.local\t12.5\tRESOLVED (udp:1.1.1.1)\texample.com.\tA (1.2.3.4)\tNOERROR\tRESOLVED\tAAAA\tblocky\textra",
+		"2026-02-14 08:00:00\t10.0.0.50\tdesktop.local\tx\tcached\texample.com.\tA (1.2.3.4)\tNOERROR\tCACHED\tA\tblocky",
+	}
+
+	p := NewRecordParser()
+	for _, line := range lines {
+		entry, err := ParseLine(line)
+		if err != nil {
+			t.Fatalf("ParseLine(%q) error: %v", line, err)
+		}
+		rec, err := p.Parse([]byte(line))
+		if err != nil {
+			t.Fatalf("Parse(%q) error: %v", line, err)
+		}
+		if rec != entry.Record() {
+			t.Errorf("record mismatch for %q:\n  parser: %+v\n  entry:  %+v", line, rec, entry.Record())
+		}
+	}
+}
+
+func TestRecordParserInvalid(t *testing.T) {
+	p := NewRecordParser()
+	for _, line := range []string{
+		"not enough\tfields",
+		"not-a-date\t1\t2\t3\t4\t5\t6\t7\t8\t9\t10",
+		"2026-02-30 00:00:00\t1\t2\t3\t4\t5\t6\t7\t8\t9\t10",
+		"2026-02-14 24:00:00\t1\t2\t3\t4\t5\t6\t7\t8\t9\t10",
+	} {
+		if _, err := p.Parse([]byte(line)); err == nil {
+			t.Errorf("Parse(%q): expected error", line)
+		}
+	}
+}
+
+func TestRecordParserInternsStrings(t *testing.T) {
+	p := NewRecordParser()
+	line := []byte("2026-02-14 12:30:00\t10.0.0.50\tdesktop.local\t1\tBLOCKED (ads)\tad.doubleclick.net.\t\tNOERROR\tBLOCKED (ads)\tA\tblocky")
+	a, _ := p.Parse(line)
+	b, _ := p.Parse(line)
+	if unsafe.StringData(a.Domain) != unsafe.StringData(b.Domain) {
+		t.Error("expected Domain to be interned")
+	}
+	if unsafe.StringData(a.ResponseCategory) != unsafe.StringData(b.ResponseCategory) {
+		t.Error("expected ResponseCategory to be interned")
+	}
+}
+
+func TestRecordParserZeroAllocs(t *testing.T) {
+	p := NewRecordParser()
+	line := []byte("2026-02-14 12:30:00\t10.0.0.50\tdesktop.local\t1\tBLOCKED (ads)\tad.doubleclick.net.\t\tNOERROR\tBLOCKED (ads)\tA\tblocky")
+	p.Parse(line) // warm the intern table
+	allocs := testing.AllocsPerRun(100, func() {
+		p.Parse(line)
+	})
+	if allocs != 0 {
+		t.Errorf("Parse allocated %.1f times per line, want 0", allocs)
+	}
+}
+
+var benchLines = []string{
+	"2026-02-14 00:00:37\t10.0.0.101\t10.0.0.101\t0\tCACHED\tbag-cdn.itunes-apple.com.akadns.net.\tCNAME (...), A (151.101.131.6)\tNOERROR\tCACHED\tA\tblocky",
+	"2026-02-14 12:30:00\t10.0.0.50\tdesktop.local\t1\tBLOCKED (ads)\tad.doubleclick.net.\t\tNOERROR\tBLOCKED (ads)\tA\tblocky",
+	"2026-02-14 13:45:10\t10.0.0.12\tphone.local\t23\tRESOLVED (tcp+udp:1.1.1.1)\tgoogle.com.\tA (142.250.80.46)\tNOERROR\tRESOLVED\tAAAA\tblocky",
+}
+
+func BenchmarkParseLineAggregate(b *testing.B) {
+	start := time.Date(2026, 2, 14, 0, 0, 0, 0, time.UTC)
+	acc := NewStatsAccumulator(start, start.AddDate(0, 0, 1))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		e, err := ParseLine(benchLines[i%len(benchLines)])
+		if err != nil {
+			b.Fatal(err)
+		}
+		acc.Add(e)
+	}
+}
+
+func BenchmarkRecordParserAggregate(b *testing.B) {
+	lines := make([][]byte, len(benchLines))
+	for i, l := range benchLines {
+		lines[i] = []byte(l)
+	}
+	start := time.Date(2026, 2, 14, 0, 0, 0, 0, time.UTC)
+	acc := NewStatsAccumulator(start, start.AddDate(0, 0, 1))
+	p := NewRecordParser()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r, err := p.Parse(lines[i%len(lines)])
+		if err != nil {
+			b.Fatal(err)
+		}
+		acc.AddRecord(r)
+	}
+}
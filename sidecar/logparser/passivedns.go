@@ -0,0 +1,330 @@
+package logparser
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PassiveDNSRecord is one IP address a domain resolved to, with the clients
+// that received that answer.
+type PassiveDNSRecord struct {
+	IP        string             `json:"ip"`
+	Domain    string             `json:"domain"`
+	FirstSeen time.Time          `json:"first_seen"`
+	LastSeen  time.Time          `json:"last_seen"`
+	Count     int                `json:"count"`
+	Clients   []PassiveDNSClient `json:"clients"`
+}
+
+type PassiveDNSClient struct {
+	IP           string    `json:"ip"`
+	Name         string    `json:"name"`
+	ResolvedName string    `json:"resolved_name,omitempty"`
+	Count        int       `json:"count"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+type pdnsKey struct {
+	addr   netip.Addr
+	domain string
+}
+
+type pdnsPair struct {
+	first, last time.Time
+	count       int
+	clients     map[string]*pdnsClient // by client IP
+}
+
+type pdnsClient struct {
+	name  string
+	count int
+	last  time.Time
+}
+
+type pdnsFile struct {
+	offset int64 // bytes of complete lines consumed
+	pairs  map[pdnsKey]*pdnsPair
+}
+
+// PassiveDNS maps answer IP addresses to the domains that resolved to them,
+// built from the ResponseAnswer field of every log file in logDir. Files are
+// read once and extended incrementally as they grow.
+type PassiveDNS struct {
+	logDir string
+
+	refreshMu sync.Mutex // serializes Refresh
+
+	mu    sync.Mutex
+	files map[string]*pdnsFile
+}
+
+func NewPassiveDNS(logDir string) *PassiveDNS {
+	return &PassiveDNS{logDir: logDir, files: make(map[string]*pdnsFile)}
+}
+
+// Refresh reads any new log data and forgets files that were deleted.
+// Files are read without blocking lookups; one that cannot be read is
+// logged and keeps what was indexed from it before.
+func (p *PassiveDNS) Refresh() error {
+	paths, err := filepath.Glob(filepath.Join(p.logDir, "*_ALL.log"))
+	if err != nil {
+		return err
+	}
+	p.refreshMu.Lock()
+	defer p.refreshMu.Unlock()
+
+	p.mu.Lock()
+	old := make(map[string]*pdnsFile, len(p.files))
+	for path, pf := range p.files {
+		old[path] = pf
+	}
+	p.mu.Unlock()
+
+	files := make(map[string]*pdnsFile, len(paths))
+	grown := make(map[*pdnsFile]*pdnsFile) // file -> lines appended to it
+	for _, path := range paths {
+		pf, delta, err := readPassiveDNS(path, old[path])
+		if err != nil {
+			log.Printf("passive DNS: %v", err)
+			pf = old[path]
+		}
+		if pf == nil {
+			continue
+		}
+		files[path] = pf
+		if delta != nil {
+			grown[pf] = delta
+		}
+	}
+
+	p.mu.Lock()
+	for pf, delta := range grown {
+		pf.merge(delta)
+	}
+	p.files = files
+	p.mu.Unlock()
+	return nil
+}
+
+// readPassiveDNS reads the complete lines appended to a file since pf was
+// built. It returns pf and the new lines as a separate file for the caller
+// to merge, or a new file if pf is nil or the file shrank. Neither is
+// returned if the file was deleted since listing.
+func readPassiveDNS(path string, pf *pdnsFile) (*pdnsFile, *pdnsFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, nil
+	}
+	if pf != nil && info.Size() == pf.offset {
+		return pf, nil, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	if pf == nil || info.Size() < pf.offset {
+		pf = &pdnsFile{pairs: make(map[pdnsKey]*pdnsPair)}
+		if err := pf.read(f, info.Size()); err != nil {
+			return nil, nil, err
+		}
+		return pf, nil, nil
+	}
+	delta := &pdnsFile{offset: pf.offset, pairs: make(map[pdnsKey]*pdnsPair)}
+	if err := delta.read(f, info.Size()); err != nil {
+		return nil, nil, fmt.Errorf("read %s: %w", filepath.Base(path), err)
+	}
+	return pf, delta, nil
+}
+
+// read adds the complete lines between pf.offset and size, leaving a
+// partial last line for the next read.
+func (pf *pdnsFile) read(f *os.File, size int64) error {
+	br := bufio.NewReaderSize(io.NewSectionReader(f, pf.offset, size-pf.offset), 64*1024)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		pf.offset += int64(len(line))
+		if e, err := ParseLine(strings.TrimRight(line, "\r\n")); err == nil {
+			pf.add(e)
+		}
+	}
+}
+
+// merge adds the pairs read after pf's end. Caller must hold PassiveDNS.mu.
+func (pf *pdnsFile) merge(delta *pdnsFile) {
+	pf.offset = delta.offset
+	for key, dp := range delta.pairs {
+		pair, ok := pf.pairs[key]
+		if !ok {
+			pf.pairs[key] = dp
+			continue
+		}
+		pair.count += dp.count
+		pair.last = dp.last
+		for ip, dc := range dp.clients {
+			c, ok := pair.clients[ip]
+			if !ok {
+				pair.clients[ip] = dc
+				continue
+			}
+			c.name = dc.name
+			c.count += dc.count
+			c.last = dc.last
+		}
+	}
+}
+
+func (pf *pdnsFile) add(e *LogEntry) {
+	if e.IsBlocked() {
+		return
+	}
+	domain := normalizeDomain(e.Domain)
+	answerAddrs(e.ResponseAnswer, func(addr netip.Addr) {
+		key := pdnsKey{addr, domain}
+		pair, ok := pf.pairs[key]
+		if !ok {
+			pair = &pdnsPair{first: e.Timestamp, clients: make(map[string]*pdnsClient)}
+			pf.pairs[key] = pair
+		}
+		pair.count++
+		pair.last = e.Timestamp
+
+		c, ok := pair.clients[e.ClientIP]
+		if !ok {
+			c = &pdnsClient{}
+			pair.clients[e.ClientIP] = c
+		}
+		c.name = e.ClientName
+		c.count++
+		c.last = e.Timestamp
+	})
+}
+
+// answerAddrs calls fn for each IP address in a Blocky answer string such as
+// "CNAME (a.example.com.), A (1.2.3.4), A (5.6.7.8)". Unspecified addresses
+// from blocking responses are skipped.
+func answerAddrs(answer string, fn func(netip.Addr)) {
+	for {
+		i := strings.IndexByte(answer, '(')
+		if i < 0 {
+			return
+		}
+		answer = answer[i+1:]
+		j := strings.IndexByte(answer, ')')
+		if j < 0 {
+			return
+		}
+		if addr, err := netip.ParseAddr(answer[:j]); err == nil && !addr.IsUnspecified() {
+			fn(addr.Unmap())
+		}
+		answer = answer[j+1:]
+	}
+}
+
+func normalizeDomain(d string) string {
+	d = strings.ToLower(d)
+	if d != "" && !strings.HasSuffix(d, ".") {
+		d += "."
+	}
+	return d
+}
+
+// LookupIP returns the domains that resolved to an address in prefix, most
+// recently seen first.
+func (p *PassiveDNS) LookupIP(prefix netip.Prefix) []PassiveDNSRecord {
+	prefix = prefix.Masked()
+	return p.lookup(func(k pdnsKey) bool { return prefix.Contains(k.addr) })
+}
+
+// LookupDomain returns the addresses a domain resolved to, most recently
+// seen first. The match is exact and case-insensitive.
+func (p *PassiveDNS) LookupDomain(domain string) []PassiveDNSRecord {
+	domain = normalizeDomain(domain)
+	return p.lookup(func(k pdnsKey) bool { return k.domain == domain })
+}
+
+func (p *PassiveDNS) lookup(match func(pdnsKey) bool) []PassiveDNSRecord {
+	p.mu.Lock()
+	merged := make(map[pdnsKey]*pdnsPair)
+	for _, pf := range p.files {
+		for key, pair := range pf.pairs {
+			if !match(key) {
+				continue
+			}
+			m, ok := merged[key]
+			if !ok {
+				m = &pdnsPair{first: pair.first, clients: make(map[string]*pdnsClient)}
+				merged[key] = m
+			}
+			if pair.first.Before(m.first) {
+				m.first = pair.first
+			}
+			if pair.last.After(m.last) {
+				m.last = pair.last
+			}
+			m.count += pair.count
+			for ip, c := range pair.clients {
+				mc, ok := m.clients[ip]
+				if !ok {
+					mc = &pdnsClient{}
+					m.clients[ip] = mc
+				}
+				if c.last.After(mc.last) {
+					mc.last = c.last
+					mc.name = c.name
+				}
+				mc.count += c.count
+			}
+		}
+	}
+	p.mu.Unlock()
+
+	records := make([]PassiveDNSRecord, 0, len(merged))
+	for key, pair := range merged {
+		rec := PassiveDNSRecord{
+			IP:        key.addr.String(),
+			Domain:    key.domain,
+			FirstSeen: pair.first,
+			LastSeen:  pair.last,
+			Count:     pair.count,
+			Clients:   make([]PassiveDNSClient, 0, len(pair.clients)),
+		}
+		for ip, c := range pair.clients {
+			rec.Clients = append(rec.Clients, PassiveDNSClient{IP: ip, Name: c.name, Count: c.count, LastSeen: c.last})
+		}
+		sort.Slice(rec.Clients, func(i, j int) bool {
+			if rec.Clients[i].Count != rec.Clients[j].Count {
+				return rec.Clients[i].Count > rec.Clients[j].Count
+			}
+			return rec.Clients[i].IP < rec.Clients[j].IP
+		})
+		records = append(records, rec)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].LastSeen.Equal(records[j].LastSeen) {
+			return records[i].LastSeen.After(records[j].LastSeen)
+		}
+		if records[i].Domain != records[j].Domain {
+			return records[i].Domain < records[j].Domain
+		}
+		return records[i].IP < records[j].IP
+	})
+	return records
+}
@@ -0,0 +1,96 @@
+package logparser
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAnswerAddrs(t *testing.T) {
+	var got []string
+	answerAddrs("CNAME (edge.example.net.), A (1.2.3.4), AAAA (2001:db8::1), A (0.0.0.0)", func(a netip.Addr) {
+		got = append(got, a.String())
+	})
+	want := []string{"1.2.3.4", "2001:db8::1"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("answerAddrs = %v, want %v", got, want)
+	}
+}
+
+func TestPassiveDNSLookup(t *testing.T) {
+	dir := t.TempDir()
+	writeTestLogFile(t, filepath.Join(dir, "2026-02-13_ALL.log"), []string{
+		"2026-02-13 08:00:00\t10.0.0.1\tPC\t5\tRESOLVED\texample.com.\tA (1.2.3.4)\tNOERROR\tRESOLVED\tA\tblocky",
+		"2026-02-13 09:00:00\t10.0.0.2\tPhone\t0\tBLOCKED (ads)\tads.example.com.\tA (0.0.0.0)\tNOERROR\tBLOCKED (ads)\tA\tblocky",
+	})
+	today := filepath.Join(dir, "2026-02-14_ALL.log")
+	writeTestLogFile(t, today, []string{
+		"2026-02-14 10:00:00\t10.0.0.2\tPhone\t5\tRESOLVED\tWWW.Example.com.\tCNAME (example.com.), A (1.2.3.4)\tNOERROR\tRESOLVED\tA\tblocky",
+		"2026-02-14 11:00:00\t10.0.0.1\tPC\t0\tCACHED\texample.com.\tA (1.2.3.4)\tNOERROR\tCACHED\tA\tblocky",
+		"2026-02-14 12:00:00\t10.0.0.1\tPC\t5\tRESOLVED\tother.org.\tA (1.2.9.9)\tNOERROR\tRESOLVED\tA\tblocky",
+	})
+
+	p := NewPassiveDNS(dir)
+	if err := p.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	recs := p.LookupIP(netip.MustParsePrefix("1.2.3.4/32"))
+	if len(recs) != 2 {
+		t.Fatalf("LookupIP(1.2.3.4) = %d records, want 2", len(recs))
+	}
+	// Most recently seen first; example.com. merges both days.
+	ex := recs[0]
+	if ex.Domain != "example.com." || ex.Count != 2 || ex.FirstSeen.Day() != 13 || ex.LastSeen.Hour() != 11 {
+		t.Errorf("record = %+v", ex)
+	}
+	if len(ex.Clients) != 1 || ex.Clients[0].IP != "10.0.0.1" || ex.Clients[0].Count != 2 {
+		t.Errorf("clients = %+v", ex.Clients)
+	}
+	if recs[1].Domain != "www.example.com." {
+		t.Errorf("second domain = %q, want www.example.com.", recs[1].Domain)
+	}
+
+	if got := len(p.LookupIP(netip.MustParsePrefix("1.2.0.0/16"))); got != 3 {
+		t.Errorf("LookupIP(1.2.0.0/16) = %d records, want 3", got)
+	}
+	if got := len(p.LookupIP(netip.MustParsePrefix("0.0.0.0/32"))); got != 0 {
+		t.Errorf("blocked answers indexed: %d records", got)
+	}
+	if got := p.LookupDomain("Example.COM"); len(got) != 1 || got[0].IP != "1.2.3.4" {
+		t.Errorf("LookupDomain = %+v", got)
+	}
+
+	// Appended lines are picked up incrementally; a partial line waits.
+	f, err := os.OpenFile(today, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("2026-02-14 13:00:00\t10.0.0.3\tTV\t5\tRESOLVED\tother.org.\tA (1.2.9.9)\tNOERROR\tRESOLVED\tA\tblocky\n")
+	f.WriteString("2026-02-14 13:00:01\t10.0.0.3\tTV\t5\tRESOLVED\tother.org.\tA (1.2.9")
+	f.Close()
+	if err := p.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.LookupDomain("other.org"); len(got) != 1 || got[0].Count != 2 || len(got[0].Clients) != 2 {
+		t.Errorf("after append = %+v", got)
+	}
+
+	// A file that cannot be read is skipped, not the end of the refresh.
+	if err := os.Mkdir(filepath.Join(dir, "2026-02-12_ALL.log"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Refresh(); err != nil {
+		t.Fatalf("refresh with an unreadable file: %v", err)
+	}
+	if got := p.LookupDomain("other.org"); len(got) != 1 || got[0].Count != 2 {
+		t.Errorf("after unreadable file = %+v", got)
+	}
+
+	os.Remove(today)
+	p.Refresh()
+	if got := len(p.LookupDomain("other.org")); got != 0 {
+		t.Errorf("deleted file still indexed: %d records", got)
+	}
+}
@@ -3,6 +3,7 @@ package logparser
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -11,17 +12,18 @@ import (
 
 // LogFilter defines filter criteria for log queries.
 type LogFilter struct {
+	Query  string // search expression, see ParseQuery
 	Client string // filter by client IP substring
 	Domain string // filter by domain substring
 	Type   string // "blocked", "cached", "resolved", or "" for all
 }
 
-// LogsResponse is the paginated logs response.
+// LogsResponse is a page of log entries, newest first. NextCursor is set when
+// more entries may follow.
 type LogsResponse struct {
-	Total   int         `json:"total"`
-	Offset  int         `json:"offset"`
-	Limit   int         `json:"limit"`
-	Entries []*LogEntry `json:"entries"`
+	Limit      int         `json:"limit"`
+	Entries    []*LogEntry `json:"entries"`
+	NextCursor string      `json:"next_cursor,omitempty"`
 }
 
 // ParseFile reads a Blocky log file and returns all parsed entries.
@@ -78,48 +80,79 @@ func LoadEntriesForRange(logDir string, start, end time.Time) ([]*LogEntry, int,
 	return allEntries, len(files), nil
 }
 
-// MatchesFilter tests whether a single entry passes the filter criteria.
-func MatchesFilter(e *LogEntry, filter LogFilter) bool {
-	if filter.Client != "" {
-		cl := strings.ToLower(filter.Client)
-		if !strings.Contains(strings.ToLower(e.ClientIP), cl) &&
-			!strings.Contains(strings.ToLower(e.ClientName), cl) &&
-			!strings.Contains(strings.ToLower(e.ResolvedName), cl) {
-			return false
+// ScanForward streams the entries of the log files in the date range in
+// chronological order, stopping as soon as fn returns false.
+func ScanForward(logDir string, start, end time.Time, fn func(e *LogEntry) bool) error {
+	for _, path := range LogFilesForRange(logDir, start, end) {
+		more, err := scanFile(path, fn)
+		if err != nil {
+			return err
+		}
+		if !more {
+			return nil
 		}
 	}
-	if filter.Domain != "" && !strings.Contains(strings.ToLower(e.Domain), strings.ToLower(filter.Domain)) {
-		return false
+	return nil
+}
+
+func scanFile(path string, fn func(*LogEntry) bool) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return true, nil // skip unreadable files
 	}
-	if filter.Type != "" {
-		switch filter.Type {
-		case "blocked":
-			if !e.IsBlocked() {
-				return false
-			}
-		case "cached":
-			if !e.IsCached() {
-				return false
-			}
-		case "resolved":
-			if e.IsBlocked() || e.IsCached() {
-				return false
-			}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 1024*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		entry, err := ParseLine(line)
+		if err != nil {
+			continue
+		}
+		if !fn(entry) {
+			return false, nil
 		}
 	}
-	return true
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("read %s: %w", filepath.Base(path), err)
+	}
+	return true, nil
 }
 
-// FilterEntries applies a LogFilter to a set of entries.
-func FilterEntries(entries []*LogEntry, filter LogFilter) []*LogEntry {
-	if filter.Client == "" && filter.Domain == "" && filter.Type == "" {
-		return entries
+// ScanFrom streams the complete lines of a log file starting at byte
+// offset, passing each entry with the offset just past its line. It returns
+// the end of the last complete line read; a trailing partial line is left
+// for a later call.
+func ScanFrom(path string, offset int64, fn func(e *LogEntry, end int64) bool) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return offset, err
 	}
-	var result []*LogEntry
-	for _, e := range entries {
-		if MatchesFilter(e, filter) {
-			result = append(result, e)
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+
+	br := bufio.NewReaderSize(f, 64*1024)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				return offset, nil
+			}
+			return offset, fmt.Errorf("read %s: %w", filepath.Base(path), err)
+		}
+		offset += int64(len(line))
+		entry, err := ParseLine(strings.TrimRight(line, "\r\n"))
+		if err != nil {
+			continue
+		}
+		if !fn(entry, offset) {
+			return offset, nil
 		}
 	}
-	return result
 }
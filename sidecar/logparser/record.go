@@ -0,0 +1,217 @@
+package logparser
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Record is a compact, value-typed view of a log line used by the aggregate
+// path. String fields are interned by the RecordParser that produced them and
+// outcome flags are computed once at parse time.
+type Record struct {
+	Timestamp        time.Time
+	ClientIP         string
+	ClientName       string
+	DurationMs       float64
+	ResponseReason   string
+	Domain           string
+	ReturnCode       string
+	ResponseCategory string
+	QueryType        string
+	Blocked          bool
+	Cached           bool
+}
+
+var (
+	errShortLine    = errors.New("expected 11 tab-separated fields")
+	errBadTimestamp = errors.New("invalid timestamp")
+)
+
+// RecordParser parses raw log lines into Records without per-line heap
+// allocations. It is not safe for concurrent use; create one per file.
+type RecordParser struct {
+	strings map[string]string
+
+	// Log files cover a single day, so the date part is parsed once and
+	// reused while it stays the same.
+	day      [10]byte
+	dayStart time.Time
+}
+
+func NewRecordParser() *RecordParser {
+	p := &RecordParser{strings: make(map[string]string, 256)}
+	// Seed the common low-cardinality values so they share storage across files.
+	for _, s := range commonSymbols {
+		p.strings[s] = s
+	}
+	return p
+}
+
+var commonSymbols = []string{
+	"A", "AAAA", "CNAME", "HTTPS", "MX", "NS", "PTR", "SOA", "SRV", "SVCB", "TXT",
+	"NOERROR", "NXDOMAIN", "SERVFAIL", "REFUSED",
+	"CACHED", "RESOLVED", "BLOCKED", "CONDITIONAL", "CUSTOMDNS", "HOSTSFILE", "SPECIAL",
+}
+
+// intern returns a string equal to b, reusing a previous allocation when the
+// same value has been seen before. The map lookup with string(b) does not allocate.
+func (p *RecordParser) intern(b []byte) string {
+	if s, ok := p.strings[string(b)]; ok {
+		return s
+	}
+	s := string(b)
+	p.strings[s] = s
+	return s
+}
+
+// Parse parses a single TSV log line. The line may be reused by the caller
+// once Parse returns.
+func (p *RecordParser) Parse(line []byte) (Record, error) {
+	var fields [11][]byte
+	rest := line
+	for i := range fields {
+		if i == len(fields)-1 {
+			// Source is the last field; ignore anything after another tab.
+			if j := bytes.IndexByte(rest, '\t'); j >= 0 {
+				rest = rest[:j]
+			}
+			fields[i] = rest
+			break
+		}
+		j := bytes.IndexByte(rest, '\t')
+		if j < 0 {
+			return Record{}, errShortLine
+		}
+		fields[i] = rest[:j]
+		rest = rest[j+1:]
+	}
+
+	ts, ok := p.parseTimestamp(fields[0])
+	if !ok {
+		return Record{}, errBadTimestamp
+	}
+
+	reason := p.intern(fields[4])
+	return Record{
+		Timestamp:        ts,
+		ClientIP:         p.intern(fields[1]),
+		ClientName:       p.intern(fields[2]),
+		DurationMs:       parseDuration(fields[3]),
+		ResponseReason:   reason,
+		Domain:           p.intern(fields[5]),
+		ReturnCode:       p.intern(fields[7]),
+		ResponseCategory: p.intern(fields[8]),
+		QueryType:        p.intern(fields[9]),
+		Blocked:          isBlockedReason(reason),
+		Cached:           isCachedReason(reason),
+	}, nil
+}
+
+// Record converts a LogEntry into the value type consumed by accumulators.
+func (e *LogEntry) Record() Record {
+	return Record{
+		Timestamp:        e.Timestamp,
+		ClientIP:         e.ClientIP,
+		ClientName:       e.ClientName,
+		DurationMs:       e.DurationMs,
+		ResponseReason:   e.ResponseReason,
+		Domain:           e.Domain,
+		ReturnCode:       e.ReturnCode,
+		ResponseCategory: e.ResponseCategory,
+		QueryType:        e.QueryType,
+		Blocked:          e.IsBlocked(),
+		Cached:           e.IsCached(),
+	}
+}
+
+// parseTimestamp parses "2006-01-02 15:04:05" as UTC, matching time.Parse
+// for this layout without its generic layout handling.
+func (p *RecordParser) parseTimestamp(b []byte) (time.Time, bool) {
+	if len(b) != 19 || b[10] != ' ' || b[13] != ':' || b[16] != ':' {
+		return time.Time{}, false
+	}
+	if p.dayStart.IsZero() || !bytes.Equal(b[:10], p.day[:]) {
+		start, ok := parseDate(b[:10])
+		if !ok {
+			return time.Time{}, false
+		}
+		copy(p.day[:], b[:10])
+		p.dayStart = start
+	}
+	hour, ok1 := atoi(b[11:13])
+	minute, ok2 := atoi(b[14:16])
+	sec, ok3 := atoi(b[17:19])
+	if !(ok1 && ok2 && ok3) || hour > 23 || minute > 59 || sec > 59 {
+		return time.Time{}, false
+	}
+	return p.dayStart.Add(time.Duration(hour*3600+minute*60+sec) * time.Second), true
+}
+
+func parseDate(b []byte) (time.Time, bool) {
+	if b[4] != '-' || b[7] != '-' {
+		return time.Time{}, false
+	}
+	year, ok1 := atoi(b[0:4])
+	month, ok2 := atoi(b[5:7])
+	day, ok3 := atoi(b[8:10])
+	if !(ok1 && ok2 && ok3) || month < 1 || month > 12 || day < 1 || day > daysIn(time.Month(month), year) {
+		return time.Time{}, false
+	}
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC), true
+}
+
+func daysIn(m time.Month, year int) int {
+	return time.Date(year, m+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+func atoi(b []byte) (int, bool) {
+	n := 0
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n, true
+}
+
+// parseDuration handles Blocky's integer millisecond durations directly and
+// falls back to strconv for anything else. Unparseable values yield 0.
+func parseDuration(b []byte) float64 {
+	if n, ok := atoi(b); ok && len(b) > 0 && len(b) < 16 {
+		return float64(n)
+	}
+	d, err := strconv.ParseFloat(string(b), 64)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// hasPrefixFold reports whether s begins with the upper-case ASCII prefix,
+// ignoring case in s.
+func hasPrefixFold(s, upperPrefix string) bool {
+	if len(s) < len(upperPrefix) {
+		return false
+	}
+	for i := 0; i < len(upperPrefix); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		if c != upperPrefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func isBlockedReason(reason string) bool {
+	return hasPrefixFold(reason, "BLOCKED")
+}
+
+func isCachedReason(reason string) bool {
+	return hasPrefixFold(reason, "CACHED") && (len(reason) == 6 || reason[6] == ' ')
+}
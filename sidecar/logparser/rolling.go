@@ -0,0 +1,226 @@
+package logparser
+
+import (
+	"sync"
+	"time"
+)
+
+// RollingTop is the number of domains and clients kept in each window of a
+// RollingSnapshot.
+const RollingTop = 25
+
+// rollingSpans are the windows reported by RollingStats, shortest first.
+var rollingSpans = []struct {
+	name string
+	secs int64
+}{
+	{"1m", 60},
+	{"5m", 300},
+	{"15m", 900},
+}
+
+// RollingSnapshot holds the aggregates over each rolling window at Time.
+type RollingSnapshot struct {
+	Time    time.Time                `json:"time"`
+	Windows map[string]RollingWindow `json:"windows"`
+}
+
+type RollingWindow struct {
+	Queries     int           `json:"queries"`
+	QPS         float64       `json:"qps"`
+	BlockedRate float64       `json:"blocked_rate"`
+	CachedRate  float64       `json:"cached_rate"`
+	TopDomains  []DomainCount `json:"top_domains"`
+	TopClients  []ClientCount `json:"top_clients"`
+}
+
+type ClientCount struct {
+	IP    string `json:"ip"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// rollingCounts are query counts over some span of seconds.
+type rollingCounts struct {
+	total, blocked, cached int
+	domains                map[string]int
+	clients                map[string]int
+}
+
+func newRollingCounts() rollingCounts {
+	return rollingCounts{domains: make(map[string]int), clients: make(map[string]int)}
+}
+
+func (c *rollingCounts) inc(e *LogEntry, blocked, cached bool) {
+	c.total++
+	if blocked {
+		c.blocked++
+	}
+	if cached {
+		c.cached++
+	}
+	c.domains[e.Domain]++
+	c.clients[e.ClientIP]++
+}
+
+// add adds (sign 1) or subtracts (sign -1) o, dropping keys that reach zero.
+func (c *rollingCounts) add(o *rollingCounts, sign int) {
+	c.total += sign * o.total
+	c.blocked += sign * o.blocked
+	c.cached += sign * o.cached
+	for k, n := range o.domains {
+		if c.domains[k] += sign * n; c.domains[k] <= 0 {
+			delete(c.domains, k)
+		}
+	}
+	for k, n := range o.clients {
+		if c.clients[k] += sign * n; c.clients[k] <= 0 {
+			delete(c.clients, k)
+		}
+	}
+}
+
+// RollingStats aggregates live queries into one-second buckets and keeps a
+// running sum for each window, so a snapshot costs no more than sorting
+// the window's domains and clients.
+type RollingStats struct {
+	mu      sync.Mutex
+	buckets []rollingCounts // ring indexed by unix second
+	secs    []int64         // second held by each bucket
+	windows []rollingCounts
+	head    int64 // newest second included in the windows
+	names   map[string]string
+
+	snapAt int64
+	snap   *RollingSnapshot
+}
+
+func NewRollingStats() *RollingStats {
+	n := rollingSpans[len(rollingSpans)-1].secs
+	rs := &RollingStats{
+		buckets: make([]rollingCounts, n),
+		secs:    make([]int64, n),
+		windows: make([]rollingCounts, len(rollingSpans)),
+		names:   make(map[string]string),
+	}
+	for i := range rs.buckets {
+		rs.buckets[i] = newRollingCounts()
+		rs.secs[i] = -1
+	}
+	for i := range rs.windows {
+		rs.windows[i] = newRollingCounts()
+	}
+	return rs
+}
+
+// wallClock interprets a log timestamp, which carries no zone and is
+// parsed as UTC, as local time.
+func wallClock(ts time.Time) time.Time {
+	return time.Date(ts.Year(), ts.Month(), ts.Day(), ts.Hour(), ts.Minute(), ts.Second(), ts.Nanosecond(), time.Local)
+}
+
+// Add counts an entry in the second of its timestamp. Entries older than
+// the longest window are ignored; entries from the future count as now.
+func (rs *RollingStats) Add(e *LogEntry, now time.Time) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.advance(now.Unix())
+
+	sec := wallClock(e.Timestamp).Unix()
+	if sec > rs.head {
+		sec = rs.head
+	}
+	age := rs.head - sec
+	if age >= int64(len(rs.buckets)) {
+		return
+	}
+
+	blocked, cached := e.IsBlocked(), e.IsCached()
+	rs.bucket(sec).inc(e, blocked, cached)
+	for i, span := range rollingSpans {
+		if age < span.secs {
+			rs.windows[i].inc(e, blocked, cached)
+		}
+	}
+	rs.snap = nil
+	if name := e.ClientName; name != "" && name != e.ClientIP {
+		rs.names[e.ClientIP] = name
+	}
+	if e.ResolvedName != "" {
+		rs.names[e.ClientIP] = e.ResolvedName
+	}
+}
+
+// bucket returns the bucket for sec, clearing it if it held an older second.
+func (rs *RollingStats) bucket(sec int64) *rollingCounts {
+	i := sec % int64(len(rs.buckets))
+	if rs.secs[i] != sec {
+		rs.buckets[i] = newRollingCounts()
+		rs.secs[i] = sec
+	}
+	return &rs.buckets[i]
+}
+
+// advance moves the windows forward to now, subtracting the seconds that
+// fall out of each one.
+func (rs *RollingStats) advance(now int64) {
+	if now <= rs.head {
+		return
+	}
+	if rs.head == 0 || now-rs.head > int64(len(rs.buckets)) {
+		// First use or idle for longer than every window: nothing to keep.
+		for i := range rs.windows {
+			rs.windows[i] = newRollingCounts()
+		}
+		rs.head = now
+		return
+	}
+	for i, span := range rollingSpans {
+		for s := rs.head - span.secs + 1; s <= now-span.secs; s++ {
+			if j := s % int64(len(rs.buckets)); s >= 0 && rs.secs[j] == s {
+				rs.windows[i].add(&rs.buckets[j], -1)
+			}
+		}
+	}
+	rs.head = now
+	if len(rs.names) > 4*len(rs.windows[len(rs.windows)-1].clients)+1024 {
+		for ip := range rs.names {
+			if _, ok := rs.windows[len(rs.windows)-1].clients[ip]; !ok {
+				delete(rs.names, ip)
+			}
+		}
+	}
+}
+
+// Snapshot returns the aggregates of every window ending at now. It is
+// computed at most once per second and shared between callers, who must
+// not modify it.
+func (rs *RollingStats) Snapshot(now time.Time) *RollingSnapshot {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.advance(now.Unix())
+	if rs.snap != nil && rs.snapAt == rs.head {
+		return rs.snap
+	}
+
+	snap := &RollingSnapshot{Time: time.Unix(rs.head, 0), Windows: make(map[string]RollingWindow, len(rollingSpans))}
+	for i, span := range rollingSpans {
+		w := &rs.windows[i]
+		rw := RollingWindow{
+			Queries:    w.total,
+			QPS:        float64(w.total) / float64(span.secs),
+			TopDomains: topN(w.domains, RollingTop),
+			TopClients: make([]ClientCount, 0, RollingTop),
+		}
+		if w.total > 0 {
+			rw.BlockedRate = float64(w.blocked) / float64(w.total)
+			rw.CachedRate = float64(w.cached) / float64(w.total)
+		}
+		for _, c := range topN(w.clients, RollingTop) {
+			rw.TopClients = append(rw.TopClients, ClientCount{IP: c.Domain, Name: rs.names[c.Domain], Count: c.Count})
+		}
+		snap.Windows[span.name] = rw
+	}
+	rs.snap, rs.snapAt = snap, rs.head
+	return snap
+}
@@ -0,0 +1,77 @@
+package logparser
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRollingStatsWindows(t *testing.T) {
+	now := time.Date(2026, 2, 14, 10, 0, 0, 0, time.Local)
+	// Log timestamps are local wall-clock times parsed as UTC.
+	entry := func(ago time.Duration, client, domain, reason string) *LogEntry {
+		ts := now.Add(-ago)
+		ts = time.Date(ts.Year(), ts.Month(), ts.Day(), ts.Hour(), ts.Minute(), ts.Second(), 0, time.UTC)
+		return &LogEntry{Timestamp: ts, ClientIP: client, ClientName: client, Domain: domain, ResponseReason: reason}
+	}
+
+	rs := NewRollingStats()
+	rs.Add(entry(10*time.Second, "10.0.0.1", "a.com.", "RESOLVED"), now)
+	rs.Add(entry(20*time.Second, "10.0.0.1", "a.com.", "CACHED"), now)
+	rs.Add(entry(2*time.Minute, "10.0.0.2", "ads.com.", "BLOCKED (ads)"), now)
+	rs.Add(entry(10*time.Minute, "10.0.0.2", "b.com.", "RESOLVED"), now)
+	rs.Add(entry(20*time.Minute, "10.0.0.3", "old.com.", "RESOLVED"), now)
+
+	snap := rs.Snapshot(now)
+	if got := snap.Windows["1m"].Queries; got != 2 {
+		t.Errorf("1m queries = %d, want 2", got)
+	}
+	if got := snap.Windows["5m"].Queries; got != 3 {
+		t.Errorf("5m queries = %d, want 3", got)
+	}
+	if got := snap.Windows["15m"].Queries; got != 4 {
+		t.Errorf("15m queries = %d, want 4", got)
+	}
+	w := snap.Windows["1m"]
+	if w.CachedRate != 0.5 || w.BlockedRate != 0 || w.QPS != 2.0/60 {
+		t.Errorf("1m rates = cached %v blocked %v qps %v", w.CachedRate, w.BlockedRate, w.QPS)
+	}
+	if len(w.TopDomains) != 1 || w.TopDomains[0] != (DomainCount{"a.com.", 2}) {
+		t.Errorf("1m top domains = %+v", w.TopDomains)
+	}
+	if got := snap.Windows["5m"].BlockedRate; got != 1.0/3 {
+		t.Errorf("5m blocked rate = %v, want 1/3", got)
+	}
+	if top := snap.Windows["15m"].TopClients; len(top) != 2 || top[0].Count != 2 || top[0].Name != "" {
+		t.Errorf("15m top clients = %+v", top)
+	}
+
+	// One minute later the first two entries leave the 1m window and the
+	// blocked one is still within 5m.
+	later := now.Add(time.Minute)
+	snap = rs.Snapshot(later)
+	if got := snap.Windows["1m"].Queries; got != 0 {
+		t.Errorf("1m queries after 1m = %d, want 0", got)
+	}
+	if got := snap.Windows["5m"].Queries; got != 3 {
+		t.Errorf("5m queries after 1m = %d, want 3", got)
+	}
+	if len(snap.Windows["1m"].TopDomains) != 0 {
+		t.Errorf("1m top domains after 1m = %+v, want none", snap.Windows["1m"].TopDomains)
+	}
+
+	// Five minutes later the 5m window is empty and the entry from 10
+	// minutes ago has left the 15m window.
+	snap = rs.Snapshot(now.Add(5*time.Minute + time.Second))
+	if got := snap.Windows["5m"].Queries; got != 0 {
+		t.Errorf("5m queries after 5m = %d, want 0", got)
+	}
+	if got := snap.Windows["15m"].Queries; got != 3 {
+		t.Errorf("15m queries after 5m = %d, want 3", got)
+	}
+
+	// After every window has passed everything is gone.
+	snap = rs.Snapshot(now.Add(time.Hour))
+	if got := snap.Windows["15m"].Queries; got != 0 {
+		t.Errorf("15m queries after 1h = %d, want 0", got)
+	}
+}
@@ -0,0 +1,635 @@
+package logparser
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Search expressions select log entries with field predicates combined by
+// boolean operators:
+//
+//	domain:tiktok -client:tv            implicit AND, "-" negates
+//	(qtype:A OR qtype:AAAA) rcode:NXDOMAIN
+//	domain:*.example.com duration>=50   globs are anchored
+//	reason:/^blocked \(ads/ NOT upstream:"1.1.1.1"
+//
+// Fields are client, domain, qtype, rcode, reason, upstream, answer, type
+// (blocked, cached or resolved) and duration, which accepts =, <, <=, >
+// and >=. Values are case-insensitive substrings unless they contain * or ?
+// (glob), are written /like this/ (regexp) or are quoted (literal). qtype
+// and rcode match whole values. A bare value matches domain or client.
+
+// Query is a compiled search expression.
+type Query struct {
+	root queryNode
+}
+
+// QueryError is a syntax error at a 1-based character position in a search expression.
+type QueryError struct {
+	Pos int
+	Msg string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("query error at position %d: %s", e.Pos, e.Msg)
+}
+
+// ParseQuery compiles a search expression. An empty expression matches everything.
+func ParseQuery(src string) (*Query, error) {
+	p := &queryParser{lex: queryLexer{src: src}}
+	p.next()
+	if p.tok.kind == tokEOF {
+		return &Query{}, nil
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %s", p.tok)
+	}
+	return &Query{root: root}, nil
+}
+
+// Match reports whether e satisfies the query.
+func (q *Query) Match(e *LogEntry) bool {
+	if q == nil || q.root == nil {
+		return true
+	}
+	return q.root.match(e)
+}
+
+// NeedsResolvedName reports whether matching looks at client names, in which
+// case entries should be enriched with ResolvedName before calling Match.
+func (q *Query) NeedsResolvedName() bool {
+	return q != nil && q.root != nil && q.root.usesClient()
+}
+
+// And returns a query matching entries that satisfy both q and other.
+func (q *Query) And(other *Query) *Query {
+	switch {
+	case q == nil || q.root == nil:
+		return other
+	case other == nil || other.root == nil:
+		return q
+	}
+	return &Query{root: andNode{q.root, other.root}}
+}
+
+// Compile turns the filter into a single Query. The simple Client, Domain
+// and Type filters are ANDed with the search expression.
+func (f LogFilter) Compile() (*Query, error) {
+	q, err := ParseQuery(f.Query)
+	if err != nil {
+		return nil, err
+	}
+	if f.Client != "" {
+		q = q.And(&Query{root: fieldNode{field: fieldClient, m: substringMatcher(f.Client), lits: literals(f.Client)}})
+	}
+	if f.Domain != "" {
+		q = q.And(&Query{root: fieldNode{field: fieldDomain, m: substringMatcher(f.Domain), lits: literals(f.Domain)}})
+	}
+	if t, ok := outcomeTypes[f.Type]; ok {
+		q = q.And(&Query{root: typeNode(t)})
+	}
+	return q, nil
+}
+
+// --- AST ---
+
+type queryNode interface {
+	match(e *LogEntry) bool
+	usesClient() bool
+}
+
+type andNode struct{ l, r queryNode }
+type orNode struct{ l, r queryNode }
+type notNode struct{ n queryNode }
+
+func (n andNode) match(e *LogEntry) bool { return n.l.match(e) && n.r.match(e) }
+func (n orNode) match(e *LogEntry) bool  { return n.l.match(e) || n.r.match(e) }
+func (n notNode) match(e *LogEntry) bool { return !n.n.match(e) }
+func (n andNode) usesClient() bool       { return n.l.usesClient() || n.r.usesClient() }
+func (n orNode) usesClient() bool        { return n.l.usesClient() || n.r.usesClient() }
+func (n notNode) usesClient() bool       { return n.n.usesClient() }
+
+type field int
+
+const (
+	fieldAny field = iota // bare value: domain or client
+	fieldClient
+	fieldDomain
+	fieldQType
+	fieldRCode
+	fieldReason
+	fieldUpstream
+	fieldAnswer
+	fieldType
+	fieldDuration
+)
+
+var fieldNames = map[string]field{
+	"client":   fieldClient,
+	"domain":   fieldDomain,
+	"qtype":    fieldQType,
+	"rcode":    fieldRCode,
+	"reason":   fieldReason,
+	"upstream": fieldUpstream,
+	"answer":   fieldAnswer,
+	"type":     fieldType,
+	"duration": fieldDuration,
+}
+
+type fieldNode struct {
+	field field
+	m     textMatcher
+	lits  []string // lowercase substrings every match contains, if known
+}
+
+func (n fieldNode) match(e *LogEntry) bool {
+	switch n.field {
+	case fieldClient:
+		return n.m(e.ClientIP) || n.m(e.ClientName) || (e.ResolvedName != "" && n.m(e.ResolvedName))
+	case fieldDomain:
+		return n.m(strings.TrimSuffix(e.Domain, "."))
+	case fieldQType:
+		return n.m(e.QueryType)
+	case fieldRCode:
+		return n.m(e.ReturnCode)
+	case fieldReason:
+		return n.m(e.ResponseReason)
+	case fieldUpstream:
+		up := Upstream(e.ResponseReason)
+		return up != "" && n.m(up)
+	case fieldAnswer:
+		return n.m(e.ResponseAnswer)
+	default: // fieldAny
+		return n.m(strings.TrimSuffix(e.Domain, ".")) ||
+			n.m(e.ClientIP) || n.m(e.ClientName) || (e.ResolvedName != "" && n.m(e.ResolvedName))
+	}
+}
+
+func (n fieldNode) usesClient() bool { return n.field == fieldClient || n.field == fieldAny }
+
+type outcome int
+
+const (
+	outcomeBlocked outcome = iota
+	outcomeCached
+	outcomeResolved
+)
+
+var outcomeTypes = map[string]outcome{
+	"blocked":  outcomeBlocked,
+	"cached":   outcomeCached,
+	"resolved": outcomeResolved,
+}
+
+type typeNode outcome
+
+func (n typeNode) match(e *LogEntry) bool {
+	switch outcome(n) {
+	case outcomeBlocked:
+		return e.IsBlocked()
+	case outcomeCached:
+		return e.IsCached()
+	default:
+		return !e.IsBlocked() && !e.IsCached()
+	}
+}
+
+func (n typeNode) usesClient() bool { return false }
+
+type durationNode struct {
+	op    string
+	value float64
+}
+
+func (n durationNode) match(e *LogEntry) bool {
+	d := e.DurationMs
+	switch n.op {
+	case "<":
+		return d < n.value
+	case "<=":
+		return d <= n.value
+	case ">":
+		return d > n.value
+	case ">=":
+		return d >= n.value
+	default:
+		return d == n.value
+	}
+}
+
+func (n durationNode) usesClient() bool { return false }
+
+// Upstream extracts the upstream resolver from a response reason such as
+// "RESOLVED (tcp+udp:1.1.1.1)". It returns "" for other reasons.
+func Upstream(reason string) string {
+	if !hasPrefixFold(reason, "RESOLVED (") {
+		return ""
+	}
+	rest := reason[len("RESOLVED ("):]
+	if i := strings.IndexByte(rest, ')'); i >= 0 {
+		rest = rest[:i]
+	}
+	return rest
+}
+
+// --- value matching ---
+
+type textMatcher func(s string) bool
+
+func substringMatcher(v string) textMatcher {
+	return func(s string) bool { return containsFold(s, v) }
+}
+
+func exactMatcher(v string) textMatcher {
+	return func(s string) bool { return strings.EqualFold(s, v) }
+}
+
+func regexpMatcher(re *regexp.Regexp) textMatcher {
+	return re.MatchString
+}
+
+// globToRegexp converts a glob with * and ? wildcards into an anchored,
+// case-insensitive regular expression.
+func globToRegexp(glob string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("(?i)^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// containsFold is a case-insensitive strings.Contains for ASCII-dominated
+// log fields that avoids lowering either string.
+func containsFold(s, sub string) bool {
+	if sub == "" {
+		return true
+	}
+	for i := 0; i+len(sub) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(sub)], sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// --- lexer ---
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokLParen
+	tokRParen
+	tokAnd
+	tokOr
+	tokNot
+	tokValue // bare word, quoted string or regexp, optionally with a field prefix
+)
+
+type valueKind int
+
+const (
+	valWord valueKind = iota
+	valQuoted
+	valRegexp
+)
+
+type token struct {
+	kind   tokKind
+	pos    int // 1-based
+	field  string
+	op     string // ":", "=", "<", "<=", ">", ">=" when field is set
+	value  string
+	vkind  valueKind
+	valPos int
+	err    *QueryError
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of query"
+	case tokLParen:
+		return `"("`
+	case tokRParen:
+		return `")"`
+	case tokAnd:
+		return "AND"
+	case tokOr:
+		return "OR"
+	case tokNot:
+		return "NOT"
+	}
+	return fmt.Sprintf("%q", t.value)
+}
+
+type queryLexer struct {
+	src string
+	pos int // 0-based byte offset
+}
+
+func isSpace(c byte) bool { return c == ' ' || c == '\t' || c == '\n' || c == '\r' }
+
+func isIdent(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' }
+
+func (l *queryLexer) next() token {
+	for l.pos < len(l.src) && isSpace(l.src[l.pos]) {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: start + 1}
+	}
+	switch c := l.src[l.pos]; {
+	case c == '(':
+		l.pos++
+		return token{kind: tokLParen, pos: start + 1}
+	case c == ')':
+		l.pos++
+		return token{kind: tokRParen, pos: start + 1}
+	case c == '-' && l.pos+1 < len(l.src) && !isSpace(l.src[l.pos+1]):
+		l.pos++
+		return token{kind: tokNot, pos: start + 1}
+	}
+
+	// Field prefix: identifier followed by an operator.
+	i := l.pos
+	for i < len(l.src) && isIdent(l.src[i]) {
+		i++
+	}
+	if i > l.pos && i < len(l.src) {
+		if op := readOp(l.src[i:]); op != "" {
+			name := strings.ToLower(l.src[l.pos:i])
+			l.pos = i + len(op)
+			tok := token{kind: tokValue, pos: start + 1, field: name, op: op}
+			l.readValue(&tok)
+			return tok
+		}
+	}
+
+	tok := token{kind: tokValue, pos: start + 1}
+	l.readValue(&tok)
+	if tok.err == nil && tok.vkind == valWord {
+		switch tok.value {
+		case "AND":
+			return token{kind: tokAnd, pos: start + 1}
+		case "OR":
+			return token{kind: tokOr, pos: start + 1}
+		case "NOT":
+			return token{kind: tokNot, pos: start + 1}
+		}
+	}
+	return tok
+}
+
+func readOp(s string) string {
+	for _, op := range []string{"<=", ">=", ":", "=", "<", ">"} {
+		if strings.HasPrefix(s, op) {
+			return op
+		}
+	}
+	return ""
+}
+
+// readValue reads a word, "quoted string" or /regexp/ at the current position.
+func (l *queryLexer) readValue(tok *token) {
+	tok.valPos = l.pos + 1
+	if l.pos >= len(l.src) {
+		return
+	}
+	switch delim := l.src[l.pos]; delim {
+	case '"', '/':
+		var b strings.Builder
+		for i := l.pos + 1; i < len(l.src); i++ {
+			c := l.src[i]
+			if c == '\\' && i+1 < len(l.src) {
+				next := l.src[i+1]
+				// Keep escapes other than the delimiter for the regexp engine.
+				if next != delim && (delim == '/' || next != '\\') {
+					b.WriteByte(c)
+				}
+				b.WriteByte(next)
+				i++
+				continue
+			}
+			if c == delim {
+				l.pos = i + 1
+				tok.value = b.String()
+				tok.vkind = valQuoted
+				if delim == '/' {
+					tok.vkind = valRegexp
+				}
+				return
+			}
+			b.WriteByte(c)
+		}
+		name := "quoted string"
+		if delim == '/' {
+			name = "regular expression"
+		}
+		tok.err = &QueryError{Pos: tok.valPos, Msg: "unterminated " + name}
+		l.pos = len(l.src)
+	default:
+		i := l.pos
+		for i < len(l.src) && !isSpace(l.src[i]) && l.src[i] != '(' && l.src[i] != ')' && l.src[i] != '"' {
+			i++
+		}
+		tok.value = l.src[l.pos:i]
+		tok.vkind = valWord
+		l.pos = i
+	}
+}
+
+// --- parser ---
+
+type queryParser struct {
+	lex queryLexer
+	tok token
+}
+
+func (p *queryParser) next() { p.tok = p.lex.next() }
+
+func (p *queryParser) errorf(format string, args ...any) *QueryError {
+	return &QueryError{Pos: p.tok.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch p.tok.kind {
+		case tokAnd:
+			p.next()
+		case tokNot, tokLParen, tokValue:
+			// implicit AND
+		default:
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+}
+
+func (p *queryParser) parseUnary() (queryNode, error) {
+	if p.tok.kind == tokNot {
+		p.next()
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *queryParser) parsePrimary() (queryNode, error) {
+	switch p.tok.kind {
+	case tokLParen:
+		open := p.tok
+		p.next()
+		if p.tok.kind == tokRParen {
+			return nil, p.errorf("empty group")
+		}
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokRParen {
+			if p.tok.kind == tokEOF {
+				return nil, &QueryError{Pos: open.pos, Msg: `unclosed "("`}
+			}
+			return nil, p.errorf(`expected ")", found %s`, p.tok)
+		}
+		p.next()
+		return n, nil
+	case tokValue:
+		n, err := p.term(p.tok)
+		if err != nil {
+			return nil, err
+		}
+		p.next()
+		return n, nil
+	case tokEOF:
+		return nil, p.errorf("unexpected end of query")
+	default:
+		return nil, p.errorf("unexpected %s", p.tok)
+	}
+}
+
+func (p *queryParser) term(t token) (queryNode, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+	f := fieldAny
+	if t.field != "" {
+		var ok bool
+		if f, ok = fieldNames[t.field]; !ok {
+			return nil, &QueryError{Pos: t.pos, Msg: fmt.Sprintf("unknown field %q", t.field)}
+		}
+	}
+	if t.value == "" && t.vkind == valWord {
+		return nil, &QueryError{Pos: t.valPos, Msg: "missing value"}
+	}
+
+	switch f {
+	case fieldDuration:
+		v, err := strconv.ParseFloat(t.value, 64)
+		if err != nil || t.vkind != valWord {
+			return nil, &QueryError{Pos: t.valPos, Msg: fmt.Sprintf("duration needs a number of milliseconds, got %q", t.value)}
+		}
+		op := t.op
+		if op == ":" {
+			op = "="
+		}
+		return durationNode{op: op, value: v}, nil
+	case fieldType:
+		if t.op != ":" && t.op != "=" {
+			return nil, &QueryError{Pos: t.pos, Msg: fmt.Sprintf("operator %q only applies to duration", t.op)}
+		}
+		o, ok := outcomeTypes[strings.ToLower(t.value)]
+		if !ok {
+			return nil, &QueryError{Pos: t.valPos, Msg: fmt.Sprintf("type must be blocked, cached or resolved, got %q", t.value)}
+		}
+		return typeNode(o), nil
+	}
+	if t.field != "" && t.op != ":" && t.op != "=" {
+		return nil, &QueryError{Pos: t.pos, Msg: fmt.Sprintf("operator %q only applies to duration", t.op)}
+	}
+
+	m, err := valueMatcher(t, f == fieldQType || f == fieldRCode || t.op == "=")
+	if err != nil {
+		return nil, err
+	}
+	n := fieldNode{field: f, m: m}
+	if t.vkind != valRegexp {
+		n.lits = literals(t.value)
+	}
+	return n, nil
+}
+
+// literals returns the lowercase fragments between the wildcards of a
+// substring, glob or literal value. Values that are not plain ASCII get
+// none, as case folding could change their length.
+func literals(v string) []string {
+	for i := 0; i < len(v); i++ {
+		if v[i] >= 0x80 {
+			return nil
+		}
+	}
+	return strings.FieldsFunc(strings.ToLower(v), func(r rune) bool { return r == '*' || r == '?' })
+}
+
+func valueMatcher(t token, exact bool) (textMatcher, error) {
+	switch t.vkind {
+	case valRegexp:
+		re, err := regexp.Compile("(?i)" + t.value)
+		if err != nil {
+			return nil, &QueryError{Pos: t.valPos, Msg: fmt.Sprintf("invalid regular expression: %v", err)}
+		}
+		return regexpMatcher(re), nil
+	case valWord:
+		if strings.ContainsAny(t.value, "*?") {
+			return regexpMatcher(globToRegexp(t.value)), nil
+		}
+	}
+	if exact {
+		return exactMatcher(t.value), nil
+	}
+	return substringMatcher(t.value), nil
+}
@@ -0,0 +1,125 @@
+package logparser
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var searchEntries = []*LogEntry{
+	{Timestamp: time.Date(2026, 2, 14, 10, 0, 0, 0, time.UTC), ClientIP: "10.0.0.5", ClientName: "tv.local", Domain: "www.tiktok.com.", QueryType: "A", ResponseReason: "RESOLVED (tcp+udp:1.1.1.1)", ReturnCode: "NOERROR", ResponseAnswer: "A (1.2.3.4)", DurationMs: 80},
+	{Timestamp: time.Date(2026, 2, 14, 10, 0, 1, 0, time.UTC), ClientIP: "10.0.0.5", ClientName: "tv.local", Domain: "ads.tiktok.com.", QueryType: "AAAA", ResponseReason: "BLOCKED (ads)", ReturnCode: "NOERROR", DurationMs: 0},
+	{Timestamp: time.Date(2026, 2, 14, 10, 0, 2, 0, time.UTC), ClientIP: "10.0.0.7", ClientName: "laptop", ResolvedName: "laptop.fritz.box", Domain: "example.com.", QueryType: "A", ResponseReason: "CACHED", ReturnCode: "NOERROR", DurationMs: 0},
+	{Timestamp: time.Date(2026, 2, 14, 10, 0, 3, 0, time.UTC), ClientIP: "10.0.0.7", ClientName: "laptop", Domain: "nope.invalid.", QueryType: "A", ResponseReason: "RESOLVED (tcp+udp:9.9.9.9)", ReturnCode: "NXDOMAIN", DurationMs: 42},
+}
+
+func TestParseQueryMatches(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []int // indexes into searchEntries
+	}{
+		{"", []int{0, 1, 2, 3}},
+		{"tiktok", []int{0, 1}},
+		{"laptop", []int{2, 3}},
+		{"client:fritz", []int{2}},
+		{"domain:tiktok -type:blocked", []int{0}},
+		{"domain:tiktok NOT type:blocked", []int{0}},
+		{"qtype:A", []int{0, 2, 3}},
+		{"qtype:aaaa", []int{1}},
+		{"rcode:NXDOMAIN", []int{3}},
+		{"reason:ads", []int{1}},
+		{"upstream:9.9.9.9", []int{3}},
+		{"answer:1.2.3.4", []int{0}},
+		{"duration>50", []int{0}},
+		{"duration>=42 duration<80", []int{3}},
+		{"duration:0", []int{1, 2}},
+		{"type:cached OR rcode:NXDOMAIN", []int{2, 3}},
+		{"client:tv AND (qtype:AAAA OR duration>50)", []int{0, 1}},
+		{"-(client:tv OR type:cached)", []int{3}},
+		{"domain:*.tiktok.com", []int{0, 1}},
+		{"domain:tik*", nil},
+		{"domain:/^(www|ads)\\./", []int{0, 1}},
+		{`reason:"BLOCKED (ads)"`, []int{1}},
+		{`domain:"*.tiktok.com"`, nil},
+	}
+	for _, tt := range tests {
+		q, err := ParseQuery(tt.query)
+		if err != nil {
+			t.Errorf("ParseQuery(%q) error: %v", tt.query, err)
+			continue
+		}
+		var got []int
+		for i, e := range searchEntries {
+			if q.Match(e) {
+				got = append(got, i)
+			}
+		}
+		if !equalInts(got, tt.want) {
+			t.Errorf("ParseQuery(%q) matched %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		pos   int
+	}{
+		{"(domain:a", 1},
+		{"domain:a )", 10},
+		{"foo:bar", 1},
+		{"domain:", 8},
+		{"duration>abc", 10},
+		{"qtype>5", 1},
+		{`domain:"unterminated`, 8},
+		{"domain:/[/", 8},
+		{"type:weird", 6},
+		{"a OR", 5},
+		{"()", 2},
+	}
+	for _, tt := range tests {
+		_, err := ParseQuery(tt.query)
+		var qe *QueryError
+		if !errors.As(err, &qe) {
+			t.Errorf("ParseQuery(%q) error = %v, want *QueryError", tt.query, err)
+			continue
+		}
+		if qe.Pos != tt.pos {
+			t.Errorf("ParseQuery(%q) error at %d (%s), want position %d", tt.query, qe.Pos, qe.Msg, tt.pos)
+		}
+	}
+}
+
+func TestLogFilterCompile(t *testing.T) {
+	q, err := LogFilter{Query: "qtype:A", Client: "10.0.0.7", Type: "resolved"}.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int
+	for i, e := range searchEntries {
+		if q.Match(e) {
+			got = append(got, i)
+		}
+	}
+	if !equalInts(got, []int{3}) {
+		t.Errorf("matched %v, want [3]", got)
+	}
+	if !q.NeedsResolvedName() {
+		t.Error("expected NeedsResolvedName with a client filter")
+	}
+	if q, _ := ParseQuery("domain:x"); q.NeedsResolvedName() {
+		t.Error("expected no NeedsResolvedName for domain-only query")
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -66,8 +66,8 @@ type TimelineBucket struct {
 	Cached    int       `json:"cached"`
 }
 
-// StatsAccumulator incrementally aggregates LogEntry data for stats computation.
-// Entries can be fed one at a time via Add(), then Finalize() produces the response.
+// StatsAccumulator incrementally aggregates log data for stats computation.
+// Records can be fed one at a time via AddRecord(), then Finalize() produces the response.
 type StatsAccumulator struct {
 	start, end         time.Time
 	hourly             [24]HourlyBucket
@@ -103,10 +103,15 @@ func NewStatsAccumulator(start, end time.Time) *StatsAccumulator {
 
 // Add processes a single log entry into the accumulator.
 func (a *StatsAccumulator) Add(e *LogEntry) {
+	a.AddRecord(e.Record())
+}
+
+// AddRecord processes a single parsed record into the accumulator.
+func (a *StatsAccumulator) AddRecord(r Record) {
 	a.totalQueries++
 
-	blocked := e.IsBlocked()
-	cached := e.IsCached()
+	blocked := r.Blocked
+	cached := r.Cached
 
 	if blocked {
 		a.blockedQueries++
@@ -116,7 +121,7 @@ func (a *StatsAccumulator) Add(e *LogEntry) {
 	}
 
 	// Hourly
-	h := e.Timestamp.Hour()
+	h := r.Timestamp.Hour()
 	a.hourly[h].Total++
 	if blocked {
 		a.hourly[h].Blocked++
@@ -126,19 +131,19 @@ func (a *StatsAccumulator) Add(e *LogEntry) {
 	}
 
 	// Domains
-	a.domainCounts[e.Domain]++
+	a.domainCounts[r.Domain]++
 
 	// Blocked domains
 	if blocked {
-		if bd, ok := a.blockedDomains[e.Domain]; ok {
+		if bd, ok := a.blockedDomains[r.Domain]; ok {
 			bd.Count++
 		} else {
-			a.blockedDomains[e.Domain] = &BlockedDomain{Domain: e.Domain, Count: 1, Reason: e.ResponseReason}
+			a.blockedDomains[r.Domain] = &BlockedDomain{Domain: r.Domain, Count: 1, Reason: r.ResponseReason}
 		}
 	}
 
 	// Clients
-	if cs, ok := a.clientMap[e.ClientIP]; ok {
+	if cs, ok := a.clientMap[r.ClientIP]; ok {
 		cs.Total++
 		if blocked {
 			cs.Blocked++
@@ -148,21 +153,21 @@ func (a *StatsAccumulator) Add(e *LogEntry) {
 		if blocked {
 			b = 1
 		}
-		a.clientMap[e.ClientIP] = &ClientStats{IP: e.ClientIP, Name: e.ClientName, Total: 1, Blocked: b}
+		a.clientMap[r.ClientIP] = &ClientStats{IP: r.ClientIP, Name: r.ClientName, Total: 1, Blocked: b}
 	}
 
 	// Query types
-	a.queryTypes[e.QueryType]++
+	a.queryTypes[r.QueryType]++
 
 	// Response categories
-	a.responseCategories[e.ResponseCategory]++
+	a.responseCategories[r.ResponseCategory]++
 
 	// Return codes
-	a.returnCodes[e.ReturnCode]++
+	a.returnCodes[r.ReturnCode]++
 
 	// Durations
-	a.durations = append(a.durations, e.DurationMs)
-	a.durationSum += e.DurationMs
+	a.durations = append(a.durations, r.DurationMs)
+	a.durationSum += r.DurationMs
 }
 
 // Merge combines another accumulator's data into this one.
@@ -213,6 +218,37 @@ func (a *StatsAccumulator) Merge(other *StatsAccumulator) {
 	a.durationSum += other.durationSum
 }
 
+// Rough per-entry costs used for cache budgeting. These approximate Go's map
+// bucket overhead and are only meant to be within a small factor of reality.
+const (
+	mapEntryOverhead = 48
+	countEntryBytes  = mapEntryOverhead + 8
+	blockedBytes     = mapEntryOverhead + 8 + 64 // *BlockedDomain
+	clientBytes      = mapEntryOverhead + 8 + 64 // *ClientStats
+	bucketBytes      = mapEntryOverhead + 8 + 8 + 48
+)
+
+// estimatedBytes approximates the heap retained by the accumulator.
+func (a *StatsAccumulator) estimatedBytes() int64 {
+	n := int64(256) // struct + hourly array
+	for k := range a.domainCounts {
+		n += countEntryBytes + int64(len(k))
+	}
+	for k, v := range a.blockedDomains {
+		n += blockedBytes + int64(len(k)+len(v.Reason))
+	}
+	for k, v := range a.clientMap {
+		n += clientBytes + int64(len(k)+len(v.Name))
+	}
+	for _, m := range []map[string]int{a.queryTypes, a.responseCategories, a.returnCodes} {
+		for k := range m {
+			n += countEntryBytes + int64(len(k))
+		}
+	}
+	n += int64(cap(a.durations)) * 8
+	return n
+}
+
 // Finalize computes the final StatsResponse from accumulated data.
 func (a *StatsAccumulator) Finalize(filesParsed int) *StatsResponse {
 	stats := &StatsResponse{
@@ -277,7 +313,7 @@ func ComputeStats(entries []*LogEntry, start, end time.Time, filesParsed int) *S
 	return acc.Finalize(filesParsed)
 }
 
-// TimelineAccumulator incrementally aggregates LogEntry data for timeline computation.
+// TimelineAccumulator incrementally aggregates log data for timeline computation.
 type TimelineAccumulator struct {
 	interval  time.Duration
 	bucketMap map[int64]*TimelineBucket
@@ -292,17 +328,22 @@ func NewTimelineAccumulator(interval time.Duration) *TimelineAccumulator {
 
 // Add processes a single log entry into the timeline accumulator.
 func (a *TimelineAccumulator) Add(e *LogEntry) {
-	key := e.Timestamp.Truncate(a.interval).Unix()
+	a.AddRecord(e.Record())
+}
+
+// AddRecord processes a single parsed record into the timeline accumulator.
+func (a *TimelineAccumulator) AddRecord(r Record) {
+	key := r.Timestamp.Truncate(a.interval).Unix()
 	b, ok := a.bucketMap[key]
 	if !ok {
 		b = &TimelineBucket{Timestamp: time.Unix(key, 0).UTC()}
 		a.bucketMap[key] = b
 	}
 	b.Total++
-	if e.IsBlocked() {
+	if r.Blocked {
 		b.Blocked++
 	}
-	if e.IsCached() {
+	if r.Cached {
 		b.Cached++
 	}
 }
@@ -321,6 +362,11 @@ func (a *TimelineAccumulator) Merge(other *TimelineAccumulator) {
 	}
 }
 
+// estimatedBytes approximates the heap retained by the accumulator.
+func (a *TimelineAccumulator) estimatedBytes() int64 {
+	return 64 + int64(len(a.bucketMap))*bucketBytes
+}
+
 // ReaggregateTo converts buckets to a coarser interval (e.g. hourly -> daily).
 func (a *TimelineAccumulator) ReaggregateTo(interval time.Duration) *TimelineAccumulator {
 	out := NewTimelineAccumulator(interval)
@@ -0,0 +1,84 @@
+package logparser
+
+import (
+	"path/filepath"
+	"time"
+)
+
+// WarmupStatus reports progress of the background cache warm-up and the
+// scheduled refresh of today's file.
+type WarmupStatus struct {
+	State       string     `json:"state"` // "pending", "running", "done" or "disabled"
+	FilesTotal  int        `json:"files_total"`
+	FilesDone   int        `json:"files_done"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	LastRefresh *time.Time `json:"last_refresh,omitempty"`
+}
+
+// Prewarm parses the log files of the last days days (including today) into
+// the cache. Today's file is loaded first since it backs the default
+// dashboard range; the rest go oldest to newest so that recent days are the
+// last to be evicted if the budget is tight. With days < 1 nothing is
+// loaded and the warm-up is reported as disabled.
+func (c *StatsCache) Prewarm(logDir string, days int) {
+	if days < 1 {
+		c.mu.Lock()
+		c.warmup.State = "disabled"
+		c.mu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	files := LogFilesForRange(logDir, today.AddDate(0, 0, -(days-1)), now)
+	if n := len(files); n > 1 {
+		files = append([]string{files[n-1]}, files[:n-1]...)
+	}
+
+	started := time.Now()
+	c.mu.Lock()
+	c.warmup.State = "running"
+	c.warmup.FilesTotal = len(files)
+	c.warmup.FilesDone = 0
+	c.warmup.StartedAt = &started
+	c.mu.Unlock()
+
+	for _, path := range files {
+		c.load(path)
+		c.mu.Lock()
+		c.warmup.FilesDone++
+		c.mu.Unlock()
+	}
+
+	finished := time.Now()
+	c.mu.Lock()
+	c.warmup.State = "done"
+	c.warmup.FinishedAt = &finished
+	c.mu.Unlock()
+}
+
+// KeepFresh reloads today's log file every interval so that requests for the
+// current day find an up-to-date accumulator. The file name is recomputed on
+// each tick, which also picks up the new file after midnight. It never returns.
+func (c *StatsCache) KeepFresh(logDir string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		path := filepath.Join(logDir, time.Now().Format("2006-01-02")+"_ALL.log")
+		if _, err := c.load(path); err != nil {
+			continue
+		}
+		refreshed := time.Now()
+		c.mu.Lock()
+		c.warmup.LastRefresh = &refreshed
+		c.mu.Unlock()
+	}
+}
+
+// Warmup returns the current warm-up and refresh status.
+func (c *StatsCache) Warmup() WarmupStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.warmup
+}
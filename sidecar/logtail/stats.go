@@ -0,0 +1,109 @@
+package logtail
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/JCHHeilmann/blocky-visor/sidecar/logparser"
+)
+
+// statsHistory is how far back a StatsFeed reads the logs on start, matching
+// the longest rolling window.
+const statsHistory = 15 * time.Minute
+
+// feedStats adds every entry from sub to rs until ctx is cancelled,
+// skipping those up to covered that seedStats already added.
+func (t *Tailer) feedStats(ctx context.Context, rs *logparser.RollingStats, sub *Subscription, covered logparser.Cursor) {
+	for {
+		select {
+		case <-ctx.Done():
+			t.Unsubscribe(sub)
+			return
+		case ev, ok := <-sub.C:
+			if !ok {
+				log.Printf("rolling stats: fell behind the log, some queries were not counted")
+				sub = t.Subscribe(nil)
+				continue
+			}
+			if ev.Day == covered.Day && ev.Offset <= covered.Offset {
+				continue
+			}
+			rs.Add(ev.Entry, t.now())
+		}
+	}
+}
+
+// StatsFeed feeds rolling stats only while someone reads them, so the
+// tailer can skip parsing lines when no client is connected.
+type StatsFeed struct {
+	t *Tailer
+
+	mu      sync.Mutex
+	readers int
+	rs      *logparser.RollingStats
+	stop    context.CancelFunc
+}
+
+// NewStatsFeed returns a feed of rolling stats from t's entries.
+func (t *Tailer) NewStatsFeed() *StatsFeed {
+	return &StatsFeed{t: t}
+}
+
+// Acquire returns the rolling stats, starting the feed with the recent
+// history if no one else is reading them. Call Release when done.
+func (f *StatsFeed) Acquire() *logparser.RollingStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.readers++
+	if f.readers == 1 {
+		f.rs = logparser.NewRollingStats()
+		sub := f.t.Subscribe(nil)
+		covered := f.t.seedStats(f.rs)
+		ctx, cancel := context.WithCancel(context.Background())
+		f.stop = cancel
+		go f.t.feedStats(ctx, f.rs, sub, covered)
+	}
+	return f.rs
+}
+
+// Release stops the feed once its last reader is gone.
+func (f *StatsFeed) Release() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.readers--
+	if f.readers == 0 {
+		f.stop()
+		f.rs, f.stop = nil, nil
+	}
+}
+
+// seedStats adds the entries of the last statsHistory from the log files,
+// newest first, and returns the position up to which they were read.
+func (t *Tailer) seedStats(rs *logparser.RollingStats) logparser.Cursor {
+	now := t.now()
+	today := now.Format("2006-01-02")
+	end, _ := logparser.CompleteLinesEnd(filepath.Join(t.logDir, today+"_ALL.log"))
+	from := logparser.Cursor{Day: today, Offset: end}
+
+	// Log timestamps are local wall-clock times parsed as UTC.
+	cutoff := now.Add(-statsHistory)
+	cutoff = time.Date(cutoff.Year(), cutoff.Month(), cutoff.Day(), cutoff.Hour(), cutoff.Minute(), cutoff.Second(), 0, time.UTC)
+	start := time.Date(now.Year(), now.Month(), now.Day()-1, 0, 0, 0, 0, time.Local)
+	err := logparser.ScanReverse(t.logDir, start, now, &from, func(e *logparser.LogEntry, _ logparser.Cursor) bool {
+		if e.Timestamp.Before(cutoff) {
+			return false
+		}
+		if t.resolve != nil {
+			e.ResolvedName = t.resolve(e.ClientIP)
+		}
+		rs.Add(e, now)
+		return true
+	})
+	if err != nil {
+		log.Printf("rolling stats: read history: %v", err)
+	}
+	return from
+}
@@ -0,0 +1,322 @@
+// Package logtail follows today's Blocky query log and broadcasts each new
+// entry to any number of subscribers.
+package logtail
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/JCHHeilmann/blocky-visor/sidecar/logparser"
+)
+
+const (
+	// rescanInterval is how often the log is checked when file
+	// notifications are available, as a safety net for missed events.
+	rescanInterval = 10 * time.Second
+	// rolloverGrace is how long yesterday's file is still followed after
+	// midnight, for lines Blocky writes to it late.
+	rolloverGrace = time.Minute
+	// maxLineLength bounds the partial line carried over between reads.
+	maxLineLength = 1 << 20
+
+	// subscriberBuffer is the number of events queued per subscriber.
+	subscriberBuffer = 256
+	// maxBacklog is how many consecutive events a subscriber may miss
+	// before it is disconnected.
+	maxBacklog = 4 * subscriberBuffer
+)
+
+// Event is one log entry together with its position in the day's file.
+// Entries are shared between subscribers and must not be modified.
+type Event struct {
+	Entry  *logparser.LogEntry
+	Day    string // "2006-01-02"
+	Offset int64  // byte offset just past the entry's line
+}
+
+// Subscription receives the events accepted by its match function. C is
+// closed when the subscription ends, either through Unsubscribe or because
+// the subscriber fell too far behind.
+type Subscription struct {
+	C <-chan Event
+
+	ch      chan Event
+	match   func(*logparser.LogEntry) bool
+	dropped atomic.Int64
+	backlog int // consecutive undelivered events, guarded by Tailer.mu
+}
+
+// Dropped returns the number of events skipped because the subscriber's
+// buffer was full since the last call.
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Swap(0)
+}
+
+// Tailer reads new lines from today's log file, parses and enriches each
+// one once and fans it out to all subscribers.
+type Tailer struct {
+	logDir   string
+	resolve  func(ip string) string
+	interval time.Duration // polling interval without file notifications
+	now      func() time.Time
+
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+// New creates a tailer for the logs in logDir. resolve, if not nil, sets
+// ResolvedName on each entry before it is matched and broadcast.
+func New(logDir string, resolve func(ip string) string) *Tailer {
+	return &Tailer{
+		logDir:   logDir,
+		resolve:  resolve,
+		interval: 500 * time.Millisecond,
+		now:      time.Now,
+		subs:     make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe registers a subscriber for entries accepted by match (all
+// entries if nil). Call Unsubscribe when done.
+func (t *Tailer) Subscribe(match func(*logparser.LogEntry) bool) *Subscription {
+	ch := make(chan Event, subscriberBuffer)
+	s := &Subscription{C: ch, ch: ch, match: match}
+	t.mu.Lock()
+	t.subs[s] = struct{}{}
+	t.mu.Unlock()
+	return s
+}
+
+func (t *Tailer) Unsubscribe(s *Subscription) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.subs[s]; ok {
+		delete(t.subs, s)
+		close(s.ch)
+	}
+}
+
+func (t *Tailer) subscribers() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.subs)
+}
+
+// broadcast delivers ev to every matching subscriber without blocking.
+func (t *Tailer) broadcast(ev Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for s := range t.subs {
+		if s.match != nil && !s.match(ev.Entry) {
+			continue
+		}
+		select {
+		case s.ch <- ev:
+			s.backlog = 0
+		default:
+			s.dropped.Add(1)
+			s.backlog++
+			if s.backlog > maxBacklog {
+				delete(t.subs, s)
+				close(s.ch)
+			}
+		}
+	}
+}
+
+// Run follows the log until ctx is cancelled, starting at the current end
+// of today's file. It wakes on file notifications where supported and
+// falls back to polling.
+func (t *Tailer) Run(ctx context.Context) {
+	wake := make(chan struct{}, 1)
+	poll := rescanInterval
+	stop, err := watchDir(t.logDir, wake)
+	if err != nil {
+		log.Printf("log tailer: %v; polling every %s", err, t.interval)
+		poll = t.interval
+	} else {
+		defer stop()
+	}
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+
+	cur := t.follow(t.day(), true)
+	var prev *followedFile
+	var prevUntil time.Time
+	defer func() {
+		cur.close()
+		prev.close()
+	}()
+
+	for {
+		if day := t.day(); day != cur.day {
+			// Midnight: finish the old file but keep an eye on it for a
+			// while in case Blocky still appends to it.
+			cur.drain()
+			prev.close()
+			prev, prevUntil = cur, t.now().Add(rolloverGrace)
+			cur = t.follow(day, false)
+		}
+		if prev != nil {
+			prev.drain()
+			if t.now().After(prevUntil) {
+				prev.close()
+				prev = nil
+			}
+		}
+
+		cur.drain()
+		if cur.replaced() {
+			cur.drain()
+			cur.close()
+			cur = t.follow(cur.day, false)
+			cur.drain()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (t *Tailer) day() string {
+	return t.now().Format("2006-01-02")
+}
+
+// followedFile is an open log file and the read position within it. The
+// handle is kept open so data written before a rename or rollover can still
+// be read.
+type followedFile struct {
+	t       *Tailer
+	day     string
+	path    string
+	f       *os.File
+	info    os.FileInfo
+	offset  int64  // end of the last complete line
+	partial []byte // bytes of an incomplete line after offset
+}
+
+// follow opens the day's log file, positioned at its end if fromEnd. A file
+// that does not exist yet is opened by drain once it appears.
+func (t *Tailer) follow(day string, fromEnd bool) *followedFile {
+	ff := &followedFile{t: t, day: day, path: filepath.Join(t.logDir, day+"_ALL.log")}
+	if ff.open() && fromEnd {
+		ff.offset = ff.info.Size()
+	}
+	return ff
+}
+
+func (ff *followedFile) open() bool {
+	f, err := os.Open(ff.path)
+	if err != nil {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return false
+	}
+	ff.f, ff.info = f, info
+	return true
+}
+
+// replaced reports whether the path now refers to a different file than
+// the one being read, e.g. after rotation by rename.
+func (ff *followedFile) replaced() bool {
+	if ff.f == nil {
+		return false
+	}
+	info, err := os.Stat(ff.path)
+	return err == nil && !os.SameFile(ff.info, info)
+}
+
+// close flushes a final unterminated line and closes the file. It is safe
+// to call on a nil file.
+func (ff *followedFile) close() {
+	if ff == nil || ff.f == nil {
+		return
+	}
+	if len(ff.partial) > 0 {
+		ff.offset += int64(len(ff.partial))
+		ff.emit(ff.partial, ff.offset)
+		ff.partial = nil
+	}
+	ff.f.Close()
+	ff.f = nil
+}
+
+// drain broadcasts every complete line appended since the last call.
+func (ff *followedFile) drain() {
+	if ff.f == nil && !ff.open() {
+		return
+	}
+	if info, err := ff.f.Stat(); err == nil && info.Size() < ff.offset+int64(len(ff.partial)) {
+		// Truncated in place
+		ff.offset, ff.partial = 0, nil
+	}
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := ff.f.ReadAt(buf, ff.offset+int64(len(ff.partial)))
+		if n > 0 {
+			ff.consume(buf[:n])
+		}
+		if err != nil || n < len(buf) {
+			if err != nil && err != io.EOF {
+				log.Printf("log tailer: read %s: %v", filepath.Base(ff.path), err)
+			}
+			return
+		}
+	}
+}
+
+// consume splits data, prefixed by any carried-over partial line, into
+// lines and keeps the incomplete remainder for the next read.
+func (ff *followedFile) consume(data []byte) {
+	if len(ff.partial) > 0 {
+		data = append(ff.partial, data...)
+	}
+	skip := ff.t.subscribers() == 0
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		ff.offset += int64(i + 1)
+		if !skip {
+			ff.emit(data[:i], ff.offset)
+		}
+		data = data[i+1:]
+	}
+	if len(data) > maxLineLength {
+		// Not a log line; skip it rather than buffer without limit.
+		ff.offset += int64(len(data))
+		data = nil
+	}
+	ff.partial = append(ff.partial[:0:0], data...)
+}
+
+func (ff *followedFile) emit(line []byte, end int64) {
+	line = bytes.TrimRight(line, "\r")
+	if len(line) == 0 {
+		return
+	}
+	entry, err := logparser.ParseLine(string(line))
+	if err != nil {
+		return
+	}
+	if ff.t.resolve != nil {
+		entry.ResolvedName = ff.t.resolve(entry.ClientIP)
+	}
+	ff.t.broadcast(Event{Entry: entry, Day: ff.day, Offset: end})
+}
@@ -0,0 +1,268 @@
+package logtail
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/JCHHeilmann/blocky-visor/sidecar/logparser"
+)
+
+func logLine(client, domain string) string {
+	return fmt.Sprintf("%s\t%s\tPC\t5\tRESOLVED\t%s\tA (1.2.3.4)\tNOERROR\tRESOLVED\tA\tblocky\n",
+		time.Now().Format("2006-01-02 15:04:05"), client, domain)
+}
+
+func appendLines(t *testing.T, path string, lines ...string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(strings.Join(lines, "")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func receive(t *testing.T, s *Subscription) Event {
+	t.Helper()
+	select {
+	case ev, ok := <-s.C:
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		return ev
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	return Event{}
+}
+
+func TestTailerFanOut(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, time.Now().Format("2006-01-02")+"_ALL.log")
+	appendLines(t, path, logLine("10.0.0.9", "old.com."))
+
+	resolved := 0
+	tl := New(dir, func(ip string) string { resolved++; return "host-" + ip })
+	tl.interval = 10 * time.Millisecond
+
+	all := tl.Subscribe(nil)
+	tv := tl.Subscribe(func(e *logparser.LogEntry) bool { return e.ClientIP == "10.0.0.2" })
+	defer tl.Unsubscribe(all)
+	defer tl.Unsubscribe(tv)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tl.Run(ctx)
+	time.Sleep(30 * time.Millisecond)
+
+	appendLines(t, path, logLine("10.0.0.1", "a.com."), logLine("10.0.0.2", "b.com."))
+
+	ev := receive(t, all)
+	if ev.Entry.Domain != "a.com." || ev.Entry.ResolvedName != "host-10.0.0.1" {
+		t.Errorf("first event = %+v", ev.Entry)
+	}
+	if second := receive(t, all); second.Entry != receive(t, tv).Entry {
+		t.Error("subscribers received different entry instances")
+	}
+	if info, _ := os.Stat(path); ev.Offset >= info.Size() {
+		t.Errorf("offset %d not inside file of %d bytes", ev.Offset, info.Size())
+	}
+	if resolved != 2 {
+		t.Errorf("resolved %d times, want once per line", resolved)
+	}
+}
+
+func TestTailerDropsSlowSubscriber(t *testing.T) {
+	tl := New(t.TempDir(), nil)
+	slow := tl.Subscribe(nil)
+	fast := tl.Subscribe(nil)
+
+	entry := &logparser.LogEntry{Domain: "x.com."}
+	for i := 0; i < subscriberBuffer+10; i++ {
+		tl.broadcast(Event{Entry: entry})
+		<-fast.C
+	}
+	if got := slow.Dropped(); got != 10 {
+		t.Errorf("Dropped() = %d, want 10", got)
+	}
+	if got := slow.Dropped(); got != 0 {
+		t.Errorf("Dropped() after reset = %d, want 0", got)
+	}
+
+	for i := 0; i < maxBacklog; i++ {
+		tl.broadcast(Event{Entry: entry})
+		<-fast.C
+	}
+	for range slow.C {
+	}
+	if n := tl.subscribers(); n != 1 {
+		t.Errorf("subscribers = %d, want 1 after dropping the slow one", n)
+	}
+	tl.Unsubscribe(slow) // no-op, must not panic
+}
+
+func TestTailerPartialLines(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, time.Now().Format("2006-01-02")+"_ALL.log")
+	appendLines(t, path)
+
+	tl := New(dir, nil)
+	tl.interval = 10 * time.Millisecond
+	sub := tl.Subscribe(nil)
+	defer tl.Unsubscribe(sub)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tl.Run(ctx)
+	time.Sleep(30 * time.Millisecond)
+
+	line := logLine("10.0.0.1", "split.com.")
+	appendLines(t, path, line[:20])
+	time.Sleep(50 * time.Millisecond)
+	appendLines(t, path, line[20:], logLine("10.0.0.1", "next.com."))
+
+	if ev := receive(t, sub); ev.Entry.Domain != "split.com." || ev.Offset != int64(len(line)) {
+		t.Errorf("event = %s at %d, want split.com. at %d", ev.Entry.Domain, ev.Offset, len(line))
+	}
+	if ev := receive(t, sub); ev.Entry.Domain != "next.com." {
+		t.Errorf("second event = %s, want next.com.", ev.Entry.Domain)
+	}
+}
+
+func TestTailerReplacedFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, time.Now().Format("2006-01-02")+"_ALL.log")
+	appendLines(t, path, logLine("10.0.0.1", "before.com."))
+
+	tl := New(dir, nil)
+	tl.interval = 10 * time.Millisecond
+	sub := tl.Subscribe(nil)
+	defer tl.Unsubscribe(sub)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tl.Run(ctx)
+	time.Sleep(30 * time.Millisecond)
+
+	// Rotate by rename: the old file still gets a line, and a new, larger
+	// file takes its place.
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendLines(t, path+".1", logLine("10.0.0.1", "late.com."))
+	appendLines(t, path, logLine("10.0.0.1", "new1.com."), logLine("10.0.0.1", "new2.com."), logLine("10.0.0.1", "new3.com."))
+
+	for _, want := range []string{"late.com.", "new1.com.", "new2.com.", "new3.com."} {
+		if ev := receive(t, sub); ev.Entry.Domain != want {
+			t.Errorf("event = %s, want %s", ev.Entry.Domain, want)
+		}
+	}
+}
+
+func TestTailerMidnightRollover(t *testing.T) {
+	dir := t.TempDir()
+	day1 := time.Date(2026, 2, 14, 23, 59, 58, 0, time.Local)
+	path1 := filepath.Join(dir, "2026-02-14_ALL.log")
+	path2 := filepath.Join(dir, "2026-02-15_ALL.log")
+	appendLines(t, path1)
+
+	var clock atomic.Int64
+	clock.Store(day1.UnixNano())
+	tl := New(dir, nil)
+	tl.interval = 10 * time.Millisecond
+	tl.now = func() time.Time { return time.Unix(0, clock.Load()) }
+	sub := tl.Subscribe(nil)
+	defer tl.Unsubscribe(sub)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tl.Run(ctx)
+	time.Sleep(30 * time.Millisecond)
+
+	clock.Store(day1.Add(5 * time.Second).UnixNano())
+	appendLines(t, path1, logLine("10.0.0.1", "last.com."))
+	appendLines(t, path2, logLine("10.0.0.1", "first.com."))
+
+	got := map[string]string{}
+	for range 2 {
+		ev := receive(t, sub)
+		got[ev.Entry.Domain] = ev.Day
+	}
+	if got["last.com."] != "2026-02-14" || got["first.com."] != "2026-02-15" {
+		t.Errorf("events by day = %v", got)
+	}
+}
+
+func TestStatsFeed(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, time.Now().Format("2006-01-02")+"_ALL.log")
+	old := strings.Replace(logLine("10.0.0.9", "old.com."), time.Now().Format("2006-01-02 15:04:05"),
+		time.Now().Add(-20*time.Minute).Format("2006-01-02 15:04:05"), 1)
+	appendLines(t, path, old, logLine("10.0.0.1", "a.com."), logLine("10.0.0.1", "a.com."))
+
+	tl := New(dir, nil)
+	tl.interval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tl.Run(ctx)
+	feed := tl.NewStatsFeed()
+	rs := feed.Acquire()
+	defer feed.Release()
+	time.Sleep(50 * time.Millisecond)
+
+	appendLines(t, path, logLine("10.0.0.2", "b.com."))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		w := rs.Snapshot(time.Now()).Windows["15m"]
+		if w.Queries == 3 {
+			if len(w.TopDomains) != 2 || w.TopDomains[0].Domain != "a.com." {
+				t.Errorf("top domains = %+v", w.TopDomains)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("15m queries = %d, want 3 (2 from history, 1 live)", w.Queries)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStatsFeedOnlyWhileRead(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, time.Now().Format("2006-01-02")+"_ALL.log")
+	appendLines(t, path, logLine("10.0.0.1", "a.com."))
+
+	tl := New(dir, nil)
+	feed := tl.NewStatsFeed()
+	if n := tl.subscribers(); n != 0 {
+		t.Fatalf("subscribers before Acquire = %d, want 0", n)
+	}
+
+	rs := feed.Acquire()
+	if got := feed.Acquire(); got != rs {
+		t.Error("second reader got different stats")
+	}
+	if w := rs.Snapshot(time.Now()).Windows["15m"]; w.Queries != 1 {
+		t.Errorf("15m queries = %d, want 1 from history", w.Queries)
+	}
+	if n := tl.subscribers(); n != 1 {
+		t.Errorf("subscribers while read = %d, want 1", n)
+	}
+
+	feed.Release()
+	feed.Release()
+	deadline := time.Now().Add(2 * time.Second)
+	for tl.subscribers() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("feed still subscribed after the last Release")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
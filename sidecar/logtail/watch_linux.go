@@ -0,0 +1,42 @@
+//go:build linux
+
+package logtail
+
+import (
+	"os"
+	"syscall"
+)
+
+// watchDir signals wake whenever an entry in dir is created, written,
+// renamed or removed. The returned function stops watching.
+func watchDir(dir string, wake chan<- struct{}) (func(), error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	const mask = syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE | syscall.IN_CREATE |
+		syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+	if _, err := syscall.InotifyAddWatch(fd, dir, mask); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("inotify_add_watch", err)
+	}
+
+	// A non-blocking fd is registered with the runtime poller, so Close
+	// unblocks the pending Read.
+	f := os.NewFile(uintptr(fd), "inotify")
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			// The events themselves are not needed: any change in the
+			// directory makes the tailer re-check its files.
+			if _, err := f.Read(buf); err != nil {
+				return
+			}
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return func() { f.Close() }, nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package logtail
+
+import "errors"
+
+func watchDir(dir string, wake chan<- struct{}) (func(), error) {
+	return nil, errors.New("file notifications are not supported on this platform")
+}
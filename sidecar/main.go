@@ -1,15 +1,22 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"path/filepath"
+	"time"
 
+	"github.com/JCHHeilmann/blocky-visor/sidecar/blocky"
 	"github.com/JCHHeilmann/blocky-visor/sidecar/handler"
 	"github.com/JCHHeilmann/blocky-visor/sidecar/logparser"
+	"github.com/JCHHeilmann/blocky-visor/sidecar/logtail"
 	"github.com/JCHHeilmann/blocky-visor/sidecar/middleware"
 	"github.com/JCHHeilmann/blocky-visor/sidecar/resolver"
+	"github.com/JCHHeilmann/blocky-visor/sidecar/savedsearch"
+	"github.com/JCHHeilmann/blocky-visor/sidecar/tempallow"
 	"github.com/go-chi/chi/v5"
 	chimw "github.com/go-chi/chi/v5/middleware"
 )
@@ -23,31 +30,99 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
+	searches, err := savedsearch.Open(filepath.Join(cfg.DataDir, "saved-searches.json"))
+	if err != nil {
+		log.Fatalf("Failed to load saved searches: %v", err)
+	}
+
+	retention := blocky.RetentionPolicy{
+		Keep:   cfg.Backups.Keep,
+		MaxAge: time.Duration(cfg.Backups.MaxAgeDays) * 24 * time.Hour,
+	}
+	if _, err := blocky.PruneBackups(cfg.Blocky.ConfigPath, retention); err != nil {
+		log.Printf("Failed to prune config backups: %v", err)
+	}
+
+	tempAllows, err := tempallow.Open(filepath.Join(cfg.DataDir, "temp-allowlist.json"),
+		cfg.Blocky.ConfigPath, cfg.Blocky.ServiceName, retention)
+	if err != nil {
+		log.Fatalf("Failed to load temporary allowlist entries: %v", err)
+	}
+	go tempAllows.Run(context.Background())
+
 	r := chi.NewRouter()
 	r.Use(chimw.Logger)
 	r.Use(chimw.Recoverer)
 	r.Use(middleware.CORS(cfg.CORSOrigins))
 
+	statsCache := logparser.NewBoundedStatsCache(int64(cfg.StatsCache.MaxMB) << 20)
+	go statsCache.Prewarm(cfg.Blocky.LogDir, cfg.StatsCache.PrewarmDays)
+	if cfg.StatsCache.RefreshInterval > 0 {
+		go statsCache.KeepFresh(cfg.Blocky.LogDir, cfg.StatsCache.RefreshInterval)
+	}
+
+	hostResolver := resolver.New(cfg.DNSResolver)
+	searchIndex := logparser.NewIndexer(cfg.Blocky.LogDir, cfg.SearchIndex.Dir)
+	go searchIndex.Build(cfg.SearchIndex.BuildDays)
+	tailer := logtail.New(cfg.Blocky.LogDir, hostResolver.Lookup)
+	go tailer.Run(context.Background())
+	liveStats := tailer.NewStatsFeed()
+
 	// Health check — no auth
-	r.Get("/api/health", handler.Health)
+	r.Get("/api/health", handler.Health(statsCache))
+
+	// WebSocket live log — authenticates during the handshake
+	r.Get("/api/logs/ws", handler.LiveLogsWS(cfg.APIKey, cfg.Blocky.LogDir, searchIndex, tailer, hostResolver, searches))
 
 	// Authenticated routes
 	r.Group(func(r chi.Router) {
 		r.Use(middleware.APIKeyAuth(cfg.APIKey))
 
 		r.Get("/api/config", handler.GetConfig(cfg.Blocky.ConfigPath))
-		r.Put("/api/config", handler.PutConfig(cfg.Blocky.ConfigPath))
+		r.Put("/api/config", handler.PutConfig(cfg.Blocky.ConfigPath, retention))
+		r.Post("/api/config/diff", handler.DiffConfig(cfg.Blocky.ConfigPath))
+		r.Get("/api/config/sections/{path}", handler.GetSection(cfg.Blocky.ConfigPath))
+		r.Patch("/api/config/sections/{path}", handler.PatchSection(cfg.Blocky.ConfigPath, retention))
+		r.Get("/api/config/backups", handler.ListBackups(cfg.Blocky.ConfigPath))
+		r.Get("/api/config/backups/{id}", handler.GetBackup(cfg.Blocky.ConfigPath))
+		r.Get("/api/config/backups/{id}/diff", handler.DiffBackup(cfg.Blocky.ConfigPath))
+		r.Post("/api/config/backups/{id}/restore", handler.RestoreBackup(cfg.Blocky.ConfigPath, retention))
+
+		r.Get("/api/lists", handler.GetLists(cfg.Blocky.ConfigPath))
+		r.Get("/api/lists/temporary", handler.ListTempAllows(tempAllows))
+		r.Post("/api/lists/temporary", handler.AddTempAllow(tempAllows, cfg.Blocky.ConfigPath, cfg.Blocky.ServiceName))
+		r.Delete("/api/lists/temporary/{id}", handler.CancelTempAllow(tempAllows, cfg.Blocky.ConfigPath, cfg.Blocky.ServiceName))
+		r.Post("/api/lists/{kind}/{group}", handler.AddListEntry(cfg.Blocky.ConfigPath, cfg.Blocky.ServiceName, retention))
+		r.Delete("/api/lists/{kind}/{group}", handler.RemoveListEntry(cfg.Blocky.ConfigPath, cfg.Blocky.ServiceName, retention))
+
+		r.Get("/api/clients/groups", handler.GetClientGroups(cfg.Blocky.ConfigPath))
+		r.Put("/api/clients/groups", handler.SetClientGroups(cfg.Blocky.ConfigPath, cfg.Blocky.ServiceName, retention))
+		r.Delete("/api/clients/groups", handler.RemoveClientGroups(cfg.Blocky.ConfigPath, cfg.Blocky.ServiceName, retention))
+		r.Get("/api/clients/groups/match", handler.MatchClientGroups(cfg.Blocky.ConfigPath, hostResolver))
 
 		r.Get("/api/service/status", handler.ServiceStatus(cfg.Blocky.ServiceName))
 		r.Post("/api/service/restart", handler.ServiceRestart(cfg.Blocky.ServiceName))
 
-		statsCache := logparser.NewStatsCache()
 		r.Get("/api/stats", handler.GetStats(cfg.Blocky.LogDir, statsCache))
 		r.Get("/api/stats/timeline", handler.GetTimeline(cfg.Blocky.LogDir, statsCache))
+		r.Get("/api/stats/cache", handler.GetStatsCache(statsCache))
+		r.Get("/api/stats/live", handler.StreamStats(liveStats))
+
+		r.Get("/api/searches", handler.ListSearches(searches))
+		r.Post("/api/searches", handler.CreateSearch(searches))
+		r.Get("/api/searches/{id}", handler.GetSearch(searches))
+		r.Put("/api/searches/{id}", handler.UpdateSearch(searches))
+		r.Delete("/api/searches/{id}", handler.DeleteSearch(searches))
+
+		withSearch := r.With(handler.WithSavedSearch(searches))
+		withSearch.Get("/api/logs", handler.GetLogs(searchIndex, hostResolver))
+		r.Get("/api/logs/context", handler.GetLogContext(cfg.Blocky.LogDir, hostResolver))
+		withSearch.Get("/api/logs/export", handler.ExportLogs(cfg.Blocky.LogDir, hostResolver))
+		withSearch.Get("/api/logs/stream", handler.StreamLogs(cfg.Blocky.LogDir, tailer, hostResolver, cfg.Stream.MaxReplay, cfg.Stream.Heartbeat))
 
-		hostResolver := resolver.New(cfg.DNSResolver)
-		r.Get("/api/logs", handler.GetLogs(cfg.Blocky.LogDir, hostResolver))
-		r.Get("/api/logs/stream", handler.StreamLogs(cfg.Blocky.LogDir, hostResolver))
+		passiveDNS := logparser.NewPassiveDNS(cfg.Blocky.LogDir)
+		go passiveDNS.Refresh()
+		r.Get("/api/passive-dns", handler.GetPassiveDNS(passiveDNS, hostResolver))
 	})
 
 	fmt.Printf("Blocky Visor sidecar listening on %s\n", cfg.Listen)
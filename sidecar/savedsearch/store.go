@@ -0,0 +1,185 @@
+// Package savedsearch stores named log searches in a local JSON file so
+// they survive reloads and can be shared by ID.
+package savedsearch
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/JCHHeilmann/blocky-visor/sidecar/internal/fsutil"
+	"github.com/JCHHeilmann/blocky-visor/sidecar/logparser"
+)
+
+var ErrNotFound = errors.New("saved search not found")
+
+// Search is a named log view: a search expression, a time range and the
+// columns to show.
+type Search struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Query     string    `json:"query"`
+	Range     string    `json:"range,omitempty"`
+	Columns   []string  `json:"columns,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+var validRanges = map[string]bool{"": true, "today": true, "yesterday": true, "7d": true, "30d": true}
+
+// Validate checks the user-editable fields.
+func (s Search) Validate() error {
+	if s.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(s.Name) > 100 {
+		return errors.New("name is longer than 100 characters")
+	}
+	if _, err := logparser.ParseQuery(s.Query); err != nil {
+		return err
+	}
+	if !validRanges[s.Range] {
+		return fmt.Errorf("invalid range %q", s.Range)
+	}
+	if len(s.Columns) > 32 {
+		return errors.New("too many columns")
+	}
+	for _, c := range s.Columns {
+		if c == "" || len(c) > 64 {
+			return fmt.Errorf("invalid column %q", c)
+		}
+	}
+	return nil
+}
+
+// Store keeps saved searches in memory and persists every change to path.
+type Store struct {
+	path string
+
+	mu       sync.RWMutex
+	searches map[string]Search
+}
+
+// Open loads the searches saved in path. A missing file is an empty store.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, searches: make(map[string]Search)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read saved searches: %w", err)
+	}
+	var list []Search
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("parse saved searches: %w", err)
+	}
+	for _, search := range list {
+		s.searches[search.ID] = search
+	}
+	return s, nil
+}
+
+// List returns all searches ordered by name.
+func (s *Store) List() []Search {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sorted(s.searches)
+}
+
+func (s *Store) Get(id string) (Search, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	search, ok := s.searches[id]
+	if !ok {
+		return Search{}, ErrNotFound
+	}
+	return search, nil
+}
+
+// Create saves a new search under a fresh random ID.
+func (s *Store) Create(in Search) (Search, error) {
+	id, err := newID()
+	if err != nil {
+		return Search{}, err
+	}
+	now := time.Now().UTC()
+	in.ID, in.CreatedAt, in.UpdatedAt = id, now, now
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return in, s.commit(id, &in)
+}
+
+// Update replaces the editable fields of an existing search.
+func (s *Store) Update(id string, in Search) (Search, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.searches[id]
+	if !ok {
+		return Search{}, ErrNotFound
+	}
+	in.ID, in.CreatedAt, in.UpdatedAt = id, old.CreatedAt, time.Now().UTC()
+	return in, s.commit(id, &in)
+}
+
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.searches[id]; !ok {
+		return ErrNotFound
+	}
+	return s.commit(id, nil)
+}
+
+// commit writes the store with search id set (or removed if nil) and only
+// then applies the change in memory. Caller must hold s.mu.
+func (s *Store) commit(id string, search *Search) error {
+	next := make(map[string]Search, len(s.searches)+1)
+	for k, v := range s.searches {
+		next[k] = v
+	}
+	if search != nil {
+		next[id] = *search
+	} else {
+		delete(next, id)
+	}
+
+	data, err := json.MarshalIndent(s.sorted(next), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := fsutil.WriteFileAtomic(s.path, data, 0600); err != nil {
+		return fmt.Errorf("save searches: %w", err)
+	}
+	s.searches = next
+	return nil
+}
+
+func (s *Store) sorted(m map[string]Search) []Search {
+	list := make([]Search, 0, len(m))
+	for _, search := range m {
+		list = append(list, search)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name != list[j].Name {
+			return list[i].Name < list[j].Name
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
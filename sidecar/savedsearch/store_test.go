@@ -0,0 +1,75 @@
+package savedsearch
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestStoreCRUD(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "saved-searches.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tv, err := s.Create(Search{Name: "TV noise", Query: "client:tv type:blocked", Range: "7d", Columns: []string{"time", "domain"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tv.ID) != 16 || tv.CreatedAt.IsZero() {
+		t.Errorf("created = %+v", tv)
+	}
+	if _, err := s.Create(Search{Name: "Ads", Query: "reason:BLOCKED*"}); err != nil {
+		t.Fatal(err)
+	}
+
+	updated, err := s.Update(tv.ID, Search{Name: "TV", Query: "client:tv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Name != "TV" || !updated.CreatedAt.Equal(tv.CreatedAt) || updated.Range != "" {
+		t.Errorf("updated = %+v", updated)
+	}
+	if _, err := s.Update("missing", Search{Name: "x"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Update(missing) err = %v, want ErrNotFound", err)
+	}
+
+	// Reopen from disk.
+	s2, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := s2.List()
+	if len(list) != 2 || list[0].Name != "Ads" || list[1].ID != tv.ID || list[1].Query != "client:tv" {
+		t.Fatalf("reloaded = %+v", list)
+	}
+
+	if err := s2.Delete(tv.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s2.Get(tv.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after delete err = %v, want ErrNotFound", err)
+	}
+	if err := s2.Delete(tv.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Delete err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestSearchValidate(t *testing.T) {
+	tests := []struct {
+		s  Search
+		ok bool
+	}{
+		{Search{Name: "ok", Query: "domain:example.com"}, true},
+		{Search{Query: "x"}, false},
+		{Search{Name: "bad query", Query: "domain:(x"}, false},
+		{Search{Name: "bad range", Range: "90d"}, false},
+		{Search{Name: "bad column", Columns: []string{""}}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.s.Validate(); (err == nil) != tt.ok {
+			t.Errorf("Validate(%+v) = %v, want ok=%v", tt.s, err, tt.ok)
+		}
+	}
+}
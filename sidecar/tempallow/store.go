@@ -0,0 +1,363 @@
+// Package tempallow adds allowlist entries that are removed again when
+// they expire. Active entries are kept in a local JSON file, so they are
+// reverted even if the sidecar was not running when they expired.
+package tempallow
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JCHHeilmann/blocky-visor/sidecar/blocky"
+	"github.com/JCHHeilmann/blocky-visor/sidecar/internal/fsutil"
+)
+
+var (
+	ErrNotFound = errors.New("temporary entry not found")
+	// ErrAlreadyAllowed is returned for an entry that is already on the
+	// allowlist, which expiring would otherwise remove.
+	ErrAlreadyAllowed = errors.New("entry is already on the allowlist")
+)
+
+// Limits of the duration of a temporary entry.
+const (
+	MinDuration = time.Minute
+	MaxDuration = 7 * 24 * time.Hour
+)
+
+// retryDelay is how long a failed revert or apply waits before it is tried
+// again.
+const retryDelay = time.Minute
+
+// Entry is an allowlist entry that is removed at ExpiresAt. Apply is how
+// the removal is applied to Blocky.
+type Entry struct {
+	ID        string    `json:"id"`
+	Entry     string    `json:"entry"`
+	Group     string    `json:"group"`
+	Source    string    `json:"source"`
+	Apply     string    `json:"apply,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (e Entry) same(entry, group, source string) bool {
+	return strings.EqualFold(e.Entry, entry) && e.Group == group && e.Source == source
+}
+
+// Store keeps active temporary entries in memory, persists every change to
+// path, and reverts entries in the Blocky config at configPath as they
+// expire.
+type Store struct {
+	path        string
+	configPath  string
+	serviceName string
+	retention   blocky.RetentionPolicy
+
+	mu         sync.Mutex
+	entries    map[string]Entry
+	retry      map[string]time.Time
+	applyMode  string    // apply that failed and is retried at applyRetry
+	applyRetry time.Time // zero if none is pending
+	wake       chan struct{}
+}
+
+// Open loads the entries saved in path. A missing file is an empty store.
+func Open(path, configPath, serviceName string, retention blocky.RetentionPolicy) (*Store, error) {
+	s := &Store{
+		path:        path,
+		configPath:  configPath,
+		serviceName: serviceName,
+		retention:   retention,
+		entries:     make(map[string]Entry),
+		retry:       make(map[string]time.Time),
+		wake:        make(chan struct{}, 1),
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read temporary entries: %w", err)
+	}
+	var list []Entry
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("parse temporary entries: %w", err)
+	}
+	for _, e := range list {
+		s.entries[e.ID] = e
+	}
+	return s, nil
+}
+
+// List returns the active entries, soonest to expire first.
+func (s *Store) List() []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sorted(s.entries)
+}
+
+func (s *Store) Get(id string) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[id]
+	if !ok {
+		return Entry{}, ErrNotFound
+	}
+	return e, nil
+}
+
+// Add puts entry on the allowlist of group for d. Adding an entry that is
+// already active extends it instead, putting it back on the allowlist if it
+// was removed by hand. The caller applies the change to Blocky; apply is
+// recorded for when the entry expires.
+func (s *Store) Add(entry, group, source, apply string, d time.Duration) (Entry, error) {
+	if d < MinDuration || d > MaxDuration {
+		return Entry{}, fmt.Errorf("duration must be between %v and %v", MinDuration, MaxDuration)
+	}
+	if group == "" {
+		return Entry{}, errors.New("group is required")
+	}
+	if source == "" {
+		source = blocky.SourceInline
+	}
+	entry = strings.TrimSpace(entry)
+	now := time.Now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, e := range s.entries {
+		if e.same(entry, group, source) {
+			edit, err := blocky.AddListEntry(s.configPath, blocky.Allowlist, group, entry, source)
+			if err != nil {
+				return Entry{}, err
+			}
+			if edit.Changed {
+				s.prune(edit.File)
+			}
+			e.ExpiresAt = now.Add(d)
+			if apply != "" {
+				e.Apply = apply
+			}
+			if err := s.commit(id, &e); err != nil {
+				return Entry{}, err
+			}
+			s.signal()
+			return e, nil
+		}
+	}
+
+	id, err := newID()
+	if err != nil {
+		return Entry{}, err
+	}
+	edit, err := blocky.AddListEntry(s.configPath, blocky.Allowlist, group, entry, source)
+	if err != nil {
+		return Entry{}, err
+	}
+	if !edit.Changed {
+		return Entry{}, ErrAlreadyAllowed
+	}
+	s.prune(edit.File)
+
+	e := Entry{ID: id, Entry: entry, Group: group, Source: source, Apply: apply, CreatedAt: now, ExpiresAt: now.Add(d)}
+	if err := s.commit(id, &e); err != nil {
+		// Without a record the entry would never expire, so take it back.
+		if _, rerr := blocky.RemoveListEntry(s.configPath, blocky.Allowlist, group, entry, source); rerr != nil {
+			log.Printf("temporary allowlist: undo %s: %v", entry, rerr)
+		}
+		return Entry{}, err
+	}
+	s.signal()
+	return e, nil
+}
+
+// Cancel removes an entry from the allowlist before it expires and returns
+// it. The caller applies the change to Blocky.
+func (s *Store) Cancel(id string) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[id]
+	if !ok {
+		return Entry{}, ErrNotFound
+	}
+	if err := s.revert(e); err != nil {
+		return Entry{}, err
+	}
+	delete(s.retry, id)
+	if err := s.commit(id, nil); err != nil {
+		return Entry{}, err
+	}
+	s.signal()
+	return e, nil
+}
+
+// Run reverts entries as they expire, starting with those that expired
+// while the sidecar was stopped, until ctx is done.
+func (s *Store) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-s.wake:
+		}
+		next := s.expire(time.Now())
+		timer.Stop()
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+		}
+	}
+}
+
+// expire reverts the entries due at now, applies the changes to Blocky and
+// returns when the next entry or retry is due. A failed apply is retried
+// like a failed revert.
+func (s *Store) expire(now time.Time) time.Time {
+	s.mu.Lock()
+	modes := make(map[string]bool)
+	for _, e := range sorted(s.entries) {
+		if e.ExpiresAt.After(now) || s.retry[e.ID].After(now) {
+			continue
+		}
+		if err := s.revert(e); err != nil {
+			log.Printf("temporary allowlist: revert %s: %v", e.Entry, err)
+			s.retry[e.ID] = now.Add(retryDelay)
+			continue
+		}
+		if err := s.commit(e.ID, nil); err != nil {
+			// The entry is off the allowlist but still recorded; retry
+			// later rather than on every wake.
+			log.Printf("temporary allowlist: %v", err)
+			s.retry[e.ID] = now.Add(retryDelay)
+			modes[e.Apply] = true
+			continue
+		}
+		delete(s.retry, e.ID)
+		log.Printf("temporary allowlist: %s expired in group %s", e.Entry, e.Group)
+		modes[e.Apply] = true
+	}
+	if s.applyMode != "" && (len(modes) > 0 || !s.applyRetry.After(now)) {
+		modes[s.applyMode] = true
+		s.applyMode, s.applyRetry = "", time.Time{}
+	}
+	var next time.Time
+	for _, e := range s.entries {
+		due := e.ExpiresAt
+		if r := s.retry[e.ID]; r.After(due) {
+			due = r
+		}
+		if next.IsZero() || due.Before(next) {
+			next = due
+		}
+	}
+	if s.applyMode != "" && (next.IsZero() || s.applyRetry.Before(next)) {
+		next = s.applyRetry
+	}
+	s.mu.Unlock()
+
+	// A restart also reloads the lists, so one is enough.
+	mode := ""
+	if modes[blocky.ApplyRestart] {
+		mode = blocky.ApplyRestart
+	} else if modes[blocky.ApplyReload] {
+		mode = blocky.ApplyReload
+	}
+	if err := blocky.ApplyListChange(s.configPath, s.serviceName, mode); err != nil {
+		log.Printf("temporary allowlist: apply: %v", err)
+		retry := now.Add(retryDelay)
+		s.mu.Lock()
+		s.applyMode, s.applyRetry = mode, retry
+		s.mu.Unlock()
+		if next.IsZero() || retry.Before(next) {
+			next = retry
+		}
+	}
+	return next
+}
+
+// revert takes an entry off the allowlist. An entry that is already gone,
+// for example because it was removed by hand, counts as reverted.
+func (s *Store) revert(e Entry) error {
+	edits, err := blocky.RemoveListEntry(s.configPath, blocky.Allowlist, e.Group, e.Entry, e.Source)
+	if errors.Is(err, blocky.ErrEntryNotFound) || errors.Is(err, blocky.ErrListSourceNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, edit := range edits {
+		s.prune(edit.File)
+	}
+	return nil
+}
+
+func (s *Store) prune(path string) {
+	if _, err := blocky.PruneBackups(path, s.retention); err != nil {
+		log.Printf("temporary allowlist: prune backups: %v", err)
+	}
+}
+
+func (s *Store) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// commit writes the store with entry id set (or removed if nil) and only
+// then applies the change in memory. Caller must hold s.mu.
+func (s *Store) commit(id string, e *Entry) error {
+	next := make(map[string]Entry, len(s.entries)+1)
+	for k, v := range s.entries {
+		next[k] = v
+	}
+	if e != nil {
+		next[id] = *e
+	} else {
+		delete(next, id)
+	}
+
+	data, err := json.MarshalIndent(sorted(next), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := fsutil.WriteFileAtomic(s.path, data, 0600); err != nil {
+		return fmt.Errorf("save temporary entries: %w", err)
+	}
+	s.entries = next
+	return nil
+}
+
+func sorted(m map[string]Entry) []Entry {
+	list := make([]Entry, 0, len(m))
+	for _, e := range m {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].ExpiresAt.Equal(list[j].ExpiresAt) {
+			return list[i].ExpiresAt.Before(list[j].ExpiresAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,232 @@
+package tempallow
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JCHHeilmann/blocky-visor/sidecar/blocky"
+)
+
+const config = `blocking:
+  denylists:
+    default:
+      - https://a.example/deny.txt
+  allowlists:
+    default:
+      - |
+        always.example.com
+`
+
+func setup(t *testing.T) (configPath, storePath string) {
+	t.Helper()
+	dir := t.TempDir()
+	configPath = filepath.Join(dir, "config.yml")
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return configPath, filepath.Join(dir, "data", "temp-allowlist.json")
+}
+
+func allowed(t *testing.T, configPath, entry string) bool {
+	t.Helper()
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Contains(string(data), entry)
+}
+
+func TestAddExpireAcrossRestart(t *testing.T) {
+	configPath, storePath := setup(t)
+	s, err := Open(storePath, configPath, "blocky", blocky.RetentionPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := s.Add("shop.example.com", "default", "", "", 30*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Source != blocky.SourceInline || time.Until(e.ExpiresAt) < 29*time.Minute {
+		t.Errorf("entry = %+v", e)
+	}
+	if !allowed(t, configPath, "shop.example.com") {
+		t.Fatal("entry not added to the allowlist")
+	}
+
+	again, err := s.Add("SHOP.example.com", "default", "inline", "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != e.ID || !again.ExpiresAt.After(e.ExpiresAt) {
+		t.Errorf("adding an active entry again = %+v, want %s extended", again, e.ID)
+	}
+	if _, err := s.Add("always.example.com", "default", "", "", time.Hour); !errors.Is(err, ErrAlreadyAllowed) {
+		t.Errorf("permanent entry error = %v, want ErrAlreadyAllowed", err)
+	}
+	if _, err := s.Add("x.example.com", "default", "", "", time.Second); err == nil {
+		t.Error("duration below the minimum accepted")
+	}
+
+	// A new process finds the entry and reverts it once it is due.
+	s, err = Open(storePath, configPath, "blocky", blocky.RetentionPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := s.List(); len(list) != 1 || list[0].ID != e.ID {
+		t.Fatalf("reopened store = %+v", list)
+	}
+	if next := s.expire(time.Now()); !next.Equal(again.ExpiresAt) {
+		t.Errorf("next expiry = %v, want %v", next, again.ExpiresAt)
+	}
+	if !allowed(t, configPath, "shop.example.com") {
+		t.Error("entry reverted before it expired")
+	}
+	if next := s.expire(again.ExpiresAt.Add(time.Second)); !next.IsZero() {
+		t.Errorf("next expiry = %v, want none", next)
+	}
+	if allowed(t, configPath, "shop.example.com") || !allowed(t, configPath, "always.example.com") {
+		t.Errorf("config after expiry:\n%s", mustRead(t, configPath))
+	}
+	if list := s.List(); len(list) != 0 {
+		t.Errorf("entries after expiry = %+v", list)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	configPath, storePath := setup(t)
+	s, err := Open(storePath, configPath, "blocky", blocky.RetentionPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := s.Add("*.cdn.example.com", "kids", "", "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := s.Add("pay.example.com", "kids", "", "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Cancel(e.ID); err != nil {
+		t.Fatal(err)
+	}
+	if allowed(t, configPath, "*.cdn.example.com") || !allowed(t, configPath, "pay.example.com") {
+		t.Errorf("config after cancel:\n%s", mustRead(t, configPath))
+	}
+	if _, err := s.Cancel(e.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second cancel error = %v, want ErrNotFound", err)
+	}
+
+	// An entry removed by hand in the meantime still counts as reverted.
+	if _, err := blocky.RemoveListEntry(configPath, blocky.Allowlist, "kids", "pay.example.com", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Cancel(other.ID); err != nil {
+		t.Errorf("cancel of an entry removed by hand: %v", err)
+	}
+}
+
+func TestExpireRetriesFailedSave(t *testing.T) {
+	configPath, storePath := setup(t)
+	s, err := Open(storePath, configPath, "blocky", blocky.RetentionPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := s.Add("shop.example.com", "default", "", "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A store path below a regular file cannot be written.
+	s.path = filepath.Join(configPath, "temp-allowlist.json")
+	now := e.ExpiresAt.Add(time.Second)
+	if next := s.expire(now); !next.Equal(now.Add(retryDelay)) {
+		t.Errorf("next expiry after a failed save = %v, want %v", next, now.Add(retryDelay))
+	}
+	if allowed(t, configPath, "shop.example.com") {
+		t.Error("entry still on the allowlist")
+	}
+
+	s.path = storePath
+	if next := s.expire(now.Add(retryDelay)); !next.IsZero() {
+		t.Errorf("next expiry after retry = %v, want none", next)
+	}
+	if list := s.List(); len(list) != 0 {
+		t.Errorf("entries after retry = %+v", list)
+	}
+}
+
+func TestExpireRetriesFailedApply(t *testing.T) {
+	fail := true
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if fail {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	configPath, storePath := setup(t)
+	ports := "ports:\n  http: " + strings.TrimPrefix(srv.URL, "http://") + "\n"
+	if err := os.WriteFile(configPath, []byte(config+ports), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Open(storePath, configPath, "blocky", blocky.RetentionPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := s.Add("shop.example.com", "default", "", blocky.ApplyReload, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := e.ExpiresAt.Add(time.Second)
+	if next := s.expire(now); !next.Equal(now.Add(retryDelay)) {
+		t.Errorf("next after a failed apply = %v, want %v", next, now.Add(retryDelay))
+	}
+	if calls != 1 || allowed(t, configPath, "shop.example.com") {
+		t.Fatalf("calls = %d, entry allowed = %v; want 1 failed apply of the revert", calls, allowed(t, configPath, "shop.example.com"))
+	}
+	if next := s.expire(now.Add(time.Second)); calls != 1 || !next.Equal(now.Add(retryDelay)) {
+		t.Errorf("apply retried early: calls = %d, next = %v", calls, next)
+	}
+
+	fail = false
+	if next := s.expire(now.Add(retryDelay)); !next.IsZero() || calls != 2 {
+		t.Errorf("after retry: next = %v, calls = %d; want none, 2", next, calls)
+	}
+}
+
+func TestAddExtendRestoresRemovedEntry(t *testing.T) {
+	configPath, storePath := setup(t)
+	s, err := Open(storePath, configPath, "blocky", blocky.RetentionPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Add("shop.example.com", "default", "", "", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blocky.RemoveListEntry(configPath, blocky.Allowlist, "default", "shop.example.com", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Add("shop.example.com", "default", "", "", 2*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if !allowed(t, configPath, "shop.example.com") {
+		t.Error("extending an entry removed by hand did not put it back")
+	}
+}
+
+func mustRead(t *testing.T, path string) string {
+	t.Helper()
+	data, _ := os.ReadFile(path)
+	return string(data)
+}
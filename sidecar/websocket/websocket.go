@@ -0,0 +1,344 @@
+// Package websocket implements the server side of the WebSocket protocol
+// (RFC 6455): the opening handshake, framing, fragmentation, ping/pong and
+// the closing handshake. Extensions such as compression are not supported.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Message types.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+
+	opContinuation = 0
+	opClose        = 8
+	opPing         = 9
+	opPong         = 10
+)
+
+// Close status codes.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseTooBig          = 1009
+)
+
+// DefaultMaxMessageSize limits incoming messages unless changed with
+// SetMaxMessageSize.
+const DefaultMaxMessageSize = 64 << 10
+
+// CloseError is returned by ReadMessage once the connection is closing,
+// either because the peer sent a close frame or because it broke the
+// protocol.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket closed: %d %s", e.Code, e.Reason)
+}
+
+// AcceptKey computes the Sec-WebSocket-Accept value for a client's
+// Sec-WebSocket-Key.
+func AcceptKey(key string) string {
+	h := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// Subprotocols returns the protocols offered in Sec-WebSocket-Protocol.
+func Subprotocols(r *http.Request) []string {
+	var protocols []string
+	for _, h := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(h, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				protocols = append(protocols, p)
+			}
+		}
+	}
+	return protocols
+}
+
+func headerContains(r *http.Request, name, token string) bool {
+	for _, h := range r.Header.Values(name) {
+		for _, v := range strings.Split(h, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Upgrade completes the opening handshake and takes over the connection.
+// protocol is echoed as the selected subprotocol if not empty. On failure
+// an error response has been written.
+func Upgrade(w http.ResponseWriter, r *http.Request, protocol string) (*Conn, error) {
+	fail := func(code int, msg string) (*Conn, error) {
+		http.Error(w, msg, code)
+		return nil, errors.New("websocket: " + msg)
+	}
+	if r.Method != http.MethodGet {
+		return fail(http.StatusMethodNotAllowed, "handshake requires GET")
+	}
+	if !headerContains(r, "Connection", "upgrade") || !headerContains(r, "Upgrade", "websocket") {
+		return fail(http.StatusBadRequest, "not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return fail(http.StatusUpgradeRequired, "unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return fail(http.StatusBadRequest, "invalid Sec-WebSocket-Key")
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return fail(http.StatusInternalServerError, "connection cannot be hijacked")
+	}
+	nc, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("websocket: hijack: %w", err)
+	}
+	// The server may have set deadlines for the HTTP request.
+	nc.SetDeadline(time.Time{})
+
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + AcceptKey(key) + "\r\n"
+	if protocol != "" {
+		resp += "Sec-WebSocket-Protocol: " + protocol + "\r\n"
+	}
+	if _, err := io.WriteString(nc, resp+"\r\n"); err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("websocket: write handshake: %w", err)
+	}
+	return newConn(nc, rw.Reader), nil
+}
+
+// Conn is a server-side WebSocket connection. ReadMessage must be called
+// from a single goroutine; writes may come from any goroutine.
+type Conn struct {
+	nc      net.Conn
+	br      *bufio.Reader
+	maxSize int64
+	idle    time.Duration
+
+	wmu       sync.Mutex
+	closeSent bool
+}
+
+func newConn(nc net.Conn, br *bufio.Reader) *Conn {
+	if br == nil {
+		br = bufio.NewReader(nc)
+	}
+	return &Conn{nc: nc, br: br, maxSize: DefaultMaxMessageSize}
+}
+
+// SetMaxMessageSize limits the size of incoming messages. Larger messages
+// close the connection with CloseTooBig.
+func (c *Conn) SetMaxMessageSize(n int64) { c.maxSize = n }
+
+// SetIdleTimeout makes ReadMessage fail if no frame, including pongs,
+// arrives within d. Zero disables the timeout.
+func (c *Conn) SetIdleTimeout(d time.Duration) { c.idle = d }
+
+// ReadMessage returns the next text or binary message. Pings are answered
+// and pongs skipped. After a close frame or a protocol error it returns a
+// *CloseError and the caller should Close the connection.
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	var msg []byte
+	msgType := 0
+	for {
+		if c.idle > 0 {
+			c.nc.SetReadDeadline(time.Now().Add(c.idle))
+		}
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch op {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			ce := &CloseError{Code: 1005} // no status received
+			if len(payload) >= 2 {
+				ce.Code = int(binary.BigEndian.Uint16(payload))
+				ce.Reason = string(payload[2:])
+			}
+			code := ce.Code
+			if code == 1005 {
+				code = CloseNormal
+			}
+			c.sendClose(code, "")
+			return 0, nil, ce
+		case TextMessage, BinaryMessage:
+			if msgType != 0 {
+				return 0, nil, c.fail(CloseProtocolError, "expected continuation frame")
+			}
+			msgType = op
+		case opContinuation:
+			if msgType == 0 {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+		default:
+			return 0, nil, c.fail(CloseProtocolError, "unknown opcode")
+		}
+
+		if int64(len(msg)+len(payload)) > c.maxSize {
+			return 0, nil, c.fail(CloseTooBig, "message too big")
+		}
+		msg = append(msg, payload...)
+		if fin {
+			if msgType == TextMessage && !utf8.Valid(msg) {
+				return 0, nil, c.fail(CloseInvalidPayload, "invalid UTF-8")
+			}
+			return msgType, msg, nil
+		}
+	}
+}
+
+// readFrame reads one frame and unmasks its payload.
+func (c *Conn) readFrame() (fin bool, op int, payload []byte, err error) {
+	var h [2]byte
+	if _, err := io.ReadFull(c.br, h[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = h[0]&0x80 != 0
+	op = int(h[0] & 0x0f)
+	if h[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "reserved bits set")
+	}
+	if h[1]&0x80 == 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "client frames must be masked")
+	}
+
+	n := int64(h[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+	if op >= opClose && (n > 125 || !fin) {
+		return false, 0, nil, c.fail(CloseProtocolError, "invalid control frame")
+	}
+	if n < 0 || n > c.maxSize {
+		return false, 0, nil, c.fail(CloseTooBig, "message too big")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// fail starts the closing handshake after a protocol violation.
+func (c *Conn) fail(code int, reason string) error {
+	c.sendClose(code, reason)
+	return &CloseError{Code: code, Reason: reason}
+}
+
+// WriteMessage sends a single unfragmented message.
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	return c.writeFrame(messageType, data)
+}
+
+// Ping sends a ping; the peer's pong resets the idle timeout.
+func (c *Conn) Ping() error {
+	return c.writeFrame(opPing, nil)
+}
+
+func (c *Conn) writeFrame(op int, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return net.ErrClosed
+	}
+	return c.writeFrameLocked(op, payload)
+}
+
+// writeFrameLocked writes one final, unmasked frame. Caller holds c.wmu.
+func (c *Conn) writeFrameLocked(op int, payload []byte) error {
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | byte(op)
+	switch n := len(payload); {
+	case n <= 125:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	c.nc.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := (&net.Buffers{header, payload}).WriteTo(c.nc)
+	return err
+}
+
+// sendClose sends a close frame once.
+func (c *Conn) sendClose(code int, reason string) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return
+	}
+	c.closeSent = true
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	c.writeFrameLocked(opClose, append(payload, reason...))
+}
+
+// Close sends a close frame with code and reason, if none was sent yet,
+// and closes the connection.
+func (c *Conn) Close(code int, reason string) error {
+	c.sendClose(code, reason)
+	return c.nc.Close()
+}
@@ -0,0 +1,177 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// Example from RFC 6455 section 1.3.
+	if got := AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("AcceptKey = %q, want s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", got)
+	}
+}
+
+// dial performs a client handshake against srv and returns the raw
+// connection and the response.
+func dial(t *testing.T, srv *httptest.Server, protocols string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	nc, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { nc.Close() })
+	req := "GET / HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n"
+	if protocols != "" {
+		req += "Sec-WebSocket-Protocol: " + protocols + "\r\n"
+	}
+	if _, err := io.WriteString(nc, req+"\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(nc)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nc, br, resp
+}
+
+// writeClientFrame writes a masked frame as a browser would.
+func writeClientFrame(t *testing.T, w io.Writer, fin bool, op int, payload []byte, masked bool) {
+	t.Helper()
+	b0 := byte(op)
+	if fin {
+		b0 |= 0x80
+	}
+	frame := []byte{b0, 0}
+	switch n := len(payload); {
+	case n <= 125:
+		frame[1] = byte(n)
+	default:
+		frame[1] = 126
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	}
+	if masked {
+		frame[1] |= 0x80
+		mask := []byte{0x12, 0x34, 0x56, 0x78}
+		frame = append(frame, mask...)
+		for i, c := range payload {
+			frame = append(frame, c^mask[i%4])
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	if _, err := w.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readServerFrame(t *testing.T, r io.Reader) (int, []byte) {
+	t.Helper()
+	var h [2]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		t.Fatal(err)
+	}
+	if h[1]&0x80 != 0 {
+		t.Fatal("server frame is masked")
+	}
+	n := int(h[1] & 0x7f)
+	if n == 126 {
+		var ext [2]byte
+		io.ReadFull(r, ext[:])
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatal(err)
+	}
+	return int(h[0] & 0x0f), payload
+}
+
+func echoServer(t *testing.T, errs chan<- error) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r, "echo")
+		if err != nil {
+			return
+		}
+		defer conn.Close(CloseNormal, "")
+		for {
+			op, msg, err := conn.ReadMessage()
+			if err != nil {
+				errs <- err
+				return
+			}
+			conn.WriteMessage(op, msg)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestEcho(t *testing.T) {
+	errs := make(chan error, 1)
+	srv := echoServer(t, errs)
+	nc, br, resp := dial(t, srv, "echo")
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want 101", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Sec-WebSocket-Accept = %q", got)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Protocol"); got != "echo" {
+		t.Errorf("Sec-WebSocket-Protocol = %q, want echo", got)
+	}
+
+	// A fragmented message with a ping between the fragments.
+	writeClientFrame(t, nc, false, TextMessage, []byte("hello "), true)
+	writeClientFrame(t, nc, true, opPing, []byte("p"), true)
+	writeClientFrame(t, nc, true, opContinuation, []byte(strings.Repeat("x", 200)), true)
+
+	if op, payload := readServerFrame(t, br); op != opPong || string(payload) != "p" {
+		t.Errorf("got op %d %q, want pong \"p\"", op, payload)
+	}
+	if op, payload := readServerFrame(t, br); op != TextMessage || string(payload) != "hello "+strings.Repeat("x", 200) {
+		t.Errorf("got op %d %q, want echoed text", op, payload)
+	}
+
+	writeClientFrame(t, nc, true, opClose, binary.BigEndian.AppendUint16(nil, CloseGoingAway), true)
+	if op, payload := readServerFrame(t, br); op != opClose || binary.BigEndian.Uint16(payload) != CloseGoingAway {
+		t.Errorf("got op %d %v, want close 1001", op, payload)
+	}
+	var ce *CloseError
+	if err := <-errs; !errors.As(err, &ce) || ce.Code != CloseGoingAway {
+		t.Errorf("ReadMessage error = %v, want close 1001", err)
+	}
+}
+
+func TestUnmaskedFrameRejected(t *testing.T) {
+	errs := make(chan error, 1)
+	srv := echoServer(t, errs)
+	nc, br, _ := dial(t, srv, "")
+
+	writeClientFrame(t, nc, true, TextMessage, []byte("hi"), false)
+	if op, payload := readServerFrame(t, br); op != opClose || binary.BigEndian.Uint16(payload) != CloseProtocolError {
+		t.Errorf("got op %d %v, want close 1002", op, payload)
+	}
+	<-errs
+}
+
+func TestUpgradeRejectsPlainRequest(t *testing.T) {
+	srv := echoServer(t, make(chan error, 1))
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", resp.StatusCode)
+	}
+}
@@ -0,0 +1,73 @@
+import { sidecarRequest, sidecarResponse } from "./sidecar";
+import type {
+  SidecarClientGroupMatch,
+  SidecarClientGroups,
+} from "$lib/types/api";
+
+export interface ClientGroupsResult {
+  status: "saved" | "removed" | "unchanged";
+  client: string;
+  groups?: string[];
+  etag: string;
+  backup?: string;
+  applied?: string;
+  apply_error?: string;
+}
+
+export async function fetchClientGroups(): Promise<
+  SidecarClientGroups & { etag: string }
+> {
+  const response = await sidecarResponse("/api/clients/groups");
+  return {
+    ...((await response.json()) as SidecarClientGroups),
+    etag: response.headers.get("etag") ?? "",
+  };
+}
+
+// setClientGroups moves a client (IP, name or CIDR) to the given denylist
+// groups, which must not be empty; use removeClientGroups to put it back on
+// the default ones. Blocky only reads clientGroupsBlock on start, so pass
+// restart to apply it right away. etag comes from fetchClientGroups; a 412
+// ApiError means the config changed since.
+export async function setClientGroups(
+  client: string,
+  groups: string[],
+  etag: string,
+  restart = false,
+): Promise<ClientGroupsResult> {
+  return sidecarRequest<ClientGroupsResult>("/api/clients/groups", {
+    method: "PUT",
+    headers: { "If-Match": etag },
+    body: JSON.stringify({
+      client,
+      groups,
+      ...(restart ? { apply: "restart" } : {}),
+    }),
+  });
+}
+
+export async function removeClientGroups(
+  client: string,
+  etag: string,
+  restart = false,
+): Promise<ClientGroupsResult> {
+  const params = new URLSearchParams({ client });
+  if (restart) params.set("apply", "restart");
+  return sidecarRequest<ClientGroupsResult>(`/api/clients/groups?${params}`, {
+    method: "DELETE",
+    headers: { "If-Match": etag },
+  });
+}
+
+// matchClientGroups shows which groups apply to a client, e.g. one from
+// StatsResponse.clients. The sidecar looks the name up if none is given.
+export async function matchClientGroups(
+  ip: string,
+  name?: string,
+): Promise<SidecarClientGroupMatch> {
+  const params = new URLSearchParams({ ip });
+  if (name) params.set("name", name);
+  return sidecarRequest<SidecarClientGroupMatch>(
+    `/api/clients/groups/match?${params}`,
+  );
+}
@@ -1,15 +1,122 @@
-import { sidecarRequest } from "./sidecar";
+import { sidecarRequest, sidecarResponse } from "./sidecar";
+import type {
+  SidecarConfigBackup,
+  SidecarConfigDiff,
+  SidecarConfigIssue,
+} from "$lib/types/api";
 
-export async function fetchConfig(): Promise<string> {
-  return sidecarRequest<string>("/api/config");
+export interface ConfigFile {
+  content: string;
+  etag: string;
 }
 
+export async function fetchConfig(): Promise<ConfigFile> {
+  const response = await sidecarResponse("/api/config");
+  return {
+    content: await response.text(),
+    etag: response.headers.get("etag") ?? "",
+  };
+}
+
+export interface SaveConfigResult {
+  status: string;
+  backup: string;
+  etag: string;
+  warnings: SidecarConfigIssue[];
+}
+
+// saveConfig fails with a 412 ApiError if the config changed on the
+// sidecar since etag was fetched.
 export async function saveConfig(
   yaml: string,
-): Promise<{ status: string; backup: string }> {
-  return sidecarRequest<{ status: string; backup: string }>("/api/config", {
+  etag: string,
+): Promise<SaveConfigResult> {
+  return sidecarRequest<SaveConfigResult>("/api/config", {
     method: "PUT",
     body: yaml,
+    headers: { "Content-Type": "text/plain", "If-Match": etag },
+  });
+}
+
+export async function diffConfig(yaml: string): Promise<SidecarConfigDiff> {
+  return sidecarRequest<SidecarConfigDiff>("/api/config/diff", {
+    method: "POST",
+    body: yaml,
     headers: { "Content-Type": "text/plain" },
   });
 }
+
+export async function fetchBackups(): Promise<SidecarConfigBackup[]> {
+  return sidecarRequest<SidecarConfigBackup[]>("/api/config/backups");
+}
+
+export async function fetchBackup(id: string): Promise<string> {
+  return sidecarRequest<string>(
+    `/api/config/backups/${encodeURIComponent(id)}`,
+  );
+}
+
+export async function diffBackup(id: string): Promise<SidecarConfigDiff> {
+  return sidecarRequest<SidecarConfigDiff>(
+    `/api/config/backups/${encodeURIComponent(id)}/diff`,
+  );
+}
+
+export interface RestoreBackupResult {
+  status: string;
+  restored: string;
+  backup: string;
+  etag: string;
+  warnings: SidecarConfigIssue[];
+}
+
+// restoreBackup fails with a 412 ApiError if the config changed on the
+// sidecar since etag was fetched.
+export async function restoreBackup(
+  id: string,
+  etag: string,
+): Promise<RestoreBackupResult> {
+  return sidecarRequest<RestoreBackupResult>(
+    `/api/config/backups/${encodeURIComponent(id)}/restore`,
+    { method: "POST", headers: { "If-Match": etag } },
+  );
+}
+
+export interface ConfigSection<T = unknown> {
+  value: T;
+  etag: string;
+}
+
+export async function fetchSection<T = unknown>(
+  path: string,
+): Promise<ConfigSection<T>> {
+  const response = await sidecarResponse(
+    `/api/config/sections/${encodeURIComponent(path)}`,
+  );
+  return {
+    value: (await response.json()) as T,
+    etag: response.headers.get("etag") ?? "",
+  };
+}
+
+export interface PatchSectionResult<T = unknown> extends SaveConfigResult {
+  value: T;
+}
+
+// patchSection applies a JSON merge patch to one config section: objects
+// are merged, null removes a key and other values replace the section. It
+// fails with a 412 ApiError if the config changed since etag was fetched.
+export async function patchSection<T = unknown>(
+  path: string,
+  patch: unknown,
+  etag: string,
+): Promise<PatchSectionResult<T>> {
+  return sidecarRequest<PatchSectionResult<T>>(
+    `/api/config/sections/${encodeURIComponent(path)}`,
+    {
+      method: "PATCH",
+      body: JSON.stringify(patch),
+      headers: { "If-Match": etag },
+    },
+  );
+}
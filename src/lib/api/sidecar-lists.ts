@@ -0,0 +1,96 @@
+import { sidecarRequest } from "./sidecar";
+import type { SidecarLists, SidecarTempAllow } from "$lib/types/api";
+
+export type ListKind = "allow" | "deny";
+
+// "reload" refreshes Blocky's lists and only picks up list file changes;
+// inline lists live in the config and need "restart".
+export type ListApply = "restart" | "reload";
+
+export interface ListEntryOptions {
+  source?: string;
+  apply?: ListApply;
+}
+
+export interface ListEntryResult {
+  // "partial" comes with an error status when a removal failed after some
+  // sources were already edited; those edits are saved and applied.
+  status: "added" | "exists" | "removed" | "partial";
+  source?: string;
+  backup?: string;
+  edits?: { changed: boolean; source: string; backup?: string }[];
+  error?: string;
+  applied?: string;
+  apply_error?: string;
+}
+
+export async function fetchLists(): Promise<SidecarLists> {
+  return sidecarRequest<SidecarLists>("/api/lists");
+}
+
+export async function addListEntry(
+  kind: ListKind,
+  group: string,
+  entry: string,
+  options: ListEntryOptions = {},
+): Promise<ListEntryResult> {
+  return sidecarRequest<ListEntryResult>(
+    `/api/lists/${kind}/${encodeURIComponent(group)}`,
+    {
+      method: "POST",
+      body: JSON.stringify({ entry, ...options }),
+    },
+  );
+}
+
+export async function removeListEntry(
+  kind: ListKind,
+  group: string,
+  entry: string,
+  options: ListEntryOptions = {},
+): Promise<ListEntryResult> {
+  const params = new URLSearchParams({ entry });
+  if (options.source) params.set("source", options.source);
+  if (options.apply) params.set("apply", options.apply);
+  return sidecarRequest<ListEntryResult>(
+    `/api/lists/${kind}/${encodeURIComponent(group)}?${params}`,
+    { method: "DELETE" },
+  );
+}
+
+export async function fetchTempAllows(): Promise<SidecarTempAllow[]> {
+  return sidecarRequest<SidecarTempAllow[]>("/api/lists/temporary");
+}
+
+export interface TempAllowResult {
+  entry: SidecarTempAllow;
+  status?: string;
+  applied?: string;
+  apply_error?: string;
+}
+
+// addTempAllow allowlists entry in group for duration (e.g. "30m"); the
+// sidecar removes it again when it expires. Adding an active entry again
+// extends it.
+export async function addTempAllow(
+  entry: string,
+  group: string,
+  duration: string,
+  options: ListEntryOptions = {},
+): Promise<TempAllowResult> {
+  return sidecarRequest<TempAllowResult>("/api/lists/temporary", {
+    method: "POST",
+    body: JSON.stringify({ entry, group, duration, ...options }),
+  });
+}
+
+export async function cancelTempAllow(
+  id: string,
+  apply?: ListApply,
+): Promise<TempAllowResult> {
+  const query = apply ? `?apply=${apply}` : "";
+  return sidecarRequest<TempAllowResult>(
+    `/api/lists/temporary/${encodeURIComponent(id)}${query}`,
+    { method: "DELETE" },
+  );
+}
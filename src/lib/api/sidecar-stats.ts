@@ -27,10 +27,12 @@ export async function fetchLogs(
   params: {
     range?: StatsRange;
     limit?: number;
-    offset?: number;
+    cursor?: string;
+    q?: string;
     client?: string;
     domain?: string;
     type?: string;
+    collapse?: boolean;
   } = {},
 ): Promise<SidecarLogsResponse> {
   const searchParams = new URLSearchParams();
@@ -43,15 +45,27 @@ export async function fetchLogs(
 }
 
 export function buildLogStreamUrl(filters?: {
+  q?: string;
   client?: string;
   domain?: string;
   type?: string;
+  collapse?: boolean;
 }): string {
   const { sidecarUrl, sidecarApiKey } = settingsStore;
   const params = new URLSearchParams();
   params.set("key", sidecarApiKey);
+  if (filters?.q) params.set("q", filters.q);
   if (filters?.client) params.set("client", filters.client);
   if (filters?.domain) params.set("domain", filters.domain);
   if (filters?.type) params.set("type", filters.type);
+  if (filters?.collapse) params.set("collapse", "true");
   return `${sidecarUrl}/api/logs/stream?${params.toString()}`;
 }
+
+export function buildLiveStatsUrl(top?: number): string {
+  const { sidecarUrl, sidecarApiKey } = settingsStore;
+  const params = new URLSearchParams();
+  params.set("key", sidecarApiKey);
+  if (top) params.set("top", String(top));
+  return `${sidecarUrl}/api/stats/live?${params.toString()}`;
+}
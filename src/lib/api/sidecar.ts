@@ -23,10 +23,10 @@ async function fetchWithTimeout(
   }
 }
 
-export async function sidecarRequest<T>(
+export async function sidecarResponse(
   path: string,
   options: RequestInit & { timeout?: number } = {},
-): Promise<T> {
+): Promise<Response> {
   const { sidecarUrl, sidecarApiKey } = settingsStore;
   if (!sidecarUrl || !sidecarApiKey) {
     throw new ConnectionError("Sidecar not configured");
@@ -51,6 +51,14 @@ export async function sidecarRequest<T>(
       body,
     );
   }
+  return response;
+}
+
+export async function sidecarRequest<T>(
+  path: string,
+  options: RequestInit & { timeout?: number } = {},
+): Promise<T> {
+  const response = await sidecarResponse(path, options);
 
   const contentType = response.headers.get("content-type");
   if (contentType?.includes("application/json")) {
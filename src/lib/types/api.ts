@@ -72,6 +72,21 @@ export interface SidecarTimelineBucket {
   cached: number;
 }
 
+export interface SidecarLiveWindow {
+  queries: number;
+  qps: number;
+  blocked_rate: number;
+  cached_rate: number;
+  top_domains: { domain: string; count: number }[];
+  top_clients: { ip: string; name: string; count: number }[];
+}
+
+// Sent as "stats" events by /api/stats/live every second
+export interface SidecarLiveStats {
+  time: string;
+  windows: Record<"1m" | "5m" | "15m", SidecarLiveWindow>;
+}
+
 export interface SidecarLogEntry {
   timestamp: string;
   client_ip: string;
@@ -83,13 +98,89 @@ export interface SidecarLogEntry {
   return_code: string;
   duration_ms: number;
   response_answer: string;
+  // Set when the request asked for collapsed repeats
+  count?: number;
+  first_seen?: string;
+  last_seen?: string;
+  group?: number;
 }
 
 export interface SidecarLogsResponse {
-  total: number;
-  offset: number;
   limit: number;
   entries: SidecarLogEntry[];
+  next_cursor?: string;
+}
+
+export interface SidecarConfigIssue {
+  line: number;
+  column: number;
+  path: string;
+  severity: "error" | "warning";
+  message: string;
+}
+
+export interface SidecarConfigChange {
+  path: string;
+  type: "added" | "removed" | "changed";
+  old?: unknown;
+  new?: unknown;
+  line: number;
+}
+
+export interface SidecarConfigDiff {
+  unified: string;
+  changes: SidecarConfigChange[];
+  issues: SidecarConfigIssue[];
+}
+
+export interface SidecarListSource {
+  type: "inline" | "file" | "url";
+  source?: string;
+  path?: string;
+  entries?: string[];
+}
+
+export interface SidecarLists {
+  allowlists: Record<string, SidecarListSource[]>;
+  denylists: Record<string, SidecarListSource[]>;
+}
+
+export interface SidecarTempAllow {
+  id: string;
+  entry: string;
+  group: string;
+  source: string;
+  apply?: string;
+  created_at: string;
+  expires_at: string;
+}
+
+export interface SidecarClientGroup {
+  client: string;
+  groups: string[];
+}
+
+export interface SidecarClientGroups {
+  clients: SidecarClientGroup[];
+  groups: string[];
+}
+
+// SidecarClientGroupMatch is the denylist groups Blocky applies to a client.
+// default is set when the matched entries give no groups and the "default"
+// entry applies, as in Blocky.
+export interface SidecarClientGroupMatch {
+  ip: string;
+  names: string[];
+  groups: string[];
+  matches: SidecarClientGroup[];
+  default: boolean;
+}
+
+export interface SidecarConfigBackup {
+  id: string;
+  name: string;
+  time: string;
+  size: number;
 }
 
 export interface SidecarServiceStatus {